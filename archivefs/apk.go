@@ -0,0 +1,40 @@
+package archivefs
+
+import "io/fs"
+import "bufio"
+import "errors"
+import "strings"
+import "github.com/ajblkf/microscope/pkgscan"
+
+// readAPKInfo reads the package described by the .PKGINFO file of an Alpine
+// package. Archives without one are left as they are.
+func (this *Archive) readAPKInfo () error {
+	file, err := this.FS.Open(".PKGINFO")
+	if errors.Is(err, fs.ErrNotExist) { return nil }
+	if err != nil { return err }
+	defer file.Close()
+
+	pkg := pkgscan.Package { Type: "apk" }
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found || strings.HasPrefix(key, "#") { continue }
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "pkgname":
+			pkg.Name = value
+		case "pkgver":
+			// versions end with a release, such as 1.2.3-r4
+			pkg.Version = value
+			if index := strings.LastIndex(value, "-r"); index >= 0 {
+				pkg.Version, pkg.Release = value[:index], value[index + 2:]
+			}
+		case "arch":
+			pkg.Arch = value
+		}
+	}
+	if scanner.Err() != nil { return scanner.Err() }
+	if pkg.Name == "" { return errors.New(".PKGINFO has no pkgname") }
+	this.Package = &pkg
+	return nil
+}
@@ -0,0 +1,298 @@
+// Package archivefs opens archives as read-only filesystems. Tar archives may
+// be compressed with gzip, xz, bzip2, zstd or lz4, and zip archives, package
+// files (.deb, .rpm and Alpine .apk) and filesystem images (ext2, ext3 and
+// ext4, squashfs and ISO 9660) are read as well. A compressed file which is
+// not an archive is opened as a filesystem holding only its content.
+package archivefs
+
+import "io"
+import "os"
+import "fmt"
+import "bytes"
+import "bufio"
+import "io/fs"
+import "errors"
+import "archive/zip"
+import "compress/gzip"
+import "compress/bzip2"
+import "github.com/ulikunitz/xz"
+import "github.com/pierrec/lz4/v4"
+import "github.com/nlepage/go-tarfs"
+import "github.com/klauspost/compress/zstd"
+import "github.com/gabriel-vasile/mimetype"
+import "github.com/ajblkf/microscope/diskfs"
+import "github.com/ajblkf/microscope/pkgscan"
+
+// Archive is an archive which has been opened as a filesystem.
+type Archive struct {
+	// The files in the archive, or in the payload of a package
+	FS      fs.FS
+	// The package described by the metadata of a package file, or nil if
+	// the archive is not a package
+	Package *pkgscan.Package
+
+	// a decompressed or converted copy of the archive
+	temporary *os.File
+	// how many bytes may be written into the copy, unless it is zero, and
+	// how many have been
+	limit     int64
+	written   int64
+}
+
+// Options changes how an archive is opened.
+type Options struct {
+	// The name of the archive, which is used to name the file inside of a
+	// compressed file which is not an archive, such as a .gz file. By
+	// default, it is the name of the opened file.
+	Name    string
+	// How many bytes may be decompressed or converted into temporary
+	// files, unless it is zero. Archives which need more fail to open with
+	// ErrTooLarge.
+	MaxSize int64
+}
+
+// ErrTooLarge is returned when an archive cannot be opened within the size it
+// is allowed to take up once decompressed.
+var ErrTooLarge = errors.New("too large once decompressed")
+
+// lz4Magic starts every lz4 frame, which mimetype does not detect on its own.
+var lz4Magic = []byte { 0x04, 0x22, 0x4d, 0x18 }
+
+func init () {
+	mimetype.Extend (
+		func (raw []byte, limit uint32) bool { return bytes.HasPrefix(raw, lz4Magic) },
+		"application/x-lz4", ".lz4")
+}
+
+// compressedTypes lists the compressed files which are decompressed.
+var compressedTypes = []string {
+	"application/gzip",
+	"application/x-xz",
+	"application/x-bzip2",
+	"application/zstd",
+	"application/x-lz4",
+}
+
+// HeaderSize is how much of the start of a file IsArchive needs to tell every
+// type of archive apart.
+const HeaderSize = diskfs.DetectSize
+
+// IsArchive returns whether a file which starts with header is of a type which
+// can be opened.
+func IsArchive (header []byte) bool {
+	if diskfs.Detect(bytes.NewReader(header)) != "" { return true }
+	mime := mimetype.Detect(header)
+	if isType(mime, "application/zip") { return true }
+	for _, kind := range append ([]string {
+		"application/vnd.debian.binary-package",
+		"application/x-rpm",
+		"application/x-tar",
+	}, compressedTypes...) {
+		if mime.Is(kind) { return true }
+	}
+	return false
+}
+
+// Open opens an archive, which must stay open until the archive is closed.
+// The type of the archive is detected from its contents.
+func Open (file *os.File) (*Archive, error) {
+	return OpenWith(file, Options { })
+}
+
+// OpenWith is like Open, but with options.
+func OpenWith (file *os.File, options Options) (*Archive, error) {
+	archive := &Archive { limit: options.MaxSize }
+	if diskfs.Detect(file) != "" {
+		info, err := file.Stat()
+		if err != nil { return nil, err }
+		archive.FS, err = diskfs.Open(file, info.Size())
+		if err != nil { return nil, err }
+		return archive, nil
+	}
+
+	mime, err := mimetype.DetectReader(file)
+	if err != nil { return nil, err }
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil { return nil, err }
+
+	switch {
+	case isType(mime, "application/zip"):
+		info, err := file.Stat()
+		if err != nil { return nil, err }
+		reader, err := zip.NewReader(file, info.Size())
+		if err != nil { return nil, err }
+		archive.FS = reader
+
+	case mime.Is("application/vnd.debian.binary-package"):
+		err = archive.openDeb(file)
+
+	case mime.Is("application/x-rpm"):
+		err = archive.openRPM(file)
+
+	case mime.Is("application/x-tar"):
+		archive.FS, err = tarfs.New(file)
+
+	default:
+		var decompressed io.Reader
+		var closer func ()
+		decompressed, closer, err = decompressor(mime, file)
+		if err != nil { return nil, err }
+		if decompressed == nil {
+			return nil, errors.New(fmt.Sprint("unknown file type ", mime))
+		}
+		defer closer()
+
+		buffered := bufio.NewReaderSize(decompressed, HeaderSize)
+		var start []byte
+		start, err = buffered.Peek(HeaderSize)
+		if err != nil && err != io.EOF { break }
+		err = nil
+		if diskfs.Detect(bytes.NewReader(start)) != "" {
+			err = archive.openImage(buffered)
+			break
+		}
+		if !mimetype.Detect(start).Is("application/x-tar") {
+			name := options.Name
+			if name == "" { name = file.Name() }
+			err = archive.openFile(buffered, name)
+			break
+		}
+		err = archive.openTar(buffered)
+		// Alpine packages are gzipped tar archives, which are told
+		// apart by their metadata
+		if err == nil { err = archive.readAPKInfo() }
+	}
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Written returns how many bytes were decompressed or converted into temporary
+// files to open the archive.
+func (this *Archive) Written () int64 {
+	return this.written
+}
+
+// Close removes the temporary files used by the archive. Its filesystem stops
+// working once it is closed.
+func (this *Archive) Close () error {
+	if this.temporary == nil { return nil }
+	err := this.temporary.Close()
+	os.Remove(this.temporary.Name())
+	this.temporary = nil
+	return err
+}
+
+// openTar spools a tar archive which cannot be read at random, such as one
+// being decompressed, into a temporary file, and opens it.
+func (this *Archive) openTar (reader io.Reader) error {
+	return this.spool(func (output io.Writer) error {
+		_, err := io.Copy(output, reader)
+		return err
+	})
+}
+
+// openImage copies a filesystem image which cannot be read at random, such as
+// one being decompressed, into a temporary file, and opens it.
+func (this *Archive) openImage (reader io.Reader) error {
+	temporary, err := this.createTemporary(func (output io.Writer) error {
+		_, err := io.Copy(output, reader)
+		return err
+	})
+	if err != nil { return err }
+	this.FS, err = diskfs.Open(temporary, this.written)
+	return err
+}
+
+// spool writes a tar archive into a temporary file, and opens it.
+func (this *Archive) spool (write func (output io.Writer) error) error {
+	temporary, err := this.createTemporary(write)
+	if err != nil { return err }
+	this.FS, err = tarfs.New(temporary)
+	return err
+}
+
+// createTemporary writes the temporary copy of the archive, and returns it.
+// Where the system allows it, the file is removed as soon as it is created, so
+// that it is not left behind if the program is killed.
+func (this *Archive) createTemporary (write func (output io.Writer) error) (*os.File, error) {
+	temporary, err := os.CreateTemp("", "microscope_archive_*")
+	if err != nil { return nil, err }
+	os.Remove(temporary.Name())
+	this.temporary = temporary
+
+	buffered := bufio.NewWriter(&limitedWriter { Writer: temporary, archive: this })
+	err = write(buffered)
+	if err != nil { return nil, err }
+	err = buffered.Flush()
+	if err != nil { return nil, err }
+	_, err = temporary.Seek(0, io.SeekStart)
+	if err != nil { return nil, err }
+	return temporary, nil
+}
+
+// limitedWriter writes into the temporary copy of an archive, until it has
+// reached the size it is limited to.
+type limitedWriter struct {
+	io.Writer
+	archive *Archive
+}
+
+func (this *limitedWriter) Write (buffer []byte) (int, error) {
+	archive := this.archive
+	if archive.limit > 0 && archive.written + int64(len(buffer)) > archive.limit {
+		return 0, ErrTooLarge
+	}
+	count, err := this.Writer.Write(buffer)
+	archive.written += int64(count)
+	return count, err
+}
+
+// decompress returns a reader which decompresses a stream whose format is
+// detected from its start, along with a function which releases it. Streams
+// which are not compressed are returned as they are.
+func decompress (reader io.Reader) (io.Reader, func (), error) {
+	buffered := bufio.NewReaderSize(reader, 3072)
+	start, err := buffered.Peek(3072)
+	if err != nil && err != io.EOF { return nil, nil, err }
+	decompressed, closer, err := decompressor(mimetype.Detect(start), buffered)
+	if err != nil { return nil, nil, err }
+	if decompressed == nil { return buffered, func () { }, nil }
+	return decompressed, closer, nil
+}
+
+// decompressor returns a reader which decompresses a stream of a type, along
+// with a function which releases it, or nil if the type is not compressed.
+func decompressor (mime *mimetype.MIME, reader io.Reader) (io.Reader, func (), error) {
+	none := func () { }
+	switch {
+	case mime.Is("application/gzip"):
+		decompressed, err := gzip.NewReader(reader)
+		if err != nil { return nil, nil, err }
+		return decompressed, none, nil
+	case mime.Is("application/x-xz"):
+		decompressed, err := xz.NewReader(reader)
+		if err != nil { return nil, nil, err }
+		return decompressed, none, nil
+	case mime.Is("application/x-bzip2"):
+		return bzip2.NewReader(reader), none, nil
+	case mime.Is("application/zstd"):
+		decompressed, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil { return nil, nil, err }
+		return decompressed, decompressed.Close, nil
+	case mime.Is("application/x-lz4"):
+		return lz4.NewReader(reader), none, nil
+	}
+	return nil, none, nil
+}
+
+// isType returns whether a type is, or is a subtype of, another type, such as
+// a jar file being a zip archive.
+func isType (mime *mimetype.MIME, kind string) bool {
+	for ; mime != nil; mime = mime.Parent() {
+		if mime.Is(kind) { return true }
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package archivefs
+
+import "os"
+import "fmt"
+import "bytes"
+import "io/fs"
+import "strings"
+import "testing"
+import "archive/tar"
+import "path/filepath"
+import "compress/gzip"
+import "encoding/binary"
+import "github.com/ulikunitz/xz"
+import "github.com/ajblkf/microscope/pkgscan"
+
+type testFile struct {
+	name    string
+	content string
+}
+
+func testTar (test *testing.T, files ...testFile) []byte {
+	buffer := bytes.Buffer { }
+	writer := tar.NewWriter(&buffer)
+	for _, file := range files {
+		err := writer.WriteHeader(&tar.Header {
+			Name:     file.name,
+			Mode:     0644,
+			Size:     int64(len(file.content)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil { test.Fatal(err) }
+		writer.Write([]byte(file.content))
+	}
+	err := writer.Close()
+	if err != nil { test.Fatal(err) }
+	return buffer.Bytes()
+}
+
+func testGzip (test *testing.T, data []byte) []byte {
+	buffer := bytes.Buffer { }
+	writer := gzip.NewWriter(&buffer)
+	writer.Write(data)
+	err := writer.Close()
+	if err != nil { test.Fatal(err) }
+	return buffer.Bytes()
+}
+
+func testXz (test *testing.T, data []byte) []byte {
+	buffer := bytes.Buffer { }
+	writer, err := xz.NewWriter(&buffer)
+	if err != nil { test.Fatal(err) }
+	writer.Write(data)
+	err = writer.Close()
+	if err != nil { test.Fatal(err) }
+	return buffer.Bytes()
+}
+
+// testAr returns an ar archive holding members, given as pairs of names and
+// contents.
+func testAr (members ...any) []byte {
+	buffer := bytes.NewBufferString("!<arch>\n")
+	for index := 0; index < len(members); index += 2 {
+		data := members[index + 1].([]byte)
+		fmt.Fprintf(buffer, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", members[index], 0, 0, 0, "100644", len(data))
+		buffer.Write(data)
+		if len(data) % 2 == 1 { buffer.WriteByte('\n') }
+	}
+	return buffer.Bytes()
+}
+
+// openTestArchive writes an archive into a file, and opens it.
+func openTestArchive (test *testing.T, data []byte) (*Archive, error) {
+	name := filepath.Join(test.TempDir(), "archive")
+	err := os.WriteFile(name, data, 0644)
+	if err != nil { test.Fatal(err) }
+	file, err := os.Open(name)
+	if err != nil { test.Fatal(err) }
+	test.Cleanup(func () { file.Close() })
+	archive, err := Open(file)
+	if err == nil { test.Cleanup(func () { archive.Close() }) }
+	return archive, err
+}
+
+// checkArchive checks the package of an archive, and the contents of files in
+// it.
+func checkArchive (test *testing.T, name string, archive *Archive, expected pkgscan.Package, files ...testFile) {
+	if archive.Package == nil || *archive.Package != expected {
+		test.Errorf("%v: expected the package %+v, got %+v", name, expected, archive.Package)
+	}
+	for _, file := range files {
+		content, err := fs.ReadFile(archive.FS, file.name)
+		if err != nil {
+			test.Errorf("%v: %v", name, err)
+			continue
+		}
+		if string(content) != file.content {
+			test.Errorf("%v: %v holds %q instead of %q", name, file.name, content, file.content)
+		}
+	}
+}
+
+func testDeb (test *testing.T, control string, data []byte) []byte {
+	return testAr (
+		"debian-binary",  []byte("2.0\n"),
+		"control.tar.gz", testGzip(test, testTar(test, testFile { "./control", control })),
+		"data.tar.xz",    data)
+}
+
+func TestDeb (test *testing.T) {
+	control := "Package: tool\nVersion: 1:2.0-3ubuntu1\nArchitecture: amd64\n" +
+		"Description: a tool\n with a description: on two lines\n"
+	data := testXz(test, testTar(test, testFile { "./usr/bin/tool", "binary" }, testFile { "./etc/tool.conf", "odd" }))
+	archive, err := openTestArchive(test, testDeb(test, control, data))
+	if err != nil { test.Fatal(err) }
+	checkArchive (
+		test, "deb", archive,
+		pkgscan.Package { Type: "deb", Name: "tool", Epoch: "1", Version: "2.0", Release: "3ubuntu1", Arch: "amd64" },
+		testFile { "usr/bin/tool", "binary" }, testFile { "etc/tool.conf", "odd" })
+}
+
+func TestDebFailures (test *testing.T) {
+	data := testGzip(test, testTar(test, testFile { "./usr/bin/tool", "binary" }))
+	control := "Package: tool\nVersion: 2.0\n"
+	invalid := testDeb(test, control, data)
+	copy(invalid[8 + 58:], "xx")
+	cases := []struct {
+		name     string
+		data     []byte
+		expected string
+	} {
+		{
+			name:     "no data archive",
+			data:     testAr("debian-binary", []byte("2.0\n"), "control.tar", testTar(test, testFile { "control", control })),
+			expected: "control.tar or data.tar is missing",
+		}, {
+			name:     "no package name",
+			data:     testDeb(test, "Version: 2.0\n", data),
+			expected: "control file has no Package field",
+		}, {
+			name:     "no control file",
+			data:     testAr (
+				"debian-binary", []byte("2.0\n"),
+				"control.tar",   testTar(test, testFile { "md5sums", "" }),
+				"data.tar.gz",   data),
+			expected: "no control file",
+		}, {
+			name:     "invalid ar header",
+			data:     invalid,
+			expected: "invalid ar header at 8",
+		}, {
+			name:     "truncated data archive",
+			data:     testDeb(test, control, data[:len(data) / 2]),
+			expected: "data.tar: unexpected EOF",
+		},
+	}
+	for _, current := range cases {
+		_, err := openTestArchive(test, current.data)
+		if err == nil || !strings.Contains(err.Error(), current.expected) {
+			test.Errorf("%v: expected %q, got %v", current.name, current.expected, err)
+		}
+	}
+}
+
+// rpmEntry is a value of the header of an rpm package.
+type rpmEntry struct {
+	tag   uint32
+	kind  uint32
+	value []byte
+}
+
+func testRPMHeader (entries ...rpmEntry) []byte {
+	be := binary.BigEndian
+	index := []byte { }
+	data := []byte { }
+	for _, entry := range entries {
+		index = be.AppendUint32(index, entry.tag)
+		index = be.AppendUint32(index, entry.kind)
+		index = be.AppendUint32(index, uint32(len(data)))
+		index = be.AppendUint32(index, 1)
+		data = append(data, entry.value...)
+	}
+	header := []byte { 0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0 }
+	header = be.AppendUint32(header, uint32(len(entries)))
+	header = be.AppendUint32(header, uint32(len(data)))
+	return append(append(header, index...), data...)
+}
+
+// cpioEntry is a file of a cpio archive.
+type cpioEntry struct {
+	name    string
+	mode    int64
+	inode   int64
+	links   int64
+	content string
+}
+
+func testCPIO (entries ...cpioEntry) []byte {
+	buffer := bytes.Buffer { }
+	pad := func () {
+		for buffer.Len() % 4 != 0 { buffer.WriteByte(0) }
+	}
+	for _, entry := range append(entries, cpioEntry { name: "TRAILER!!!", links: 1 }) {
+		fmt.Fprintf (
+			&buffer, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			entry.inode, entry.mode, 0, 0, entry.links, 0, len(entry.content),
+			0, 0, 0, 0, len(entry.name) + 1, 0)
+		buffer.WriteString(entry.name + "\x00")
+		pad()
+		buffer.WriteString(entry.content)
+		pad()
+	}
+	return buffer.Bytes()
+}
+
+func testRPM (header []byte, payload []byte) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte { 0xed, 0xab, 0xee, 0xdb, 3, 0 })
+	copy(lead[10:], "tool-2.0-3.el9")
+	// the signature header is five bytes long, and padded to eight
+	signature := testRPMHeader(rpmEntry { 1000, rpmTypeString, []byte("sign\x00") })
+	signature = append(signature, 0, 0, 0)
+	return append(append(append(lead, signature...), header...), payload...)
+}
+
+func TestRPM (test *testing.T) {
+	header := testRPMHeader (
+		rpmEntry { rpmTagName,    rpmTypeString, []byte("tool\x00") },
+		rpmEntry { rpmTagEpoch,   rpmTypeInt32,  []byte { 0, 0, 0, 1 } },
+		rpmEntry { rpmTagVersion, rpmTypeString, []byte("2.0\x00") },
+		rpmEntry { rpmTagRelease, rpmTypeString, []byte("3.el9\x00") },
+		rpmEntry { rpmTagArch,    rpmTypeString, []byte("x86_64\x00") })
+	payload := testCPIO (
+		cpioEntry { name: "./usr", mode: 0040755, inode: 1, links: 2 },
+		cpioEntry { name: "./usr/bin/tool", mode: 0100755, inode: 2, links: 1, content: "binary" },
+		cpioEntry { name: "./usr/bin/link", mode: 0120777, inode: 3, links: 1, content: "tool" },
+		// hard links whose content comes with the last of their names
+		cpioEntry { name: "./usr/lib/first", mode: 0100644, inode: 4, links: 2 },
+		cpioEntry { name: "./usr/lib/second", mode: 0100644, inode: 4, links: 2, content: "shared" })
+	archive, err := openTestArchive(test, testRPM(header, testGzip(test, payload)))
+	if err != nil { test.Fatal(err) }
+	checkArchive (
+		test, "rpm", archive,
+		pkgscan.Package { Type: "rpm", Name: "tool", Epoch: "1", Version: "2.0", Release: "3.el9", Arch: "x86_64" },
+		testFile { "usr/bin/tool", "binary" },
+		testFile { "usr/lib/first", "shared" },
+		testFile { "usr/lib/second", "shared" })
+	info, err := fs.Stat(archive.FS, "usr/bin")
+	if err != nil || !info.IsDir() { test.Errorf("usr/bin is not a directory: %v", err) }
+}
+
+func TestRPMFailures (test *testing.T) {
+	name := testRPMHeader(rpmEntry { rpmTagName, rpmTypeString, []byte("tool\x00") })
+	payload := testCPIO(cpioEntry { name: "./usr/bin/tool", mode: 0100755, inode: 2, links: 1, content: "binary" })
+	large := testRPMHeader()
+	binary.BigEndian.PutUint32(large[8:], 1 << 20)
+	cases := []struct {
+		name     string
+		data     []byte
+		expected string
+	} {
+		{
+			name:     "header too large",
+			data:     testRPM(large, nil),
+			expected: "header: header is too large",
+		}, {
+			name:     "invalid header",
+			data:     testRPM([]byte("not a header, but long enough"), nil),
+			expected: "header: invalid header",
+		}, {
+			name:     "no package name",
+			data:     testRPM(testRPMHeader(rpmEntry { rpmTagVersion, rpmTypeString, []byte("2.0\x00") }), nil),
+			expected: "header: no package name",
+		}, {
+			name:     "not a cpio archive",
+			data:     testRPM(name, testGzip(test, []byte(strings.Repeat("x", 200)))),
+			expected: "payload: not a cpio archive",
+		}, {
+			name:     "invalid cpio header",
+			data:     testRPM(name, testGzip(test, append([]byte("070701zz"), payload[8:]...))),
+			expected: "payload: invalid cpio header",
+		}, {
+			name:     "truncated payload",
+			data:     testRPM(name, testGzip(test, payload[:len(payload) - 20])),
+			expected: "payload: unexpected EOF",
+		},
+	}
+	for _, current := range cases {
+		_, err := openTestArchive(test, current.data)
+		if err == nil || !strings.Contains(err.Error(), current.expected) {
+			test.Errorf("%v: expected %q, got %v", current.name, current.expected, err)
+		}
+	}
+}
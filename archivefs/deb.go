@@ -0,0 +1,124 @@
+package archivefs
+
+import "io"
+import "os"
+import "fmt"
+import "path"
+import "bufio"
+import "errors"
+import "strings"
+import "strconv"
+import "archive/tar"
+import "github.com/ajblkf/microscope/pkgscan"
+
+// openDeb opens a Debian package, which is an ar archive holding a control
+// archive with the metadata of the package and a data archive with its files.
+// Either may be compressed.
+func (this *Archive) openDeb (file *os.File) error {
+	info, err := file.Stat()
+	if err != nil { return err }
+	members, err := arMembers(io.NewSectionReader(file, 0, info.Size()))
+	if err != nil { return err }
+
+	var control, data *io.SectionReader
+	for name, member := range members {
+		switch {
+		case strings.HasPrefix(name, "control.tar"): control = member
+		case strings.HasPrefix(name, "data.tar"):    data    = member
+		}
+	}
+	if control == nil || data == nil {
+		return errors.New("not a Debian package: control.tar or data.tar is missing")
+	}
+
+	pkg, err := readDebControl(control)
+	if err != nil { return fmt.Errorf("control.tar: %w", err) }
+	this.Package = &pkg
+
+	reader, closer, err := decompress(data)
+	if err != nil { return fmt.Errorf("data.tar: %w", err) }
+	defer closer()
+	err = this.openTar(reader)
+	if err != nil { return fmt.Errorf("data.tar: %w", err) }
+	return nil
+}
+
+// arMembers returns the members of an ar archive by name.
+func arMembers (archive *io.SectionReader) (map[string] *io.SectionReader, error) {
+	magic := make([]byte, 8)
+	_, err := archive.ReadAt(magic, 0)
+	if err != nil || string(magic) != "!<arch>\n" {
+		return nil, errors.New("not an ar archive")
+	}
+
+	members := map[string] *io.SectionReader { }
+	header := make([]byte, 60)
+	for offset := int64(8); offset < archive.Size(); {
+		_, err := archive.ReadAt(header, offset)
+		if err == io.EOF { break }
+		if err != nil { return nil, err }
+		if string(header[58:60]) != "`\n" {
+			return nil, errors.New(fmt.Sprint("invalid ar header at ", offset))
+		}
+		// GNU ar ends names with a slash
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, errors.New(fmt.Sprint("invalid size of ar member ", name))
+		}
+		offset += 60
+		members[name] = io.NewSectionReader(archive, offset, size)
+		// members are aligned to two bytes
+		offset += size + size % 2
+	}
+	return members, nil
+}
+
+// readDebControl reads the package described by the control file inside of a
+// control archive.
+func readDebControl (archive io.Reader) (pkgscan.Package, error) {
+	reader, closer, err := decompress(archive)
+	if err != nil { return pkgscan.Package { }, err }
+	defer closer()
+
+	entries := tar.NewReader(reader)
+	for {
+		header, err := entries.Next()
+		if err == io.EOF { break }
+		if err != nil { return pkgscan.Package { }, err }
+		if path.Clean(header.Name) != "control" { continue }
+		return parseDebControl(entries)
+	}
+	return pkgscan.Package { }, errors.New("no control file")
+}
+
+// parseDebControl parses the fields of a control file into a package.
+func parseDebControl (control io.Reader) (pkgscan.Package, error) {
+	pkg := pkgscan.Package { Type: "deb" }
+	scanner := bufio.NewScanner(control)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		// continuation lines of multi-line fields start with a space
+		if !found || strings.HasPrefix(key, " ") { continue }
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			pkg.Name = value
+		case "Version":
+			version := value
+			if epoch, rest, found := strings.Cut(version, ":"); found {
+				pkg.Epoch, version = epoch, rest
+			}
+			// the revision follows the last hyphen
+			if index := strings.LastIndex(version, "-"); index >= 0 {
+				version, pkg.Release = version[:index], version[index + 1:]
+			}
+			pkg.Version = version
+		case "Architecture":
+			pkg.Arch = value
+		}
+	}
+	if scanner.Err() != nil { return pkg, scanner.Err() }
+	if pkg.Name == "" { return pkg, errors.New("control file has no Package field") }
+	return pkg, nil
+}
@@ -0,0 +1,108 @@
+package archivefs
+
+import "io"
+import "path"
+import "time"
+import "io/fs"
+import "strings"
+
+// openFile decompresses a compressed file which is not an archive into a
+// temporary file, and opens a filesystem holding only it. The file is named
+// after the compressed file, without its extension.
+func (this *Archive) openFile (reader io.Reader, name string) error {
+	temporary, err := this.createTemporary(func (output io.Writer) error {
+		_, err := io.Copy(output, reader)
+		return err
+	})
+	if err != nil { return err }
+
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "" || name == "." || name == ".." { name = "data" }
+	this.FS = &fileFS { info: fileInfo {
+		name:    name,
+		size:    this.written,
+		mode:    0644,
+		modTime: time.Now(),
+	}, file: temporary }
+	return nil
+}
+
+// fileFS is a filesystem holding a single file at its root.
+type fileFS struct {
+	info fileInfo
+	file io.ReaderAt
+}
+
+func (this *fileFS) Open (name string) (fs.File, error) {
+	switch {
+	case !fs.ValidPath(name):
+		return nil, &fs.PathError { Op: "open", Path: name, Err: fs.ErrInvalid }
+	case name == ".":
+		return &fileFSRoot { fileFS: this }, nil
+	case name == this.info.name:
+		return &fileFSFile {
+			SectionReader: io.NewSectionReader(this.file, 0, this.info.size),
+			info:          this.info,
+		}, nil
+	}
+	return nil, &fs.PathError { Op: "open", Path: name, Err: fs.ErrNotExist }
+}
+
+type fileFSFile struct {
+	*io.SectionReader
+	info fileInfo
+}
+
+func (this *fileFSFile) Stat () (fs.FileInfo, error) {
+	return this.info, nil
+}
+
+func (this *fileFSFile) Close () error {
+	return nil
+}
+
+// fileFSRoot is the root directory of a fileFS.
+type fileFSRoot struct {
+	*fileFS
+	read bool
+}
+
+func (this *fileFSRoot) Stat () (fs.FileInfo, error) {
+	return fileInfo {
+		name:    ".",
+		mode:    fs.ModeDir | 0755,
+		modTime: this.info.modTime,
+	}, nil
+}
+
+func (this *fileFSRoot) Read ([]byte) (int, error) {
+	return 0, &fs.PathError { Op: "read", Path: ".", Err: fs.ErrInvalid }
+}
+
+func (this *fileFSRoot) ReadDir (count int) ([]fs.DirEntry, error) {
+	if this.read {
+		if count > 0 { return nil, io.EOF }
+		return nil, nil
+	}
+	this.read = true
+	return []fs.DirEntry { fs.FileInfoToDirEntry(this.info) }, nil
+}
+
+func (this *fileFSRoot) Close () error {
+	return nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (this fileInfo) Name    () string      { return this.name }
+func (this fileInfo) Size    () int64       { return this.size }
+func (this fileInfo) Mode    () fs.FileMode { return this.mode }
+func (this fileInfo) ModTime () time.Time   { return this.modTime }
+func (this fileInfo) IsDir   () bool        { return this.mode.IsDir() }
+func (this fileInfo) Sys     () any         { return nil }
@@ -0,0 +1,254 @@
+package archivefs
+
+import "io"
+import "os"
+import "fmt"
+import "time"
+import "path"
+import "bufio"
+import "bytes"
+import "errors"
+import "strings"
+import "strconv"
+import "archive/tar"
+import "encoding/binary"
+import "github.com/ajblkf/microscope/pkgscan"
+
+// tags of the header of an rpm package
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagArch    = 1022
+)
+
+// types of the values in the header of an rpm package
+const (
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeI18NString  = 9
+)
+
+// openRPM opens an rpm package, which is made of a lead, a signature header, a
+// header with the metadata of the package, and a compressed cpio archive with
+// its files. The cpio archive is converted into a tar archive.
+func (this *Archive) openRPM (file *os.File) error {
+	reader := bufio.NewReader(file)
+	lead := make([]byte, 96)
+	_, err := io.ReadFull(reader, lead)
+	if err != nil || !bytes.HasPrefix(lead, []byte { 0xed, 0xab, 0xee, 0xdb }) {
+		return errors.New("not an rpm package")
+	}
+
+	// the signature header is padded to eight bytes
+	_, size, err := readRPMHeader(reader)
+	if err != nil { return fmt.Errorf("signature: %w", err) }
+	_, err = reader.Discard((8 - size % 8) % 8)
+	if err != nil { return fmt.Errorf("signature: %w", err) }
+
+	header, _, err := readRPMHeader(reader)
+	if err != nil { return fmt.Errorf("header: %w", err) }
+	pkg := pkgscan.Package {
+		Type:    "rpm",
+		Name:    header.string(rpmTagName),
+		Epoch:   header.string(rpmTagEpoch),
+		Version: header.string(rpmTagVersion),
+		Release: header.string(rpmTagRelease),
+		Arch:    header.string(rpmTagArch),
+	}
+	if pkg.Name == "" { return errors.New("header: no package name") }
+	this.Package = &pkg
+
+	payload, closer, err := decompress(reader)
+	if err != nil { return fmt.Errorf("payload: %w", err) }
+	defer closer()
+	err = this.spool(func (output io.Writer) error {
+		return cpioToTar(payload, output)
+	})
+	if err != nil { return fmt.Errorf("payload: %w", err) }
+	return nil
+}
+
+// rpmHeader holds the values of a header of an rpm package by tag.
+type rpmHeader map[uint32] rpmValue
+
+type rpmValue struct {
+	kind uint32
+	data []byte
+}
+
+// readRPMHeader reads a header of an rpm package, and returns it along with the
+// size of its data.
+func readRPMHeader (reader io.Reader) (rpmHeader, int, error) {
+	intro := make([]byte, 16)
+	_, err := io.ReadFull(reader, intro)
+	if err != nil { return nil, 0, err }
+	if !bytes.HasPrefix(intro, []byte { 0x8e, 0xad, 0xe8 }) {
+		return nil, 0, errors.New("invalid header")
+	}
+	count := binary.BigEndian.Uint32(intro[8:12])
+	size  := binary.BigEndian.Uint32(intro[12:16])
+	// headers are far smaller than this
+	if count > 1 << 16 || size > 1 << 28 {
+		return nil, 0, errors.New("header is too large")
+	}
+
+	index := make([]byte, 16 * count)
+	_, err = io.ReadFull(reader, index)
+	if err != nil { return nil, 0, err }
+	data := make([]byte, size)
+	_, err = io.ReadFull(reader, data)
+	if err != nil { return nil, 0, err }
+
+	header := rpmHeader { }
+	for entry := index; len(entry) >= 16; entry = entry[16:] {
+		tag    := binary.BigEndian.Uint32(entry[0:4])
+		kind   := binary.BigEndian.Uint32(entry[4:8])
+		offset := binary.BigEndian.Uint32(entry[8:12])
+		if offset >= size { continue }
+		header[tag] = rpmValue { kind: kind, data: data[offset:] }
+	}
+	return header, int(size), nil
+}
+
+// string returns a value of a header as a string, or an empty string if it is
+// not present.
+func (this rpmHeader) string (tag uint32) string {
+	value, found := this[tag]
+	if !found { return "" }
+	switch value.kind {
+	case rpmTypeString, rpmTypeI18NString:
+		end := bytes.IndexByte(value.data, 0)
+		if end < 0 { return "" }
+		return string(value.data[:end])
+	case rpmTypeInt32:
+		if len(value.data) < 4 { return "" }
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(value.data)), 10)
+	}
+	return ""
+}
+
+// cpioFile is a file of a cpio archive.
+type cpioFile struct {
+	name  string
+	mode  int64
+	inode string
+	links int64
+	size  int64
+	mtime int64
+}
+
+// cpioToTar converts a cpio archive in the "new ASCII" format used by rpm into a
+// tar archive. Hard links are stored as copies of the file they link to.
+func cpioToTar (archive io.Reader, output io.Writer) error {
+	reader := bufio.NewReader(archive)
+	writer := tar.NewWriter(output)
+	// the names of hard links whose content is held by a later entry, by
+	// inode
+	pending := map[string] []string { }
+
+	for {
+		file, err := readCPIOHeader(reader)
+		if err != nil { return err }
+		if file.name == "TRAILER!!!" { break }
+
+		name := strings.TrimPrefix(path.Clean("/" + file.name), "/")
+		header := &tar.Header {
+			Name:    name,
+			Mode:    file.mode & 07777,
+			ModTime: time.Unix(file.mtime, 0),
+			Format:  tar.FormatPAX,
+		}
+		var content io.Reader = io.LimitReader(reader, file.size)
+		switch file.mode & 0170000 {
+		case 0040000:
+			header.Typeflag = tar.TypeDir
+		case 0120000:
+			target, err := io.ReadAll(content)
+			if err != nil { return err }
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = string(target)
+		case 0100000:
+			header.Typeflag = tar.TypeReg
+			header.Size     = file.size
+		}
+
+		switch {
+		case name == "" || header.Typeflag == 0:
+		case header.Typeflag == tar.TypeReg && file.links > 1 && file.size == 0:
+			// every name of a hard linked file but the last one is
+			// stored without content
+			pending[file.inode] = append(pending[file.inode], name)
+		case header.Typeflag == tar.TypeReg && len(pending[file.inode]) > 0:
+			data, err := io.ReadAll(content)
+			if err != nil { return err }
+			for _, name := range append(pending[file.inode], name) {
+				copied := *header
+				copied.Name = name
+				err = writeTarFile(writer, &copied, bytes.NewReader(data))
+				if err != nil { return err }
+			}
+			delete(pending, file.inode)
+		default:
+			err = writeTarFile(writer, header, content)
+			if err != nil { return err }
+		}
+
+		// skip whatever was not written, and the padding to four bytes
+		_, err = io.Copy(io.Discard, content)
+		if err != nil { return err }
+		_, err = reader.Discard(int((4 - file.size % 4) % 4))
+		if err != nil { return err }
+	}
+	return writer.Close()
+}
+
+// writeTarFile writes a file into a tar archive.
+func writeTarFile (writer *tar.Writer, header *tar.Header, content io.Reader) error {
+	err := writer.WriteHeader(header)
+	if err != nil { return err }
+	if header.Typeflag != tar.TypeReg { return nil }
+	_, err = io.CopyN(writer, content, header.Size)
+	return err
+}
+
+// readCPIOHeader reads the header and name of a file of a cpio archive, leaving
+// the reader at its content.
+func readCPIOHeader (reader *bufio.Reader) (cpioFile, error) {
+	header := make([]byte, 110)
+	_, err := io.ReadFull(reader, header)
+	if err != nil { return cpioFile { }, err }
+	magic := string(header[0:6])
+	if magic != "070701" && magic != "070702" {
+		return cpioFile { }, errors.New("not a cpio archive in the new ASCII format")
+	}
+
+	var fields [13]int64
+	for index := range fields {
+		field := header[6 + 8 * index:][:8]
+		fields[index], err = strconv.ParseInt(string(field), 16, 64)
+		if err != nil { return cpioFile { }, errors.New("invalid cpio header") }
+	}
+	// the fields are the inode, mode, uid, gid, number of links, mtime,
+	// size, device and represented device numbers, and size of the name
+	nameSize := fields[11]
+	if nameSize < 1 || nameSize > 1 << 16 {
+		return cpioFile { }, errors.New("invalid cpio header")
+	}
+	name := make([]byte, nameSize)
+	_, err = io.ReadFull(reader, name)
+	if err != nil { return cpioFile { }, err }
+	// the header and name are padded to four bytes
+	_, err = reader.Discard(int((4 - (110 + nameSize) % 4) % 4))
+	if err != nil { return cpioFile { }, err }
+
+	return cpioFile {
+		name:  string(bytes.TrimRight(name, "\x00")),
+		mode:  fields[1],
+		inode: fmt.Sprint(fields[7], ":", fields[8], ":", fields[0]),
+		links: fields[4],
+		size:  fields[6],
+		mtime: fields[5],
+	}, nil
+}
@@ -1,7 +1,12 @@
 package binscan
 
 import "fmt"
+import "sync"
+import "errors"
+import "context"
 import "io/fs"
+import "sync/atomic"
+import "github.com/ajblkf/microscope/severity"
 
 type Database interface {
 	CheckFile (filesystem fs.FS, path string) (*Vulnerability, error)
@@ -9,36 +14,230 @@ type Database interface {
 
 type Vulnerability struct {
 	// The path to the file (in given filesystem)
-	Name   string
+	Name   string `json:"name"`
 	// The hash of the file
-	Hash   string
+	Hash   string `json:"hash"`
 	// Where the vulnerability was mentioned
-	Source string
+	Source string `json:"source"`
 	// Description of the vulnerability
-	Reason string
+	Reason string `json:"reason"`
+	// How severe the vulnerability is
+	Severity severity.Severity `json:"severity,omitempty"`
+	// The CVSS vector of the vulnerability
+	CVSS     string  `json:"cvss,omitempty"`
+	// The CVSS base score of the vulnerability, or zero if unknown
+	Score    float64 `json:"score,omitempty"`
+	// An identifier of the advisory, such as a CVE or GHSA id
+	Advisory string  `json:"advisory,omitempty"`
+	// Where to read more about the vulnerability
+	URL      string  `json:"url,omitempty"`
+	// The digest of the image layer which added the finding, if it was found
+	// in an image
+	Layer     string `json:"layer,omitempty"`
+	// The instruction which created that layer, such as a Dockerfile line
+	CreatedBy string `json:"createdBy,omitempty"`
 }
 
 func (this Vulnerability) String () string {
-	return fmt.Sprintf("%s\t%s\t%s\t%s", this.Name, this.Hash, this.Source, this.Reason)
+	text := fmt.Sprintf("%s\t%s\t%s\t%s", this.Name, this.Hash, this.Source, this.Reason)
+	if this.Layer != "" {
+		text += fmt.Sprintf("\t%s\t%s", this.Layer, this.CreatedBy)
+	}
+	return text
+}
+
+// SkippedFile is a file or directory which could not be checked.
+type SkippedFile struct {
+	// The path to the file (in given filesystem)
+	Name   string `json:"name"`
+	// Why the file could not be checked
+	Reason string `json:"reason"`
 }
 
+// ErrNotRegular is the reason given for skipping devices, pipes and sockets,
+// which may never finish being read.
+var ErrNotRegular = errors.New("not a regular file")
+
+// ErrBrokenLink is the reason given for skipping symbolic links whose target
+// does not exist.
+var ErrBrokenLink = errors.New("broken symbolic link")
+
+// ErrLinkNotFollowed is the reason given for skipping symbolic links to
+// directories, which are not followed as they may form cycles.
+var ErrLinkNotFollowed = errors.New("symbolic link to a directory not followed")
+
 func Scan (filesystem fs.FS, root string, database Database) ([]Vulnerability, error) {
-	var vulnerabilities []Vulnerability
+	return ScanParallel(filesystem, root, database, 1)
+}
+
+// ScanParallel is like Scan, but checks up to the given number of files at
+// once.
+func ScanParallel (filesystem fs.FS, root string, database Database, jobs int) ([]Vulnerability, error) {
+	scanner := Scanner { Database: database, Jobs: jobs, Strict: true }
+	vulnerabilities, _, err := scanner.Scan(filesystem, root)
+	return vulnerabilities, err
+}
+
+// Scanner checks every file in a filesystem against a database.
+type Scanner struct {
+	Database Database
+	// How many files to check at once. The database and filesystem must be
+	// safe for concurrent use if this is more than one.
+	Jobs     int
+	// Which files to check, or nil to check every file
+	Filter   *Filter
+	// If true, the scan stops at the first file which cannot be checked,
+	// instead of skipping it
+	Strict   bool
+	// How to descend into archives, or nil to check them as they are
+	Nested   *Nested
+
+	// how many more bytes may be decompressed out of the archive being
+	// scanned, and how many archives deep it is
+	budget   *budget
+	depth    int
+}
+
+// Scan checks every file under the given root. Vulnerabilities are returned in
+// the order their files were walked, along with the files which were skipped
+// because they could not be checked. An error is returned if the root cannot
+// be walked, if the scan is cancelled, or if a file cannot be checked in a
+// strict scan. In that case, whatever was found before the error is returned
+// along with it.
+func (this *Scanner) Scan (filesystem fs.FS, root string) ([]Vulnerability, []SkippedFile, error) {
+	jobs := this.Jobs
+	if jobs < 1 { jobs = 1 }
+
+	type result struct {
+		path          string
+		vulnerability *Vulnerability
+		// What was found inside of the file if it is an archive
+		nested        []Vulnerability
+		nestedSkipped []SkippedFile
+		// Why the file was skipped
+		skip          error
+		// An error which stops the scan
+		err           error
+	}
+
+	var rootDevice uint64
+	if this.Filter != nil && this.Filter.OneFilesystem {
+		info, err := fs.Stat(filesystem, root)
+		if err != nil { return nil, nil, err }
+		rootDevice = deviceOf(info)
+	}
+
+	var results []*result
+	var failed  atomic.Bool
+	var group   sync.WaitGroup
+	fatal := func (err error) bool {
+		return this.Strict || isCancellation(err)
+	}
+	tasks := make(chan *result, jobs)
+	for worker := 0; worker < jobs; worker ++ {
+		group.Add(1)
+		go func () {
+			defer group.Done()
+			for current := range tasks {
+				path := current.path
+				skip, err := this.Filter.skipContent(filesystem, path)
+				if err == nil && !skip {
+					current.vulnerability, err =
+						this.Database.CheckFile(filesystem, path)
+				}
+				if err == nil {
+					current.nested, current.nestedSkipped, err =
+						this.scanNested(filesystem, path)
+				}
+				switch {
+				case err == nil:
+				case fatal(err):
+					current.err = err
+					failed.Store(true)
+				default:
+					current.skip = err
+				}
+			}
+		}()
+	}
 
 	// for every file in given filesystem
 	walker := func (path string, entry fs.DirEntry, err error) error {
-		if err != nil    { return err }
-		if entry.IsDir() { return nil }
-		vulnerability, err := database.CheckFile(filesystem, path)
-		if err != nil    { return err }
-		if vulnerability != nil {
-			vulnerabilities = append (
-				vulnerabilities,
-				*vulnerability)
+		if failed.Load() { return fs.SkipAll }
+		// the archive being scanned has been decompressed as far as
+		// it is allowed to be
+		if this.budget != nil && this.budget.exhausted() { return fs.SkipAll }
+		if err != nil {
+			if path == root || fatal(err) { return err }
+			results = append(results, &result { path: path, skip: err })
+			return nil
+		}
+		if entry.IsDir() {
+			if this.Filter.skipDir(path, entry, rootDevice) { return fs.SkipDir }
+			return nil
+		}
+		// symbolic links to files are checked as the files they point to
+		if entry.Type() & fs.ModeSymlink != 0 {
+			info, err := fs.Stat(filesystem, path)
+			if errors.Is(err, fs.ErrNotExist) {
+				err = &fs.PathError { Op: "stat", Path: path, Err: ErrBrokenLink }
+			}
+			if err != nil {
+				if fatal(err) { return err }
+				results = append(results, &result { path: path, skip: err })
+				return nil
+			}
+			if info.IsDir() {
+				results = append(results, &result { path: path, skip: ErrLinkNotFollowed })
+				return nil
+			}
+			entry = fs.FileInfoToDirEntry(info)
 		}
+		if this.Filter.skipFile(path, entry) { return nil }
+		// devices, pipes and sockets may never finish being read
+		if !entry.Type().IsRegular() {
+			results = append(results, &result { path: path, skip: ErrNotRegular })
+			return nil
+		}
+
+		result := &result { path: path }
+		results = append(results, result)
+		tasks <- result
 		return nil
 	}
-	
+
 	err := fs.WalkDir(filesystem, root, walker)
-	return vulnerabilities, err
+	close(tasks)
+	group.Wait()
+
+	var vulnerabilities []Vulnerability
+	var skipped         []SkippedFile
+	for _, result := range results {
+		if result.err != nil { return vulnerabilities, skipped, result.err }
+		if result.skip != nil {
+			skipped = append(skipped, SkippedFile {
+				Name:   result.path,
+				Reason: skipReason(result.skip),
+			})
+		}
+		if result.vulnerability != nil {
+			vulnerabilities = append (
+				vulnerabilities,
+				*result.vulnerability)
+		}
+		vulnerabilities = append(vulnerabilities, result.nested...)
+		skipped         = append(skipped,         result.nestedSkipped...)
+	}
+	return vulnerabilities, skipped, err
+}
+
+func isCancellation (err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// skipReason describes an error without the path it occurred at.
+func skipReason (err error) string {
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) { return pathError.Err.Error() }
+	return err.Error()
 }
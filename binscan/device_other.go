@@ -0,0 +1,11 @@
+//go:build !unix
+
+package binscan
+
+import "io/fs"
+
+// deviceOf returns zero, as the device a file is on cannot be found on this
+// system.
+func deviceOf (info fs.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package binscan
+
+import "io/fs"
+import "syscall"
+
+// deviceOf returns the device a file is on, or zero if it is unknown.
+func deviceOf (info fs.FileInfo) uint64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok { return 0 }
+	return uint64(stat.Dev)
+}
@@ -0,0 +1,210 @@
+package binscan
+
+import "io"
+import "fmt"
+import "path"
+import "bytes"
+import "io/fs"
+import "errors"
+import "regexp"
+import "strings"
+import "github.com/gabriel-vasile/mimetype"
+
+// PseudoFilesystems lists directories which hold pseudo-filesystems on Linux,
+// such as /proc, and should not be scanned when scanning an entire system.
+var PseudoFilesystems = []string { "/proc", "/sys", "/dev" }
+
+// Filter decides which files are checked during a scan.
+type Filter struct {
+	// Files are checked only if they match any of these patterns, unless
+	// there are none
+	Include []Pattern
+	// Files and directories matching any of these patterns are skipped
+	Exclude []Pattern
+	// Files larger than this many bytes are skipped, unless it is zero
+	MaxSize int64
+	// Files are checked only if they are of one of these types, unless there
+	// are none. A type is either a MIME type, which also matches its subtypes,
+	// or one of elf, pe, macho or script.
+	Types   []string
+	// Directories on a different device than the root of the scan are
+	// skipped. This only works on the local system.
+	OneFilesystem bool
+}
+
+// Validate returns an error if any of the types of the filter are invalid.
+func (this *Filter) Validate () error {
+	for _, kind := range this.Types {
+		_, alias := typeAliases[kind]
+		if !alias && kind != "script" && !strings.Contains(kind, "/") {
+			return errors.New(fmt.Sprint("unknown file type ", kind))
+		}
+	}
+	return nil
+}
+
+// Pattern matches slash separated paths relative to the root of a filesystem.
+type Pattern interface {
+	Match (name string) bool
+}
+
+type regexpPattern struct {
+	*regexp.Regexp
+}
+
+func (this regexpPattern) Match (name string) bool {
+	return this.MatchString(name)
+}
+
+// ParseRegexp returns a pattern which matches paths containing a match of a
+// regular expression.
+func ParseRegexp (expression string) (Pattern, error) {
+	compiled, err := regexp.Compile(expression)
+	if err != nil { return nil, err }
+	return regexpPattern { compiled }, nil
+}
+
+// ParseGlob returns a pattern which matches paths using a shell-style glob. A
+// glob containing a slash matches entire paths, with an optional leading slash
+// referring to the root of the filesystem. Otherwise, it matches the name of
+// any file or directory. A * matches anything except a slash, ** matches
+// anything including slashes, ? matches a single character, and [...] matches
+// a set of characters.
+func ParseGlob (glob string) (Pattern, error) {
+	anchored := strings.Contains(strings.TrimSuffix(glob, "/"), "/")
+	glob = strings.Trim(glob, "/")
+	if glob == "" { return nil, errors.New("empty glob") }
+
+	expression := strings.Builder { }
+	if anchored {
+		expression.WriteString("^")
+	} else {
+		expression.WriteString("(^|/)")
+	}
+	for index := 0; index < len(glob); index ++ {
+		char := glob[index]
+		switch char {
+		case '*':
+			if index + 1 < len(glob) && glob[index + 1] == '*' {
+				expression.WriteString(".*")
+				index ++
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[index:], ']')
+			if end < 0 {
+				return nil, errors.New(fmt.Sprint("unterminated [ in glob ", glob))
+			}
+			class := glob[index + 1:index + end]
+			if strings.HasPrefix(class, "!") { class = "^" + class[1:] }
+			expression.WriteString("[" + class + "]")
+			index += end
+		case '\\':
+			if index + 1 < len(glob) {
+				index ++
+				char = glob[index]
+			}
+			fallthrough
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	expression.WriteString("$")
+
+	compiled, err := regexp.Compile(expression.String())
+	if err != nil {
+		return nil, errors.New(fmt.Sprint("invalid glob ", glob))
+	}
+	return regexpPattern { compiled }, nil
+}
+
+// skipDir returns whether a directory should be skipped entirely.
+func (this *Filter) skipDir (name string, entry fs.DirEntry, rootDevice uint64) bool {
+	if this == nil { return false }
+	if name != "." && matchAny(this.Exclude, name) { return true }
+	if this.OneFilesystem && rootDevice != 0 {
+		info, err := entry.Info()
+		if err == nil {
+			device := deviceOf(info)
+			if device != 0 && device != rootDevice { return true }
+		}
+	}
+	return false
+}
+
+// skipFile returns whether a file should be skipped, based on its name and
+// metadata.
+func (this *Filter) skipFile (name string, entry fs.DirEntry) bool {
+	if this == nil { return false }
+	if len(this.Include) > 0 && !matchAny(this.Include, name) { return true }
+	if matchAny(this.Exclude, name) { return true }
+	if this.MaxSize > 0 {
+		info, err := entry.Info()
+		if err == nil && info.Size() > this.MaxSize { return true }
+	}
+	return false
+}
+
+// skipContent returns whether a file should be skipped, based on its type.
+func (this *Filter) skipContent (filesystem fs.FS, name string) (bool, error) {
+	if this == nil || len(this.Types) == 0 { return false, nil }
+
+	file, err := filesystem.Open(name)
+	if err != nil { return false, err }
+	defer file.Close()
+	header := make([]byte, 3072)
+	count, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return this.skipType(header[:count]), nil
+}
+
+// skipType returns whether a file should be skipped, based on the type of the
+// content it starts with.
+func (this *Filter) skipType (header []byte) bool {
+	if this == nil || len(this.Types) == 0 { return false }
+	mime := mimetype.Detect(header)
+	for _, kind := range this.Types {
+		if matchType(kind, mime, header) { return false }
+	}
+	return true
+}
+
+var typeAliases = map[string] string {
+	"elf":   "application/x-elf",
+	"pe":    "application/vnd.microsoft.portable-executable",
+	"macho": "application/x-mach-binary",
+}
+
+var scriptTypes = []string {
+	"text/x-php", "application/javascript", "text/x-lua",
+	"text/x-perl", "text/x-python", "text/x-tcl",
+}
+
+func matchType (kind string, mime *mimetype.MIME, header []byte) bool {
+	if kind == "script" {
+		if bytes.HasPrefix(header, []byte("#!")) { return true }
+		for _, script := range scriptTypes {
+			if mime.Is(script) { return true }
+		}
+		return false
+	}
+
+	if alias, ok := typeAliases[kind]; ok { kind = alias }
+	for ; mime != nil; mime = mime.Parent() {
+		if mime.Is(kind) { return true }
+	}
+	return false
+}
+
+func matchAny (patterns []Pattern, name string) bool {
+	name = path.Clean(name)
+	for _, pattern := range patterns {
+		if pattern.Match(name) { return true }
+	}
+	return false
+}
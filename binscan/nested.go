@@ -0,0 +1,257 @@
+package binscan
+
+import "io"
+import "os"
+import "fmt"
+import "math"
+import "path"
+import "bufio"
+import "io/fs"
+import "errors"
+import "sync/atomic"
+import "github.com/ajblkf/microscope/archivefs"
+
+// Nested decides how a scanner descends into the archives it finds, such as
+// tar, zip, jar and gzip files, to check the files inside of them. These are
+// reported with paths such as app.tar.gz!/lib/x.jar!/y.class, and are filtered
+// like any other file, by their path inside of the archive.
+type Nested struct {
+	// How many archives deep to descend, such as 1 to check the files
+	// inside of archives, but not those inside of archives inside of them
+	Depth    int
+	// How many bytes may be decompressed out of each archive found in the
+	// scanned filesystem, including the archives inside of it, unless it is
+	// zero
+	MaxSize  int64
+	// How many times larger than itself an archive may grow once it is
+	// decompressed, unless it is zero. Small archives may grow to a
+	// megabyte regardless. Along with MaxSize, this stops archives made to
+	// decompress into far more than they hold, such as zip bombs, early.
+	MaxRatio int64
+	// The database which checks files inside of archives, or nil to use
+	// that of the scanner, which is needed if that one only checks files on
+	// the local system
+	Database Database
+	// Wrap, if not nil, is applied to the filesystem of every archive, such
+	// as to stop reading it once a context is done
+	Wrap     func (fs.FS) fs.FS
+}
+
+// minRatioLimit is how large any archive may grow regardless of its size, as
+// small archives of small files grow far larger than they are just from the
+// headers and padding of tar archives.
+const minRatioLimit = 1 << 20
+
+// descends returns whether the scanner descends into the archives it finds.
+func (this *Scanner) descends () bool {
+	return this.Nested != nil && this.depth < this.Nested.Depth
+}
+
+// scanNested checks the files inside of a file if it is an archive, and the
+// scanner descends into archives. An error is returned if the archive cannot
+// be read.
+func (this *Scanner) scanNested (filesystem fs.FS, name string) ([]Vulnerability, []SkippedFile, error) {
+	if !this.descends() { return nil, nil, nil }
+	file, err := filesystem.Open(name)
+	if err != nil { return nil, nil, err }
+	defer file.Close()
+
+	archive, rest, err := this.teeArchive(file)
+	if err != nil || archive == nil { return nil, nil, err }
+	defer closeTemporary(archive)
+	return this.scanTemporary(name, archive, rest)
+}
+
+// teeArchive copies a file into a temporary file as it is read, if it is an
+// archive and the scanner descends into archives. It returns the temporary
+// file, or nil, along with a reader for the file which must be read from
+// instead.
+func (this *Scanner) teeArchive (content io.Reader) (*os.File, io.Reader, error) {
+	if !this.descends() { return nil, content, nil }
+	buffered := bufio.NewReaderSize(content, archivefs.HeaderSize)
+	start, err := buffered.Peek(archivefs.HeaderSize)
+	if err != nil && err != io.EOF { return nil, nil, err }
+	if !archivefs.IsArchive(start) { return nil, buffered, nil }
+
+	// Where the system allows it, the file is removed as soon as it is
+	// created, so that it is not left behind if the program is killed
+	temporary, err := os.CreateTemp("", "microscope_nested_*")
+	if err != nil { return nil, nil, err }
+	os.Remove(temporary.Name())
+	return temporary, io.TeeReader(buffered, temporary), nil
+}
+
+func closeTemporary (temporary *os.File) {
+	if temporary == nil { return }
+	temporary.Close()
+	os.Remove(temporary.Name())
+}
+
+// scanTemporary finishes copying an archive into a temporary file by reading
+// whatever is left of it, and checks the files inside of it.
+func (this *Scanner) scanTemporary (
+	name      string,
+	temporary *os.File,
+	rest      io.Reader,
+) (
+	[]Vulnerability,
+	[]SkippedFile,
+	error,
+) {
+	_, err := io.Copy(io.Discard, rest)
+	if err != nil { return nil, nil, err }
+	size, err := temporary.Seek(0, io.SeekCurrent)
+	if err != nil { return nil, nil, err }
+	_, err = temporary.Seek(0, io.SeekStart)
+	if err != nil { return nil, nil, err }
+
+	// the archives inside of an archive share its budget
+	parent := this.budget
+	if parent == nil { parent = newBudget(this.Nested.MaxSize, nil) }
+	limit := int64(0)
+	if this.Nested.MaxRatio > 0 && size < math.MaxInt64 / this.Nested.MaxRatio {
+		limit = max(size * this.Nested.MaxRatio, minRatioLimit)
+	}
+	budget := newBudget(limit, parent)
+
+	archive, err := archivefs.OpenWith(temporary, archivefs.Options {
+		Name:    path.Base(name),
+		MaxSize: budget.available(),
+	})
+	if err != nil { return nil, nil, archiveError(name, err) }
+	defer archive.Close()
+	budget.take(archive.Written())
+
+	var filesystem fs.FS = budgetFS { FS: archive.FS, budget: budget }
+	if this.Nested.Wrap != nil { filesystem = this.Nested.Wrap(filesystem) }
+	database := this.Nested.Database
+	if database == nil { database = this.Database }
+	inner := &Scanner {
+		Database: database,
+		Filter:   this.Filter,
+		Strict:   this.Strict,
+		Nested:   this.Nested,
+		budget:   budget,
+		depth:    this.depth + 1,
+	}
+	vulnerabilities, skipped, err := inner.Scan(filesystem, ".")
+
+	prefix := name + "!/"
+	for index := range vulnerabilities {
+		vulnerabilities[index].Name = prefix + vulnerabilities[index].Name
+	}
+	var kept []SkippedFile
+	for _, file := range skipped {
+		// files which could not be read because the archive ran out of
+		// budget are reported along with the archive instead
+		if budget.exhausted() && file.Reason == archivefs.ErrTooLarge.Error() { continue }
+		file.Name = prefix + file.Name
+		kept = append(kept, file)
+	}
+
+	var pathError *fs.PathError
+	switch {
+	case errors.As(err, &pathError):
+		// errors inside of the archive refer to paths inside of it
+		if pathError.Path == "." {
+			pathError.Path = name
+		} else {
+			pathError.Path = prefix + pathError.Path
+		}
+	case err == nil && budget.exhausted():
+		err = archiveError(name, archivefs.ErrTooLarge)
+	}
+	return vulnerabilities, kept, err
+}
+
+// archiveError returns an error for an archive which cannot be read.
+func archiveError (name string, err error) error {
+	return &fs.PathError {
+		Op:   "open",
+		Path: name,
+		Err:  fmt.Errorf("cannot read archive: %w", err),
+	}
+}
+
+// budget is how many more bytes may be decompressed out of an archive, and out
+// of each archive it is inside of. It is safe to use from several goroutines
+// at once, as an archive is walked while its files are read.
+type budget struct {
+	remaining atomic.Int64
+	parent    *budget
+}
+
+// newBudget returns a budget of a number of bytes inside of another budget,
+// which may be nil. A limit of zero means no limit.
+func newBudget (limit int64, parent *budget) *budget {
+	if limit <= 0 { limit = math.MaxInt64 }
+	budget := &budget { parent: parent }
+	budget.remaining.Store(limit)
+	return budget
+}
+
+// take takes bytes from the budget and every budget it is inside of. It
+// returns false if that was more than any of them had left.
+func (this *budget) take (count int64) bool {
+	enough := true
+	for current := this; current != nil; current = current.parent {
+		if current.remaining.Add(-count) < 0 { enough = false }
+	}
+	return enough
+}
+
+// available returns how many more bytes may be taken from the budget.
+func (this *budget) available () int64 {
+	available := int64(math.MaxInt64)
+	for current := this; current != nil; current = current.parent {
+		available = min(available, current.remaining.Load())
+	}
+	return max(available, 0)
+}
+
+// exhausted returns whether more bytes have been taken from the budget, or any
+// budget it is inside of, than it had.
+func (this *budget) exhausted () bool {
+	for current := this; current != nil; current = current.parent {
+		if current.remaining.Load() < 0 { return true }
+	}
+	return false
+}
+
+// budgetFS takes every byte read from a filesystem from a budget, and fails to
+// read once the budget is exhausted.
+type budgetFS struct {
+	fs.FS
+	budget *budget
+}
+
+func (this budgetFS) Open (name string) (fs.File, error) {
+	if this.budget.exhausted() {
+		return nil, &fs.PathError { Op: "open", Path: name, Err: archivefs.ErrTooLarge }
+	}
+	file, err := this.FS.Open(name)
+	if err != nil { return nil, err }
+	return budgetFile { File: file, budget: this.budget, name: name }, nil
+}
+
+func (this budgetFS) ReadDir (name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(this.FS, name)
+}
+
+func (this budgetFS) Stat (name string) (fs.FileInfo, error) {
+	return fs.Stat(this.FS, name)
+}
+
+type budgetFile struct {
+	fs.File
+	budget *budget
+	name   string
+}
+
+func (this budgetFile) Read (buffer []byte) (int, error) {
+	count, err := this.File.Read(buffer)
+	if !this.budget.take(int64(count)) {
+		return count, &fs.PathError { Op: "read", Path: this.name, Err: archivefs.ErrTooLarge }
+	}
+	return count, err
+}
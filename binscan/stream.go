@@ -0,0 +1,261 @@
+package binscan
+
+import "io"
+import "path"
+import "bytes"
+import "io/fs"
+import "errors"
+import "strings"
+import "archive/tar"
+import "github.com/ajblkf/microscope/imagefs"
+
+// ScanTar checks every regular file under the given roots of a tar archive as
+// it is read, in a single pass, so that archives which cannot be read at random
+// or stored anywhere, such as the output of a command, can be scanned. Files
+// for which keep returns true are kept in memory, and returned as a filesystem
+// once the archive has been read, so that they can be read again, such as to
+// scan packages. Keep may be nil.
+//
+// Files are checked one at a time in the order they appear in the archive,
+// regardless of Jobs. Hard links are reported along with the file they link
+// to, if that file was checked, but not along with what was found inside of
+// it if it is an archive.
+func (this *Scanner) ScanTar (
+	archive io.Reader,
+	roots   []string,
+	keep    func (name string) bool,
+) (
+	[]Vulnerability,
+	[]SkippedFile,
+	fs.FS,
+	error,
+) {
+	var vulnerabilities []Vulnerability
+	var skipped         []SkippedFile
+	// the findings of files which have been checked, by name, so that hard
+	// links to them can be reported too
+	checked := map[string] *Vulnerability { }
+
+	var kept bytes.Buffer
+	keeper := tar.NewWriter(&kept)
+	// the contents of files which have been kept, by name, so that hard
+	// links to them can be kept as copies
+	keptFiles := map[string] []byte { }
+
+	fail := func (name string, err error) error {
+		if this.Strict || isCancellation(err) { return err }
+		skipped = append(skipped, SkippedFile {
+			Name:   name,
+			Reason: skipReason(err),
+		})
+		return nil
+	}
+
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF { break }
+		if err != nil { return vulnerabilities, skipped, nil, err }
+		name := entryName(header.Name)
+		if name == "." { continue }
+
+		keeping := keep != nil && keep(name)
+		var content io.Reader = reader
+		var saved bytes.Buffer
+		if keeping && header.Typeflag == tar.TypeReg {
+			content = io.TeeReader(reader, &saved)
+		}
+
+		if this.wants(name, header, roots) {
+			switch header.Typeflag {
+			case tar.TypeReg:
+				// archives are copied as they are checked, so that
+				// the files inside of them can be checked next
+				archive, checking, err := this.teeArchive(content)
+				var vulnerability *Vulnerability
+				if err == nil {
+					vulnerability, err = this.checkEntry(name, header, checking)
+				}
+				if err != nil {
+					closeTemporary(archive)
+					err = fail(name, err)
+					if err != nil { return vulnerabilities, skipped, nil, err }
+					break
+				}
+				checked[name] = vulnerability
+				if vulnerability != nil {
+					vulnerabilities = append(vulnerabilities, *vulnerability)
+				}
+				if archive == nil { break }
+
+				nested, nestedSkipped, err := this.scanTemporary(name, archive, checking)
+				closeTemporary(archive)
+				vulnerabilities = append(vulnerabilities, nested...)
+				skipped         = append(skipped,         nestedSkipped...)
+				if err != nil {
+					err = fail(name, err)
+					if err != nil { return vulnerabilities, skipped, nil, err }
+				}
+
+			case tar.TypeLink:
+				target := entryName(header.Linkname)
+				vulnerability, found := checked[target]
+				if !found {
+					err = fail(name, errors.New("links to " + target + ", which was not checked"))
+					if err != nil { return vulnerabilities, skipped, nil, err }
+					break
+				}
+				checked[name] = vulnerability
+				if vulnerability != nil {
+					linked := *vulnerability
+					linked.Name = name
+					vulnerabilities = append(vulnerabilities, linked)
+				}
+
+			case tar.TypeDir, tar.TypeSymlink:
+			default:
+				err = fail(name, ErrNotRegular)
+				if err != nil { return vulnerabilities, skipped, nil, err }
+			}
+		}
+
+		if !keeping { continue }
+		switch header.Typeflag {
+		case tar.TypeReg:
+			// read whatever was not needed to check the file
+			_, err := io.Copy(io.Discard, content)
+			if err != nil { return vulnerabilities, skipped, nil, err }
+			keptFiles[name] = saved.Bytes()
+		case tar.TypeLink:
+			target := entryName(header.Linkname)
+			data, found := keptFiles[target]
+			if !found { continue }
+			keptFiles[name] = data
+			saved.Write(data)
+		}
+		err = keepEntry(keeper, name, header, saved.Bytes())
+		if err != nil { return vulnerabilities, skipped, nil, err }
+	}
+
+	err := keeper.Close()
+	if err != nil { return vulnerabilities, skipped, nil, err }
+	// the kept files are merged like a layer of an image, which resolves
+	// symbolic links such as etc/os-release inside of the archive
+	filesystem, err := imagefs.Merge([]imagefs.Layer { {
+		Archive: io.NewSectionReader(bytes.NewReader(kept.Bytes()), 0, int64(kept.Len())),
+	} })
+	if err != nil { return vulnerabilities, skipped, nil, err }
+	return vulnerabilities, skipped, filesystem, nil
+}
+
+// entryName returns the path of the file an entry of an archive refers to,
+// relative to the root of the archive.
+func entryName (name string) string {
+	name = strings.TrimPrefix(path.Clean("/" + name), "/")
+	if name == "" { return "." }
+	return name
+}
+
+// wants returns whether an entry of an archive is under one of the roots, and
+// is not skipped by the filter because of its name or metadata.
+func (this *Scanner) wants (name string, header *tar.Header, roots []string) bool {
+	under := false
+	for _, root := range roots {
+		root = path.Clean(root)
+		if root == "." || name == root || strings.HasPrefix(name, root + "/") {
+			under = true
+			break
+		}
+	}
+	if !under || this.Filter == nil { return under }
+
+	// directories are not walked, so each one the entry is in is checked
+	// against the filter instead
+	for directory := path.Dir(name); directory != "."; directory = path.Dir(directory) {
+		if matchAny(this.Filter.Exclude, directory) { return false }
+	}
+	if header.Typeflag == tar.TypeDir { return true }
+	return !this.Filter.skipFile(name, fs.FileInfoToDirEntry(header.FileInfo()))
+}
+
+// checkEntry checks a regular file of an archive, which is read from content.
+// It returns nil if the file is not vulnerable or is skipped because of its
+// type.
+func (this *Scanner) checkEntry (name string, header *tar.Header, content io.Reader) (*Vulnerability, error) {
+	start := make([]byte, 3072)
+	count, err := io.ReadFull(content, start)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, &fs.PathError { Op: "read", Path: name, Err: err }
+	}
+	start = start[:count]
+	if this.Filter.skipType(start) { return nil, nil }
+
+	entry := &entryFS {
+		name:   name,
+		info:   header.FileInfo(),
+		reader: io.MultiReader(bytes.NewReader(start), content),
+	}
+	return this.Database.CheckFile(entry, name)
+}
+
+// keepEntry copies an entry of an archive into another archive. Hard links are
+// copied as regular files holding the content of the file they link to.
+func keepEntry (keeper *tar.Writer, name string, header *tar.Header, content []byte) error {
+	copied := &tar.Header {
+		Typeflag: header.Typeflag,
+		Name:     name,
+		Linkname: header.Linkname,
+		Mode:     header.Mode,
+		ModTime:  header.ModTime,
+		Format:   tar.FormatPAX,
+	}
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeLink:
+		copied.Typeflag = tar.TypeReg
+		copied.Linkname = ""
+		copied.Size     = int64(len(content))
+	case tar.TypeDir, tar.TypeSymlink:
+	default:
+		return nil
+	}
+	err := keeper.WriteHeader(copied)
+	if err != nil { return err }
+	_, err = keeper.Write(content)
+	return err
+}
+
+// entryFS is a filesystem holding a single file of an archive which is being
+// read, which can only be opened once.
+type entryFS struct {
+	name   string
+	info   fs.FileInfo
+	reader io.Reader
+	opened bool
+}
+
+func (this *entryFS) Open (name string) (fs.File, error) {
+	if name != this.name {
+		return nil, &fs.PathError { Op: "open", Path: name, Err: fs.ErrNotExist }
+	}
+	if this.opened {
+		return nil, &fs.PathError { Op: "open", Path: name, Err: errors.New("can only be read once") }
+	}
+	this.opened = true
+	return &entryFile { entryFS: this }, nil
+}
+
+type entryFile struct {
+	*entryFS
+}
+
+func (this *entryFile) Stat () (fs.FileInfo, error) {
+	return this.info, nil
+}
+
+func (this *entryFile) Read (buffer []byte) (int, error) {
+	return this.reader.Read(buffer)
+}
+
+func (this *entryFile) Close () error {
+	return nil
+}
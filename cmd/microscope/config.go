@@ -0,0 +1,251 @@
+package main
+
+import "os"
+import "fmt"
+import "time"
+import "errors"
+import "path/filepath"
+import "gopkg.in/yaml.v3"
+import "github.com/ajblkf/microscope/imagefs"
+import "github.com/ajblkf/microscope/severity"
+import "github.com/ajblkf/microscope/container"
+
+// configFileName is the name of the configuration file that is read from the
+// working directory if no other file is specified.
+const configFileName = ".microscope.yaml"
+
+// configFile is the structure of a configuration file. Paths on the local
+// system are relative to the directory the file is in.
+type configFile struct {
+	Databases struct {
+		Packages []string `yaml:"packages"`
+		Files    []string `yaml:"files"`
+	} `yaml:"databases"`
+	Suppress    []string           `yaml:"suppress"`
+	Targets     []configTarget     `yaml:"targets"`
+	Outputs     []scanOutput       `yaml:"outputs"`
+	Fail        scanPolicy         `yaml:"fail"`
+	// Findings less severe than this are not reported
+	MinSeverity *severity.Severity `yaml:"minSeverity"`
+	// How many files to check at once
+	Jobs        *int               `yaml:"jobs"`
+	// How many targets to scan at once
+	Parallel    *int               `yaml:"parallel"`
+	// How long the whole scan, and each target, may take
+	Timeout     *time.Duration     `yaml:"timeout"`
+	TaskTimeout *time.Duration     `yaml:"taskTimeout"`
+	// A file to cache the sums of local files in
+	HashCache   string             `yaml:"hashCache"`
+	// Which files are checked by file scans
+	Filter      scanFilter         `yaml:"filter"`
+	// Whether a file which cannot be read stops the scan of its target
+	Strict      *bool              `yaml:"strict"`
+	// Which image to scan in OCI layouts
+	Platform    *imagefs.Platform  `yaml:"platform"`
+	// The container runtime command or storage directory
+	Runtime     string             `yaml:"runtime"`
+	// Whether to stream containers instead of exporting them to a file
+	Stream      *bool              `yaml:"stream"`
+
+	// the directory the file is in
+	directory string
+}
+
+// configTarget describes something to be scanned. Containers, images, disk
+// images and archives are scanned for packages, files and NPM projects inside
+// of them, and everything else is scanned on the local system.
+type configTarget struct {
+	Container     string   `yaml:"container"`
+	Archive       string   `yaml:"archive"`
+	// A docker image, an image archive written by docker save, an OCI image
+	// layout directory or archive, or an image in a registry
+	Image         string   `yaml:"image"`
+	ImageArchive  string   `yaml:"imageArchive"`
+	OCILayout     string   `yaml:"ociLayout"`
+	RegistryImage string   `yaml:"registryImage"`
+	// A raw disk image, whose partitions are each scanned
+	DiskImage     string   `yaml:"diskImage"`
+	// Scan packages installed on the system, container, image or archive
+	Packages      bool     `yaml:"packages"`
+	// Recursively scan a list of files or directories
+	Files         []string `yaml:"files"`
+	// Scan dependencies of NPM projects
+	Projects      []string `yaml:"projects"`
+	// Scan packages listed in SBOMs
+	SBOMs         []string `yaml:"sboms"`
+}
+
+// discoverConfig returns the name of the configuration file in the working
+// directory, or an empty string if there is none.
+func discoverConfig () string {
+	_, err := os.Stat(configFileName)
+	if err != nil { return "" }
+	return configFileName
+}
+
+func loadConfig (name string) (*configFile, error) {
+	file, err := os.Open(name)
+	if err != nil { return nil, err }
+	defer file.Close()
+
+	config := &configFile { directory: filepath.Dir(name) }
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	err = decoder.Decode(config)
+	if err != nil { return nil, fmt.Errorf("%v: %w", name, err) }
+
+	if config.Jobs != nil && *config.Jobs < 1 {
+		return nil, errors.New(fmt.Sprint(name, ": jobs must be at least 1"))
+	}
+	if config.Parallel != nil && *config.Parallel < 1 {
+		return nil, errors.New(fmt.Sprint(name, ": parallel must be at least 1"))
+	}
+	if config.Fail.MaxFindings != nil && *config.Fail.MaxFindings < 0 {
+		return nil, errors.New(fmt.Sprint(name, ": maxFindings cannot be negative"))
+	}
+	for index, target := range config.Targets {
+		err := target.validate()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf (
+				"%v: target %v: %v", name, index + 1, err))
+		}
+	}
+	return config, nil
+}
+
+func (this configTarget) validate () error {
+	sources := 0
+	for _, source := range []string {
+		this.Container, this.Archive, this.Image, this.ImageArchive,
+		this.OCILayout, this.RegistryImage, this.DiskImage,
+	} {
+		if source != "" { sources ++ }
+	}
+	switch {
+	case sources > 1:
+		return errors.New (
+			"can only be one of a container, archive, image, imageArchive, " +
+			"ociLayout, registryImage or diskImage")
+	case this.Archive != "" && len(this.Projects) > 0:
+		return errors.New("projects cannot be scanned in an archive")
+	case sources > 0 && len(this.SBOMs) > 0:
+		return errors.New("sboms cannot be read from a container, image or archive")
+	case !this.Packages && len(this.Files) == 0 &&
+		len(this.Projects) == 0 && len(this.SBOMs) == 0:
+		return errors.New("nothing to scan")
+	}
+	return nil
+}
+
+// path resolves a local path relative to the configuration file.
+func (this *configFile) path (name string) string {
+	if name == "" || filepath.IsAbs(name) { return name }
+	return filepath.Join(this.directory, name)
+}
+
+func (this *configFile) paths (names []string) []string {
+	if names == nil { return nil }
+	resolved := make([]string, len(names))
+	for index, name := range names {
+		resolved[index] = this.path(name)
+	}
+	return resolved
+}
+
+// scanConfig converts the configuration file into a scan configuration.
+func (this *configFile) scanConfig () scanConfig {
+	config := scanConfig {
+		PackageDatabases: this.paths(this.Databases.Packages),
+		FileDatabases:    this.paths(this.Databases.Files),
+		Suppressions:     this.paths(this.Suppress),
+		Policy:           this.Fail,
+		MinSeverity:      this.MinSeverity,
+		Jobs:             this.Jobs,
+		Parallel:         this.Parallel,
+		Timeout:          this.Timeout,
+		TaskTimeout:      this.TaskTimeout,
+		HashCache:        this.path(this.HashCache),
+		Filter:           this.Filter,
+		Strict:           this.Strict,
+		Platform:         this.Platform,
+		Runtime:          this.Runtime,
+		Stream:           this.Stream,
+	}
+	if this.Runtime != "auto" && !container.IsCommand(this.Runtime) {
+		config.Runtime = this.path(this.Runtime)
+	}
+
+	for _, output := range this.Outputs {
+		output.Template = this.path(output.Template)
+		output.File     = this.path(output.File)
+		config.Outputs  = append(config.Outputs, output)
+	}
+
+	add := func (kind string, args ...string) {
+		config.Targets = append(config.Targets, scanTarget {
+			kind: kind,
+			args: args,
+		})
+	}
+	for _, target := range this.Targets {
+		switch {
+		case target.Container != "":
+			if target.Packages {
+				add("docker-pkg", target.Container)
+			}
+			if len(target.Files) > 0 {
+				add("docker-files", append([]string { target.Container }, target.Files...)...)
+			}
+			if len(target.Projects) > 0 {
+				add("docker-npm", append([]string { target.Container }, target.Projects...)...)
+			}
+
+		case target.Archive != "":
+			archive := this.path(target.Archive)
+			if target.Packages {
+				add("archive-pkg", archive)
+			}
+			if len(target.Files) > 0 {
+				add("archive-files", append([]string { archive }, target.Files...)...)
+			}
+
+		case target.Image != "" || target.ImageArchive != "" ||
+			target.OCILayout != "" || target.RegistryImage != "" ||
+			target.DiskImage != "":
+			kind, name := "docker-image", target.Image
+			switch {
+			case target.ImageArchive != "":
+				kind, name = "image-archive", this.path(target.ImageArchive)
+			case target.OCILayout != "":
+				kind, name = "oci-layout", this.path(target.OCILayout)
+			case target.RegistryImage != "":
+				kind, name = "registry-image", target.RegistryImage
+			case target.DiskImage != "":
+				kind, name = "disk-image", this.path(target.DiskImage)
+			}
+			config.Targets = append(config.Targets, scanTarget {
+				kind:     kind,
+				args:     []string { name },
+				packages: target.Packages,
+				files:    target.Files,
+				projects: target.Projects,
+			})
+
+		default:
+			if target.Packages {
+				add("pkg")
+			}
+			if len(target.Files) > 0 {
+				add("files", this.paths(target.Files)...)
+			}
+			for _, project := range target.Projects {
+				add("npm", this.path(project))
+			}
+			if len(target.SBOMs) > 0 {
+				add("sbom", this.paths(target.SBOMs)...)
+			}
+		}
+	}
+
+	return config
+}
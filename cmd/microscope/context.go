@@ -0,0 +1,61 @@
+package main
+
+import "os"
+import "io/fs"
+import "context"
+import "os/signal"
+
+// interruptContext returns a context which is cancelled when the program is
+// interrupted. Interrupting the program a second time kills it as usual.
+func interruptContext () (context.Context, func ()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func () {
+		<- ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// contextFS is a filesystem which stops working once its context is done, so
+// that walking and reading it can be cancelled.
+type contextFS struct {
+	fs.FS
+	ctx context.Context
+}
+
+// withContext returns a filesystem which stops working once the context is
+// done.
+func withContext (ctx context.Context, filesystem fs.FS) fs.FS {
+	return contextFS { FS: filesystem, ctx: ctx }
+}
+
+func (this contextFS) Open (name string) (fs.File, error) {
+	err := this.ctx.Err()
+	if err != nil { return nil, &fs.PathError { Op: "open", Path: name, Err: err } }
+	file, err := this.FS.Open(name)
+	if err != nil { return nil, err }
+	return contextFile { File: file, ctx: this.ctx }, nil
+}
+
+func (this contextFS) ReadDir (name string) ([]fs.DirEntry, error) {
+	err := this.ctx.Err()
+	if err != nil { return nil, &fs.PathError { Op: "readdir", Path: name, Err: err } }
+	return fs.ReadDir(this.FS, name)
+}
+
+func (this contextFS) Stat (name string) (fs.FileInfo, error) {
+	err := this.ctx.Err()
+	if err != nil { return nil, &fs.PathError { Op: "stat", Path: name, Err: err } }
+	return fs.Stat(this.FS, name)
+}
+
+type contextFile struct {
+	fs.File
+	ctx context.Context
+}
+
+func (this contextFile) Read (buffer []byte) (int, error) {
+	err := this.ctx.Err()
+	if err != nil { return 0, err }
+	return this.File.Read(buffer)
+}
@@ -0,0 +1,358 @@
+package main
+
+import "os"
+import "fmt"
+import "io/fs"
+import "errors"
+import "strings"
+import "github.com/ajblkf/microscope/localdb"
+import "github.com/ajblkf/microscope/pkgscan"
+import "github.com/ajblkf/microscope/severity"
+
+var dbCommand = command {
+	name: "db",
+	args: "lint|merge|convert OPTION...",
+	help: "Check, merge and convert deny lists",
+	description:
+		"Works with deny lists and suppression lists. Subcommands:\n\n" +
+		"  lint      Check lists for mistakes\n" +
+		"  merge     Merge several lists into one\n" +
+		"  convert   Convert package identifiers between formats",
+}
+
+var dbLintCommand = command {
+	name: "db lint",
+	args: "OPTION...",
+	description:
+		"Checks deny lists and suppression lists for malformed records, invalid\n" +
+		"identifiers, duplicate entries and other mistakes.",
+	exitCodes: []string {
+		"No problems were found",
+		"Problems were found",
+		"The command line is invalid or a list cannot be read",
+	},
+	options: []option {
+		{ name: "help", max: 0,
+			help: "Print this usage information" },
+		{ name: "pkgdb", args: "FILES...", min: 1, max: -1,
+			help: "Check package deny lists" },
+		{ name: "db", args: "FILES...", min: 1, max: -1,
+			help: "Check file deny lists" },
+		{ name: "suppress", args: "FILES...", min: 1, max: -1,
+			help: "Check suppression lists" },
+	},
+}
+
+var dbMergeCommand = command {
+	name: "db merge",
+	args: "OPTION...",
+	description:
+		"Merges several lists of the same kind into one, which is written to " +
+		"standard\noutput. Duplicate entries are combined, joining their " +
+		"reasons if they differ and\nfilling in blank severities, scores and " +
+		"advisories.",
+	exitCodes: []string {
+		"The lists were merged",
+		"The output could not be written",
+		"The command line is invalid or a list cannot be read",
+	},
+	options: []option {
+		{ name: "help", max: 0,
+			help: "Print this usage information" },
+		{ name: "pkgdb", args: "FILES...", min: 1, max: -1,
+			help: "Merge package deny lists" },
+		{ name: "db", args: "FILES...", min: 1, max: -1,
+			help: "Merge file deny lists" },
+		{ name: "suppress", args: "FILES...", min: 1, max: -1,
+			help: "Merge suppression lists" },
+	},
+}
+
+var dbConvertCommand = command {
+	name: "db convert",
+	args: "OPTION...",
+	description:
+		"Converts the package identifiers in a package deny list to another " +
+		"format, and\nwrites the result to standard output.",
+	exitCodes: []string {
+		"Every identifier was converted",
+		"Some identifiers could not be converted, or lost their type or architecture",
+		"The command line is invalid or a list cannot be read",
+	},
+	options: []option {
+		{ name: "help", max: 0,
+			help: "Print this usage information" },
+		{ name: "to", args: "FORMAT", min: 1, max: 1,
+			help: "Specify the format to convert to: purl or legacy (NAME-VERSION-RELEASE:REPOSITORY)" },
+		{ name: "type", args: "TYPE", min: 1, max: 1,
+			help: "Specify the package URL type of identifiers that do not have one, such as deb" },
+		{ name: "pkgdb", args: "FILES...", min: 1, max: -1,
+			help: "Convert package deny lists" },
+	},
+}
+
+func init () {
+	dbCommand.run        = runDB
+	dbLintCommand.run    = runDBLint
+	dbMergeCommand.run   = runDBMerge
+	dbConvertCommand.run = runDBConvert
+}
+
+func runDB (args []string) int {
+	if len(args) == 0 {
+		dbCommand.usage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "lint":    return dbLintCommand.run(args[1:])
+	case "merge":   return dbMergeCommand.run(args[1:])
+	case "convert": return dbConvertCommand.run(args[1:])
+	case "help", "-help", "--help", "-h":
+		dbCommand.usage(os.Stdout)
+		return exitSuccess
+	default:
+		printError(errors.New(fmt.Sprint("unknown subcommand ", args[0])))
+		dbCommand.usage(os.Stderr)
+		return exitUsage
+	}
+}
+
+// dbFile is a list file named on the command line, along with what kind of
+// list it is.
+type dbFile struct {
+	name string
+	kind string
+}
+
+func dbFiles (parsed []parsedOption) []dbFile {
+	var files []dbFile
+	for _, option := range parsed {
+		for _, name := range option.args {
+			files = append(files, dbFile { name: name, kind: option.name })
+		}
+	}
+	return files
+}
+
+func readRecords (name string) ([]localdb.Record, error) {
+	file, err := os.Open(name)
+	if err != nil { return nil, err }
+	defer file.Close()
+	records, err := localdb.ReadRecords(file)
+	if err != nil { return records, fmt.Errorf("%v: %w", name, err) }
+	return records, nil
+}
+
+// normalizeIdentifier returns a canonical form of an identifier in a list of
+// the given kind, so that duplicates can be detected.
+func normalizeIdentifier (kind, identifier string) (string, error) {
+	identifier = strings.TrimSpace(identifier)
+	if kind == "db" || (kind == "suppress" && localdb.IsHash(identifier)) {
+		if !localdb.IsHash(identifier) {
+			return "", errors.New("not a hexadecimal encoded sha256 sum")
+		}
+		return strings.ToLower(identifier), nil
+	}
+
+	pkg, err := pkgscan.ParseIdentifier(identifier)
+	if err != nil { return "", err }
+	if pkg.Name == "" {
+		return "", errors.New("package identifier has no name")
+	}
+	return fmt.Sprintf("%#v", pkg), nil
+}
+
+func runDBLint (args []string) int {
+	parsed, code, ok := dbLintCommand.parseRequired(args)
+	if !ok { return code }
+
+	problems := 0
+	problem := func (name string, line int, format string, values ...any) {
+		problems ++
+		fmt.Printf("%v:%v: %v\n", name, line, fmt.Sprintf(format, values...))
+	}
+
+	for _, file := range dbFiles(parsed) {
+		records, err := readRecords(file.name)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			printError(err)
+			return exitUsage
+		}
+		if err != nil {
+			// a malformed record stops the list from being read any
+			// further, but the records before it can still be checked
+			problems ++
+			fmt.Println(err)
+		}
+
+		seen := map[string] int { }
+		for _, record := range records {
+			if strings.TrimSpace(record.Identifier) != record.Identifier {
+				problem (
+					file.name, record.Line,
+					"identifier has surrounding whitespace")
+			}
+			if file.kind == "db" && strings.ToLower(record.Identifier) != record.Identifier {
+				problem (
+					file.name, record.Line,
+					"hash is not lowercase, and will never match")
+			}
+			if strings.TrimSpace(record.Reason) == "" {
+				problem(file.name, record.Line, "reason is empty")
+			}
+
+			normalized, err := normalizeIdentifier(file.kind, record.Identifier)
+			if err != nil {
+				problem(file.name, record.Line, "%v", err)
+				continue
+			}
+			if previous, duplicate := seen[normalized]; duplicate {
+				problem (
+					file.name, record.Line,
+					"duplicate of line %v", previous)
+				continue
+			}
+			seen[normalized] = record.Line
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%v: %v problems\n", os.Args[0], problems)
+	if problems > 0 { return exitFailure }
+	return exitSuccess
+}
+
+func runDBMerge (args []string) int {
+	parsed, code, ok := dbMergeCommand.parseRequired(args)
+	if !ok { return code }
+
+	files := dbFiles(parsed)
+	for _, file := range files {
+		if file.kind != files[0].kind {
+			printError(errors.New("cannot merge lists of different kinds"))
+			return exitUsage
+		}
+	}
+
+	var merged []localdb.Record
+	indices := map[string] int { }
+	for _, file := range files {
+		records, err := readRecords(file.name)
+		if err != nil {
+			printError(err)
+			return exitUsage
+		}
+
+		for _, record := range records {
+			normalized, err := normalizeIdentifier(file.kind, record.Identifier)
+			if err != nil {
+				printError(fmt.Errorf("%v:%v: %w", file.name, record.Line, err))
+				return exitUsage
+			}
+
+			index, duplicate := indices[normalized]
+			if !duplicate {
+				indices[normalized] = len(merged)
+				merged = append(merged, record)
+				continue
+			}
+
+			existing := &merged[index]
+			reason := strings.TrimSpace(record.Reason)
+			if reason != "" && !strings.Contains(existing.Reason, reason) {
+				existing.Reason += "; " + reason
+			}
+			if existing.Severity == severity.Unknown { existing.Severity = record.Severity }
+			if existing.CVSS     == ""               { existing.CVSS     = record.CVSS }
+			if existing.Score    == 0                { existing.Score    = record.Score }
+			if existing.Advisory == ""               { existing.Advisory = record.Advisory }
+			if existing.URL      == ""               { existing.URL      = record.URL }
+		}
+	}
+
+	err := localdb.WriteRecords(os.Stdout, merged)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+func runDBConvert (args []string) int {
+	parsed, code, ok := dbConvertCommand.parseRequired(args)
+	if !ok { return code }
+
+	to    := ""
+	kind  := ""
+	var names []string
+	for _, option := range parsed {
+		switch option.name {
+		case "to":    to   = option.args[0]
+		case "type":  kind = option.args[0]
+		case "pkgdb": names = append(names, option.args...)
+		}
+	}
+	if to != "purl" && to != "legacy" {
+		printError(errors.New("-to must be either purl or legacy"))
+		return exitUsage
+	}
+	if len(names) == 0 {
+		printError(errors.New("no lists given"))
+		return exitUsage
+	}
+
+	failed := false
+	var converted []localdb.Record
+	for _, name := range names {
+		records, err := readRecords(name)
+		if err != nil {
+			printError(err)
+			return exitUsage
+		}
+
+		for _, record := range records {
+			pkg, err := pkgscan.ParseIdentifier(record.Identifier)
+			if err != nil {
+				printError(fmt.Errorf("%v:%v: %w", name, record.Line, err))
+				failed = true
+				continue
+			}
+
+			switch to {
+			case "purl":
+				if pkg.Type == "" { pkg.Type = kind }
+				if pkg.Type == "" {
+					printError(fmt.Errorf (
+						"%v:%v: package has no type, use -type to specify one",
+						name, record.Line))
+					failed = true
+					continue
+				}
+				record.Identifier = pkg.PURL()
+			case "legacy":
+				if pkg.Type != "" || pkg.Arch != "" {
+					// the entry is still written, but would match
+					// packages of any type and architecture
+					printError(fmt.Errorf (
+						"%v:%v: type and architecture cannot be represented, " +
+						"and were dropped", name, record.Line))
+					failed = true
+				}
+				record.Identifier = pkg.String()
+				if pkg.Version == "" && pkg.Epoch == "" &&
+					pkg.Release == "" && pkg.Repository == "" {
+					// a bare name matches every version
+					record.Identifier, _, _ = strings.Cut(record.Identifier, "--:")
+				}
+			}
+			converted = append(converted, record)
+		}
+	}
+
+	err := localdb.WriteRecords(os.Stdout, converted)
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+	if failed { return exitFailure }
+	return exitSuccess
+}
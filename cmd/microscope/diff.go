@@ -0,0 +1,102 @@
+package main
+
+import "os"
+import "fmt"
+import "github.com/ajblkf/microscope/report"
+
+var diffCommand = command {
+	name: "diff",
+	args: "OLD NEW",
+	help: "Compare the results of two scans",
+	description:
+		"Compares two scan results written by 'scan -format json', and " +
+		"lists findings\nthat were added (+) or removed (-) in NEW.",
+	exitCodes: []string {
+		"NEW has no findings that OLD did not",
+		"NEW has findings that OLD did not",
+		"The command line is invalid or a result cannot be read",
+	},
+}
+
+func init () { diffCommand.run = runDiff }
+
+func runDiff (args []string) int {
+	if len(args) == 1 {
+		switch args[0] {
+		case "help", "-help", "--help", "-h":
+			diffCommand.usage(os.Stdout)
+			return exitSuccess
+		}
+	}
+	if len(args) != 2 {
+		diffCommand.usage(os.Stderr)
+		return exitUsage
+	}
+
+	old, err := readReport(args[0])
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	new, err := readReport(args[1])
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+
+	oldFindings := findingSet(old)
+	newFindings := findingSet(new)
+	added, removed := 0, 0
+	for _, finding := range findingList(old) {
+		if newFindings[finding] { continue }
+		fmt.Println("-", finding)
+		removed ++
+	}
+	for _, finding := range findingList(new) {
+		if oldFindings[finding] { continue }
+		fmt.Println("+", finding)
+		added ++
+	}
+
+	fmt.Fprintf (
+		os.Stderr, "%v: %v added, %v removed\n",
+		os.Args[0], added, removed)
+	if added > 0 { return exitFailure }
+	return exitSuccess
+}
+
+func readReport (name string) (*report.Report, error) {
+	file, err := os.Open(name)
+	if err != nil { return nil, err }
+	defer file.Close()
+	result, err := report.ReadJSON(file)
+	if err != nil { return nil, fmt.Errorf("%v: %w", name, err) }
+	return result, nil
+}
+
+// findingList returns a line of text describing each unsuppressed finding in a
+// report, prefixed by the target it was found in.
+func findingList (result *report.Report) []string {
+	var findings []string
+	for _, finding := range result.AllFiles() {
+		findings = append(findings, fmt.Sprintf (
+			"%v %v\t%v",
+			finding.Target.Kind, finding.Target.Name,
+			finding.Vulnerability))
+	}
+	for _, finding := range result.AllPackages() {
+		findings = append(findings, fmt.Sprintf (
+			"%v %v\t%v",
+			finding.Target.Kind, finding.Target.Name,
+			finding.Vulnerability))
+	}
+	return findings
+}
+
+func findingSet (result *report.Report) map[string] bool {
+	set := map[string] bool { }
+	for _, finding := range findingList(result) {
+		set[finding] = true
+	}
+	return set
+}
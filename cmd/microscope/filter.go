@@ -0,0 +1,129 @@
+package main
+
+import "fmt"
+import "errors"
+import "strconv"
+import "strings"
+import "github.com/ajblkf/microscope/binscan"
+
+// scanFilter describes which files are checked by file scans. Unset values are
+// nil.
+type scanFilter struct {
+	Include         []string `yaml:"include"`
+	Exclude         []string `yaml:"exclude"`
+	IncludeRegex    []string `yaml:"includeRegex"`
+	ExcludeRegex    []string `yaml:"excludeRegex"`
+	MaxSize         *string  `yaml:"maxSize"`
+	Types           []string `yaml:"types"`
+	OneFilesystem   *bool    `yaml:"oneFilesystem"`
+	// Whether to skip pseudo-filesystems such as /proc when scanning /
+	DefaultExcludes *bool    `yaml:"defaultExcludes"`
+	// How many archives deep to check files inside of archives, and how
+	// much may be decompressed out of each archive
+	NestedDepth     *int     `yaml:"nestedDepth"`
+	NestedMaxSize   *string  `yaml:"nestedMaxSize"`
+}
+
+// defaultNestedMaxSize is how much may be decompressed out of each archive by
+// default, and nestedMaxRatio how many times larger than itself each archive
+// may grow once decompressed.
+const (
+	defaultNestedMaxSize = 1 << 30
+	nestedMaxRatio       = 100
+)
+
+func (this *scanFilter) override (other scanFilter) {
+	if other.Include         != nil { this.Include         = other.Include }
+	if other.Exclude         != nil { this.Exclude         = other.Exclude }
+	if other.IncludeRegex    != nil { this.IncludeRegex    = other.IncludeRegex }
+	if other.ExcludeRegex    != nil { this.ExcludeRegex    = other.ExcludeRegex }
+	if other.MaxSize         != nil { this.MaxSize         = other.MaxSize }
+	if other.Types           != nil { this.Types           = other.Types }
+	if other.OneFilesystem   != nil { this.OneFilesystem   = other.OneFilesystem }
+	if other.DefaultExcludes != nil { this.DefaultExcludes = other.DefaultExcludes }
+	if other.NestedDepth     != nil { this.NestedDepth     = other.NestedDepth }
+	if other.NestedMaxSize   != nil { this.NestedMaxSize   = other.NestedMaxSize }
+}
+
+// filters returns the filter to use for file scans, along with the filter to
+// use when scanning the root directory of the local system.
+func (this *scanFilter) filters () (*binscan.Filter, *binscan.Filter, error) {
+	filter := &binscan.Filter { Types: this.Types }
+	if this.OneFilesystem != nil { filter.OneFilesystem = *this.OneFilesystem }
+	if this.MaxSize != nil {
+		size, err := parseSize(*this.MaxSize)
+		if err != nil { return nil, nil, err }
+		filter.MaxSize = size
+	}
+	err := filter.Validate()
+	if err != nil { return nil, nil, err }
+
+	add := func (list *[]binscan.Pattern, parse func (string) (binscan.Pattern, error), sources []string) error {
+		for _, source := range sources {
+			pattern, err := parse(source)
+			if err != nil { return err }
+			*list = append(*list, pattern)
+		}
+		return nil
+	}
+	for _, err := range []error {
+		add(&filter.Include, binscan.ParseGlob,   this.Include),
+		add(&filter.Exclude, binscan.ParseGlob,   this.Exclude),
+		add(&filter.Include, binscan.ParseRegexp, this.IncludeRegex),
+		add(&filter.Exclude, binscan.ParseRegexp, this.ExcludeRegex),
+	} {
+		if err != nil { return nil, nil, err }
+	}
+
+	if this.DefaultExcludes != nil && !*this.DefaultExcludes {
+		return filter, filter, nil
+	}
+	root := *filter
+	root.Exclude = append([]binscan.Pattern(nil), filter.Exclude...)
+	err = add(&root.Exclude, binscan.ParseGlob, binscan.PseudoFilesystems)
+	if err != nil { return nil, nil, err }
+	return filter, &root, nil
+}
+
+// nested returns how file scans descend into archives, or nil if they do not.
+func (this *scanFilter) nested () (*binscan.Nested, error) {
+	if this.NestedDepth != nil && *this.NestedDepth < 0 {
+		return nil, errors.New(fmt.Sprint("invalid nested depth ", *this.NestedDepth))
+	}
+	nested := &binscan.Nested {
+		MaxSize:  defaultNestedMaxSize,
+		MaxRatio: nestedMaxRatio,
+	}
+	if this.NestedMaxSize != nil {
+		size, err := parseSize(*this.NestedMaxSize)
+		if err != nil { return nil, err }
+		nested.MaxSize = size
+	}
+	if this.NestedDepth == nil || *this.NestedDepth == 0 { return nil, nil }
+	nested.Depth = *this.NestedDepth
+	return nested, nil
+}
+
+// parseSize parses a number of bytes, which may have a K, M, G or T suffix
+// meaning a power of 1024.
+func parseSize (text string) (int64, error) {
+	number := strings.TrimSpace(strings.ToUpper(text))
+	number  = strings.TrimSuffix(number, "B")
+	number  = strings.TrimSuffix(number, "I")
+	multiplier := int64(1)
+	if number != "" {
+		switch number[len(number) - 1] {
+		case 'K': multiplier = 1 << 10
+		case 'M': multiplier = 1 << 20
+		case 'G': multiplier = 1 << 30
+		case 'T': multiplier = 1 << 40
+		}
+		if multiplier > 1 { number = number[:len(number) - 1] }
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New(fmt.Sprint("invalid size ", text))
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import "os"
+import "fmt"
+import "errors"
+import "runtime"
+import "github.com/ajblkf/microscope/pkgscan"
+import "github.com/ajblkf/microscope/imagefs"
+import "github.com/ajblkf/microscope/container"
+
+var sbomCommand = command {
+	name: "sbom",
+	args: "OPTION...",
+	help: "List the packages installed in targets without checking them",
+	description:
+		"Lists the packages installed on systems, containers, archives and " +
+		"projects,\nwithout checking them against any deny lists.",
+	exitCodes: []string {
+		"Every target was read",
+		"Errors were reported",
+		"The command line is invalid",
+	},
+	options: []option {
+		{ name: "help", max: 0,
+			help: "Print this usage information" },
+		{ name: "format", args: "FORMAT", min: 1, max: 1,
+			help: "Specify the output format: text (package URLs, default) or cyclonedx" },
+		{ name: "pkg", max: 0,
+			help: "List packages installed on the system" },
+		{ name: "npm", args: "PROJECT-DIRECTORY", min: 1, max: 1,
+			help: "List dependencies of an NPM project" },
+		{ name: "docker-pkg", args: "CONTAINER", min: 1, max: 1,
+			help: "List packages installed in a container" },
+		{ name: "archive-pkg", args: "ARCHIVE", min: 1, max: 1,
+			help: "List packages installed in an archive or image of a filesystem, or the\n" +
+				"package described by a .deb, .rpm or .apk file" },
+		{ name: "docker-npm", args: "CONTAINER PROJECT-DIRECTORIES...", min: 2, max: -1,
+			help: "List dependencies of an NPM project inside of a container" },
+		{ name: "docker-image", args: "IMAGE", min: 1, max: 1,
+			help: "List packages installed in a docker image" },
+		{ name: "image-archive", args: "ARCHIVE", min: 1, max: 1,
+			help: "List packages installed in an image archive written by docker save" },
+		{ name: "oci-layout", args: "LAYOUT", min: 1, max: 1,
+			help: "List packages installed in an OCI image layout directory or archive" },
+		{ name: "registry-image", args: "REFERENCE", min: 1, max: 1,
+			help: "List packages installed in an image pulled from a registry" },
+		{ name: "disk-image", args: "IMAGE", min: 1, max: 1,
+			help: "List packages installed in the partitions of a raw disk image" },
+		{ name: "platform", args: "OS/ARCH[/VARIANT]", min: 1, max: 1,
+			help: "Choose which image to list in OCI layouts and registries holding images\n" +
+				"for several platforms (default linux/" + runtime.GOARCH + ")" },
+		{ name: "runtime", args: "RUNTIME", min: 1, max: 1,
+			help: "Access containers and docker images with docker, podman or nerdctl, or\n" +
+				"read containers from a containers/storage or docker data directory" },
+		{ name: "stream", max: 0,
+			help: "Read containers straight from the output of the runtime in a single pass,\n" +
+				"instead of exporting them to a temporary file" },
+	},
+}
+
+func init () { sbomCommand.run = runSBOM }
+
+func runSBOM (args []string) int {
+	parsed, code, ok := sbomCommand.parseRequired(args)
+	if !ok { return code }
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	inventory := new(pkgscan.Inventory)
+	format    := "text"
+	platform  := imagefs.DefaultPlatform()
+	var errs []error
+
+	appendError := func (err error) {
+		if err == nil { return }
+		errs = append(errs, err)
+	}
+
+	runtimeName := ""
+	stream := false
+	for _, option := range parsed {
+		switch option.name {
+		case "platform":
+			var err error
+			platform, err = imagefs.ParsePlatform(option.args[0])
+			if err != nil {
+				printError(err)
+				return exitUsage
+			}
+		case "runtime":
+			runtimeName = option.args[0]
+		case "stream":
+			stream = true
+		}
+	}
+	containers, err := container.Select(runtimeName)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+
+	for _, option := range parsed {
+	args := option.args
+	switch option.name {
+	case "format":
+		switch args[0] {
+		case "text", "cyclonedx": format = args[0]
+		default:
+			printError(errors.New(fmt.Sprint("unknown format ", args[0])))
+			return exitUsage
+		}
+
+	case "pkg":
+		_, err := pkgscan.Scan(withContext(ctx, os.DirFS("/")), inventory)
+		appendError(err)
+
+	case "npm":
+		_, err := scanNPMProject(withContext(ctx, os.DirFS(args[0])), ".", inventory)
+		appendError(err)
+
+	case "docker-pkg":
+		filesystem, cleanup, err := openContainerFiles (
+			ctx, containers, stream, args[0], pkgscan.NeedsFile)
+		appendError(err)
+		if err != nil { continue }
+		_, err = pkgscan.Scan(filesystem, inventory)
+		appendError(err)
+		cleanup()
+
+	case "archive-pkg":
+		filesystem, pkg, cleanup, err := openArchive(ctx, args[0])
+		appendError(err)
+		if err != nil { continue }
+		_, err = scanArchivePackages(filesystem, pkg, inventory)
+		appendError(err)
+		cleanup()
+
+	case "docker-npm":
+		filesystem, cleanup, err := openContainerFiles (
+			ctx, containers, stream, args[0], projectFiles(args[1:]))
+		appendError(err)
+		if err != nil { continue }
+		for _, project := range args[1:] {
+			_, err = scanNPMProject(filesystem, fsPath(project), inventory)
+			appendError(err)
+		}
+		cleanup()
+
+	case "docker-image", "image-archive", "oci-layout", "registry-image":
+		_, filesystem, cleanup, err := openImage(ctx, containers, option.name, args[0], platform)
+		appendError(err)
+		if err != nil { continue }
+		_, err = pkgscan.Scan(withContext(ctx, filesystem), inventory)
+		appendError(err)
+		cleanup()
+
+	case "disk-image":
+		partitions, cleanup, err := openDiskImage(ctx, args[0])
+		appendError(err)
+		if err != nil { continue }
+		for _, partition := range partitions {
+			err := partition.err
+			if err == nil { _, err = pkgscan.Scan(partition.filesystem, inventory) }
+			if err != nil { appendError(fmt.Errorf("%v: %w", partition.name, err)) }
+		}
+		cleanup()
+	}}
+
+	for _, err := range errs {
+		printError(err)
+	}
+
+	packages := inventory.Sorted()
+	switch format {
+	case "text":
+		for _, pkg := range packages {
+			_, err = fmt.Println(pkg.PURL())
+			if err != nil { break }
+		}
+	case "cyclonedx":
+		err = pkgscan.WriteCycloneDX(os.Stdout, packages)
+	}
+	if err != nil {
+		printError(err)
+		return exitFailure
+	}
+
+	if len(errs) > 0 { return exitFailure }
+	return exitSuccess
+}
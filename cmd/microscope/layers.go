@@ -0,0 +1,80 @@
+package main
+
+import "path"
+import "strings"
+import "context"
+import "github.com/ajblkf/microscope/binscan"
+import "github.com/ajblkf/microscope/pkgscan"
+import "github.com/ajblkf/microscope/imagefs"
+
+// attributeFiles records which layer of an image each file finding came from.
+// Findings inside of archives come from the layer which added the outermost
+// archive.
+func attributeFiles (filesystem *imagefs.FS, list []binscan.Vulnerability) {
+	for index := range list {
+		name, _, _ := strings.Cut(list[index].Name, "!/")
+		layer, ok := filesystem.Origin(name)
+		if !ok { continue }
+		list[index].Layer     = layer.Digest
+		list[index].CreatedBy = layer.CreatedBy
+	}
+}
+
+// attributeProject records which layer of an image last changed the lock file
+// of an NPM project, for each finding in that project.
+func attributeProject (filesystem *imagefs.FS, project string, list []pkgscan.Vulnerability) {
+	layer, ok := filesystem.Origin(path.Join(project, "package-lock.json"))
+	if !ok { return }
+	for index := range list {
+		list[index].Layer     = layer.Digest
+		list[index].CreatedBy = layer.CreatedBy
+	}
+}
+
+// attributePackages records which layer of an image installed each package
+// finding. Packages are scanned again as they were below each layer, from the
+// top down, and a finding belongs to the lowest layer it is found at.
+func attributePackages (
+	ctx      context.Context,
+	image    *imagefs.Image,
+	database pkgscan.Database,
+	list     []pkgscan.Vulnerability,
+) error {
+	key := func (vuln pkgscan.Vulnerability) string {
+		return vuln.Package.String() + "\x00" + vuln.Source + "\x00" + vuln.Reason
+	}
+	pending := map[string] []int { }
+	for index, vuln := range list {
+		pending[key(vuln)] = append(pending[key(vuln)], index)
+	}
+	attribute := func (indexes []int, layer imagefs.Layer) {
+		for _, index := range indexes {
+			list[index].Layer     = layer.Digest
+			list[index].CreatedBy = layer.CreatedBy
+		}
+	}
+
+	for count := len(image.Layers) - 1; count > 0 && len(pending) > 0; count -- {
+		if ctx.Err() != nil { return ctx.Err() }
+		filesystem, err := imagefs.Merge(image.Layers[:count])
+		if err != nil { return err }
+		// package lists which cannot be read in lower layers are
+		// treated as empty
+		below, _ := pkgscan.ScanQuietly(withContext(ctx, filesystem), database)
+
+		present := map[string] bool { }
+		for _, vuln := range below {
+			present[key(vuln)] = true
+		}
+		for found, indexes := range pending {
+			if present[found] { continue }
+			attribute(indexes, image.Layers[count])
+			delete(pending, found)
+		}
+	}
+	if len(image.Layers) == 0 { return nil }
+	for _, indexes := range pending {
+		attribute(indexes, image.Layers[0])
+	}
+	return nil
+}
@@ -3,285 +3,140 @@ package main
 import "io"
 import "os"
 import "fmt"
-import "io/fs"
 import "errors"
-import "os/exec"
-import "path/filepath"
-import "compress/gzip"
-import "github.com/nlepage/go-tarfs"
-import "github.com/gabriel-vasile/mimetype"
-import "github.com/ajblkf/microscope/localdb"
-import "github.com/ajblkf/microscope/binscan"
-import "github.com/ajblkf/microscope/pkgscan"
+import "strings"
+
+// Exit codes shared by all commands
+const (
+	exitSuccess = 0
+	exitFailure = 1
+	exitUsage   = 2
+	// Used by commands that distinguish errors from other failures
+	exitError   = 3
+)
+
+// command is a subcommand of microscope.
+type command struct {
+	name string
+	// A synopsis of the arguments the command takes
+	args string
+	// A short description printed in the list of commands
+	help string
+	// A longer description printed in the usage message of the command
+	description string
+	// A description of when the command exits with each exit code
+	exitCodes []string
+	options []option
+	run func (args []string) int
+}
 
-func main () {
-	database := new(localdb.Database)
+var commands = []*command {
+	&scanCommand,
+	&sbomCommand,
+	&dbCommand,
+	&diffCommand,
+	&versionCommand,
+}
 
+func main () {
 	args := os.Args[1:]
-	argMap := map[string] []string { }
-	currentFlag := ""
-	for len(args) != 0 {
-		arg := args[0]
-		args = args[1:]
-
-		if len(arg) > 0 && arg[0] == '-' {
-			currentFlag = arg
-			argMap[currentFlag] = nil
-		} else {
-			argMap[currentFlag] = append(argMap[currentFlag], arg)
-		}
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(exitUsage)
 	}
 
-	die := func () {
-		usage()
-		os.Exit(2)
+	switch args[0] {
+	case "help", "-help", "--help", "-h":
+		usage(os.Stdout)
+		os.Exit(exitSuccess)
 	}
-	
-	var tasks   []func ()
-	var binVuln []binscan.Vulnerability
-	var pkgVuln []pkgscan.Vulnerability
-	var errors  []error
 
-	appendError := func (err error) {
-		if err == nil { return }
-		errors = append(errors, err)
-	}
-	appendTask := func (task func ()) {
-		tasks = append(tasks, task)
+	// options without a command are given to the scan command, which was
+	// the only way to run microscope before it had commands
+	if strings.HasPrefix(args[0], "-") {
+		os.Exit(scanCommand.run(args))
 	}
-	appendBinVuln := func (vulns ...binscan.Vulnerability) {
-		binVuln = append(binVuln, vulns...)
-	}
-	appendPkgVuln := func (vulns ...pkgscan.Vulnerability) {
-		pkgVuln = append(pkgVuln, vulns...)
-	}
-
-	for flag, args := range argMap {
-	switch flag {
-	// Specify a deny list of unwanted packages
-	case "-pkgdb":
-		if len(args) != 1 { die() }
-		file, err := os.Open(args[0])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
-			os.Exit(1)
-		}
-		err = database.ReadPackageDb(file)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
-			os.Exit(1)
-		}
-		file.Close()
-
-	// Specify a deny list of unwanted files
-	case "-db":
-		if len(args) != 1 { die() }
-		file, err := os.Open(args[0])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
-			os.Exit(1)
-		}
-		err = database.ReadFileDb(file)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
-			os.Exit(1)
-		}
-		file.Close()
-
-	// Recursively scan a list of files or directories
-	case "-files": if len(args) == 0 { die() }; appendTask(func () {
-		for _, file := range args {
-			list, err := binscan.Scan(os.DirFS(file), ".", database)
-			appendBinVuln(list...)
-			appendError(err)
-		}
-	})
-
-	// Scan packages installed on the system
-	case "-pkg": if len(args) != 0 { die() }; appendTask(func () {
-		list, err := pkgscan.Scan(os.DirFS("/"), database)
-		appendPkgVuln(list...)
-		appendError(err)
-	})
-
-	// Scan dependencies of an NPM project
-	case "-npm": if len(args) != 1 { die() }; appendTask(func () {
-		for _, project := range args {
-			list, err := scanNPMProject(os.DirFS(project), ".", database)
-			appendPkgVuln(list...)
-			appendError(err)
-		}
-	})
-
-	// Scan files installed in a docker container
-	case "-docker-files": if len(args) == 0 { die() }; appendTask(func () {
-		if len(args) == 0 { return }
-		temporary, err := extractDockerContainer(args[0])
-		appendError(err)
-		if err != nil { return }
-		defer temporary.Close()
-		defer os.Remove(temporary.Name())
-		filesystem, err := archiveFs(temporary)
-		appendError(err)
-		if err != nil { return }
-
-		for _, file := range args[1:] {
-			list, err := binscan.Scan(filesystem, file, database)
-			appendBinVuln(list...)
-			appendError(err)
-		}
-	})
-
-	// Scan packages installed in a docker container
-	case "-docker-pkg": if len(args) == 0 { die() }; appendTask(func () {
-		if len(args) == 0 { return }
-		temporary, err := extractDockerContainer(args[0])
-		appendError(err)
-		if err != nil { return }
-		defer temporary.Close()
-		defer os.Remove(temporary.Name())
-		filesystem, err := archiveFs(temporary)
-		appendError(err)
-		if err != nil { return }
-		
-		list, err := pkgscan.Scan(filesystem, database)
-		appendPkgVuln(list...)
-		appendError(err)
-	})
-
-	// Scan files contained in an archive
-	case "-archive-files": if len(args) == 0 { die() }; appendTask(func () {
-		file, err := os.Open(args[0])
-		appendError(err)
-		if err != nil { return }
-		defer file.Close()
-		filesystem, err := archiveFs(file)
-		appendError(err)
-		if err != nil { return }
-
-		for _, file := range args[1:] {
-			list, err := binscan.Scan(filesystem, file, database)
-			appendBinVuln(list...)
-			appendError(err)
-		}
-	})
 
-	// Scan packages installed in an archive of a filesystem
-	case "-archive-pkg": if len(args) != 1 { die() }; appendTask(func () {
-		file, err := os.Open(args[0])
-		appendError(err)
-		if err != nil { return }
-		defer file.Close()
-		filesystem, err := archiveFs(file)
-		appendError(err)
-		if err != nil { return }
-
-		list, err := pkgscan.Scan(filesystem, database)
-		appendPkgVuln(list...)
-		appendError(err)
-	})
-
-	// Scan dependencies of an NPM project inside of a docker container
-	case "-docker-npm": if len(args) < 2 { die () }; appendTask(func () {
-		if len(args) == 0 { return }
-		temporary, err := extractDockerContainer(args[0])
-		appendError(err)
-		if err != nil { return }
-		defer temporary.Close()
-		defer os.Remove(temporary.Name())
-		filesystem, err := archiveFs(temporary)
-		appendError(err)
-		if err != nil { return }
-
-		for _, project := range args[1:] {
-			list, err := scanNPMProject(filesystem, project, database)
-			appendPkgVuln(list...)
-			appendError(err)
+	for _, command := range commands {
+		if command.name == args[0] {
+			os.Exit(command.run(args[1:]))
 		}
-	})
-
-	default: die()
-	}}
-
-	for _, task := range tasks {
-		task()
 	}
 
-	for _, err := range errors {
-		fmt.Fprintf (
-			os.Stderr, "%v: %v\n",
-			os.Args[0], err)
-	}
-	for _, vulnerability := range binVuln {
-		fmt.Println(vulnerability)
-	}
-	for _, vulnerability := range pkgVuln {
-		fmt.Println(vulnerability)
-	}
-	
-	fmt.Fprintf (
-		os.Stderr, "%v: %v errors, %v vulns\n",
-		os.Args[0], len(errors), len(pkgVuln) + len(binVuln))
-	if len(errors) > 0 || len(pkgVuln) > 0 || len(binVuln) > 0 {
-		os.Exit(1)
-	}
+	printError(errors.New(fmt.Sprint("unknown command ", args[0])))
+	usage(os.Stderr)
+	os.Exit(exitUsage)
 }
 
-func usage () {
+func usage (output io.Writer) {
 	fmt.Fprintf (
-		os.Stderr, "Usage: %s OPTION... \n\n",
+		output, "Usage: %s COMMAND [OPTION...]\n\n",
+		os.Args[0])
+	fmt.Fprintf (
+		output, "Microscope identifies vulnerable or unwanted software " +
+		"installed on a system.\n\nCommands:\n")
+	for _, command := range commands {
+		fmt.Fprintf(output, "  %-9v %v\n", command.name, command.help)
+	}
+	fmt.Fprintf (
+		output, "\nRun '%s COMMAND -help' for more information on a " +
+		"command. If no command\nis given, scan is assumed.\n",
 		os.Args[0])
-	// TODO print options
 }
 
-func extractDockerContainer (containerName string) (*os.File, error) {
-	temp, err := os.CreateTemp("", "microscope_*.tar")
-	if err != nil { return nil, err }
-	tempName := temp.Name()
-	temp.Close()
-
-	command := exec.Command (
-			"docker", "export",
-			"--output=" + tempName,
-			containerName)
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
-	fmt.Fprintf (
-		os.Stderr, "%v: running %v\n",
-		os.Args[0], command)
-	err = command.Run()
-	if err != nil {
+// usage prints the usage message of the command.
+func (this *command) usage (output io.Writer) {
 	fmt.Fprintf (
-		os.Stderr, "%v: docker extract error: %v\n",
-		os.Args[0], err)
+		output, "Usage: %s %s %s\n\n",
+		os.Args[0], this.name, this.args)
+	fmt.Fprintf(output, "%v\n", this.description)
+	if len(this.options) > 0 {
+		fmt.Fprintf(output, "\nOptions:\n")
+		printOptions(output, this.options)
+		fmt.Fprintf (
+			output, "\nA -- separator ends the options: every argument after it is given to\n" +
+			"the option before it, even if it begins with a dash.\n")
+	}
+	if len(this.exitCodes) > 0 {
+		fmt.Fprintf(output, "\nExit status:\n")
+		for code, description := range this.exitCodes {
+			// codes the command never exits with are left blank
+			if description == "" { continue }
+			fmt.Fprintf(output, "  %v  %v\n", code, description)
+		}
 	}
-	
-	return os.Open(tempName)
 }
 
-func archiveFs (file io.ReadSeeker) (fs.FS, error) {
-	mime, err := mimetype.DetectReader(file)
-	if err != nil { return nil, err }
-	file.Seek(0, io.SeekStart)
-
-	switch {
-	case mime.Is("application/gzip"):
-		file, err := gzip.NewReader(file)
-		if err != nil { return nil, err }
-		return tarfs.New(file)
-		
-	case mime.Is("application/x-tar"):
-		return tarfs.New(file)
+// parse parses the arguments of the command. If the arguments are invalid,
+// or if -help was given, it prints a message and returns false along with the
+// exit code the command should return.
+func (this *command) parse (args []string) ([]parsedOption, int, bool) {
+	parsed, err := parseOptions(this.options, args)
+	if err != nil {
+		printError(err)
+		this.usage(os.Stderr)
+		return nil, exitUsage, false
+	}
+	for _, option := range parsed {
+		if option.name == "help" {
+			this.usage(os.Stdout)
+			return nil, exitSuccess, false
+		}
+	}
+	return parsed, exitSuccess, true
+}
 
-	default:
-		return nil, errors.New(fmt.Sprint("unknown file type ", mime))
+// parseRequired is like parse, but also fails if no options were given.
+func (this *command) parseRequired (args []string) ([]parsedOption, int, bool) {
+	parsed, code, ok := this.parse(args)
+	if ok && len(parsed) == 0 {
+		this.usage(os.Stderr)
+		return nil, exitUsage, false
 	}
+	return parsed, code, ok
 }
 
-func scanNPMProject (filesystem fs.FS, project string, database pkgscan.Database) ([]pkgscan.Vulnerability, error) {
-	packageLock, err := filesystem.Open(
-		filepath.Join(project,
-		"package-lock.json"))
-	if err != nil { return nil, err }
-	return pkgscan.ScanNPM(packageLock, database)
+// printError prints an error to stderr, prefixed with the program name.
+func printError (err error) {
+	fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
 }
@@ -0,0 +1,127 @@
+package main
+
+import "io"
+import "fmt"
+import "errors"
+import "strings"
+
+// option describes a command line option.
+type option struct {
+	// The name of the option, without any leading dashes
+	name string
+	// A synopsis of the arguments the option takes, such as "FILE"
+	args string
+	// The minimum and maximum number of arguments the option takes. A
+	// maximum of -1 means there is no limit.
+	min, max int
+	// A description of the option, printed in the usage message
+	help string
+}
+
+// parsedOption is a single occurrence of an option on the command line.
+type parsedOption struct {
+	*option
+	args []string
+}
+
+// parseOptions parses command line arguments according to the given list of
+// options. Options may be specified with one or two leading dashes, may be
+// repeated, and may have their first argument attached with an equals sign, as
+// in --db=FILE. A -- separator ends option parsing: every argument following
+// it is given to the option before it, even if it begins with a dash or another
+// --. Occurrences are returned in the order they were given.
+func parseOptions (options []option, args []string) ([]parsedOption, error) {
+	var parsed []parsedOption
+	var current *parsedOption
+	separated := false
+
+	finish := func () error {
+		if current == nil { return nil }
+		count := len(current.args)
+		if count < current.min || (current.max >= 0 && count > current.max) {
+			return errors.New(fmt.Sprintf (
+				"-%v: expected %v, got %v",
+				current.name, current.argCount(), count))
+		}
+		parsed = append(parsed, *current)
+		current = nil
+		return nil
+	}
+
+	for _, arg := range args {
+		switch {
+		case separated || arg == "-" || !strings.HasPrefix(arg, "-"):
+			if current == nil {
+				return nil, errors.New(fmt.Sprintf (
+					"unexpected argument %v", arg))
+			}
+			current.args = append(current.args, arg)
+
+		case arg == "--":
+			if current == nil {
+				return nil, errors.New("-- must follow an option")
+			}
+			// no more options follow
+			separated = true
+
+		default:
+			err := finish()
+			if err != nil { return nil, err }
+
+			name, value, hasValue := strings.Cut(strings.TrimPrefix(arg[1:], "-"), "=")
+			found := lookupOption(options, name)
+			if found == nil {
+				return nil, errors.New(fmt.Sprintf (
+					"unknown option %v", arg))
+			}
+			current = &parsedOption { option: found }
+			if hasValue {
+				current.args = append(current.args, value)
+			}
+		}
+	}
+
+	err := finish()
+	if err != nil { return nil, err }
+	return parsed, nil
+}
+
+func lookupOption (options []option, name string) *option {
+	for index := range options {
+		if options[index].name == name {
+			return &options[index]
+		}
+	}
+	return nil
+}
+
+func (this *option) argCount () string {
+	switch {
+	case this.min == this.max && this.min == 0:
+		return "no arguments"
+	case this.min == this.max && this.min == 1:
+		return "1 argument"
+	case this.min == this.max:
+		return fmt.Sprint(this.min, " arguments")
+	case this.max < 0 && this.min == 1:
+		return "at least 1 argument"
+	case this.max < 0:
+		return fmt.Sprint("at least ", this.min, " arguments")
+	default:
+		return fmt.Sprint(this.min, " to ", this.max, " arguments")
+	}
+}
+
+// printOptions prints a description of each option in the list.
+func printOptions (output io.Writer, options []option) {
+	for _, option := range options {
+		synopsis := "-" + option.name
+		if option.args != "" {
+			synopsis += " " + option.args
+		}
+		fmt.Fprintf(output, "  %v\n", synopsis)
+		for _, line := range strings.Split(option.help, "\n") {
+			fmt.Fprintf(output, "        %v\n", line)
+		}
+	}
+}
@@ -0,0 +1,894 @@
+package main
+
+import "io"
+import "os"
+import "fmt"
+import "errors"
+import "io/fs"
+import "sync"
+import "time"
+import "context"
+import "runtime"
+import "strconv"
+import "path/filepath"
+import "text/template"
+import "github.com/ajblkf/microscope/localdb"
+import "github.com/ajblkf/microscope/binscan"
+import "github.com/ajblkf/microscope/pkgscan"
+import "github.com/ajblkf/microscope/report"
+import "github.com/ajblkf/microscope/imagefs"
+import "github.com/ajblkf/microscope/severity"
+import "github.com/ajblkf/microscope/container"
+
+var scanCommand = command {
+	name: "scan",
+	args: "OPTION...",
+	help: "Scan targets for files and packages in deny lists",
+	description:
+		"Scans systems, containers, archives and projects for unwanted " +
+		"files and\npackages.",
+	exitCodes: []string {
+		"Nothing was found that fails the scan",
+		"Findings were reported that fail the scan",
+		"The command line, configuration or a deny list is invalid",
+		"Errors occurred while scanning but no findings fail it, or a report could not be written",
+	},
+	options: []option {
+		{ name: "help", max: 0,
+			help: "Print this usage information" },
+		{ name: "config", args: "FILE", min: 1, max: 1,
+			help: "Read a configuration file, instead of " + configFileName + " in the working directory" },
+		{ name: "no-config", max: 0,
+			help: "Do not read " + configFileName + " from the working directory" },
+		{ name: "pkgdb", args: "FILE", min: 1, max: 1,
+			help: "Specify a deny list of unwanted packages" },
+		{ name: "db", args: "FILE", min: 1, max: 1,
+			help: "Specify a deny list of unwanted files" },
+		{ name: "suppress", args: "FILE", min: 1, max: 1,
+			help: "Specify a list of reviewed findings which should not be reported" },
+		{ name: "format", args: "FORMAT", min: 1, max: 1,
+			help: "Specify the output format: text (default), html, markdown or json" },
+		{ name: "template", args: "FILE", min: 1, max: 1,
+			help: "Render the output through a Go text/template, overriding -format" },
+		{ name: "output", args: "FILE", min: 1, max: 1,
+			help: "Write the output to a file instead of standard output" },
+		{ name: "jobs", args: "N", min: 1, max: 1,
+			help: "Check up to N files at once in each target (default is the number of CPUs)" },
+		{ name: "hash-cache", args: "FILE", min: 1, max: 1,
+			help: "Remember the sums of files scanned by -files in an SQLite database, and\n" +
+				"only hash files again if their size, modification time or inode change" },
+		{ name: "rehash", max: 0,
+			help: "Hash every file again, ignoring the sums in the -hash-cache file" },
+		{ name: "cache-prune", max: 0,
+			help: "Remove the sums of files which no longer exist from the -hash-cache file" },
+		{ name: "parallel", args: "N", min: 1, max: 1,
+			help: "Scan up to N targets at once (default 4)" },
+		{ name: "timeout", args: "DURATION", min: 1, max: 1,
+			help: "Stop scanning after a duration such as 10m or 1h30m" },
+		{ name: "task-timeout", args: "DURATION", min: 1, max: 1,
+			help: "Stop scanning each target after a duration" },
+		{ name: "min-severity", args: "SEVERITY", min: 1, max: 1,
+			help: "Only report findings at least this severe: low, medium, high or critical.\n" +
+				"Findings of unknown severity are always reported" },
+		{ name: "fail-on", args: "SEVERITY", min: 1, max: 1,
+			help: "Only fail on findings at least this severe: low, medium, high, critical,\n" +
+				"or none to never fail on findings. Findings of unknown severity always fail" },
+		{ name: "fail-on-errors", args: "[true|false]", min: 0, max: 1,
+			help: "Whether to fail if errors occur while scanning (default true)" },
+		{ name: "max-findings", args: "N", min: 1, max: 1,
+			help: "Only fail if there are more than N findings that would fail the scan" },
+		{ name: "include", args: "GLOBS...", min: 1, max: -1,
+			help: "Only check files matching a glob. A glob containing a slash matches whole\n" +
+				"paths, and * does not match a slash, but ** does. Other globs match names" },
+		{ name: "exclude", args: "GLOBS...", min: 1, max: -1,
+			help: "Skip files and directories matching a glob" },
+		{ name: "include-regex", args: "REGEXES...", min: 1, max: -1,
+			help: "Only check files whose paths match a regular expression" },
+		{ name: "exclude-regex", args: "REGEXES...", min: 1, max: -1,
+			help: "Skip files and directories whose paths match a regular expression" },
+		{ name: "max-size", args: "SIZE", min: 1, max: 1,
+			help: "Skip files larger than a size, such as 512K or 100M" },
+		{ name: "type", args: "TYPES...", min: 1, max: -1,
+			help: "Only check files of a type: elf, pe, macho, script or a MIME type" },
+		{ name: "nested-depth", args: "N", min: 1, max: 1,
+			help: "Check files inside of archives such as tar, zip and jar files, up to N\n" +
+				"archives deep (default 0, which checks archives as they are)" },
+		{ name: "nested-max-size", args: "SIZE", min: 1, max: 1,
+			help: "Stop descending into an archive once this much has been decompressed out\n" +
+				"of it, including the archives inside of it (default 1G)" },
+		{ name: "one-filesystem", max: 0,
+			help: "Skip directories on other filesystems when scanning local files" },
+		{ name: "no-default-excludes", max: 0,
+			help: "Scan /proc, /sys and /dev when scanning / with -files" },
+		{ name: "platform", args: "OS/ARCH[/VARIANT]", min: 1, max: 1,
+			help: "Choose which image to scan in OCI layouts and registries holding images\n" +
+				"for several platforms (default linux/" + runtime.GOARCH + ")" },
+		{ name: "runtime", args: "RUNTIME", min: 1, max: 1,
+			help: "Access containers and docker images with docker, podman or nerdctl, or\n" +
+				"read containers from a containers/storage or docker data directory\n" +
+				"(default is the first of docker, podman and nerdctl that is installed)" },
+		{ name: "stream", max: 0,
+			help: "Read containers straight from the output of the runtime in a single pass,\n" +
+				"instead of exporting them to a temporary file" },
+		{ name: "strict", max: 0,
+			help: "Stop scanning a target at the first file which cannot be read, and report\n" +
+				"it as an error. Otherwise, such files are skipped and listed" },
+		{ name: "files", args: "FILES...", min: 1, max: -1,
+			help: "Recursively scan a list of files or directories" },
+		{ name: "pkg", max: 0,
+			help: "Scan packages installed on the system" },
+		{ name: "npm", args: "PROJECT-DIRECTORY", min: 1, max: 1,
+			help: "Scan dependencies of an NPM project" },
+		{ name: "sbom", args: "FILES...", min: 1, max: -1,
+			help: "Scan packages listed in CycloneDX or SPDX JSON SBOMs" },
+		{ name: "docker-files", args: "CONTAINER FILES...", min: 1, max: -1,
+			help: "Scan files installed in a container" },
+		{ name: "docker-pkg", args: "CONTAINER", min: 1, max: 1,
+			help: "Scan packages installed in a container" },
+		{ name: "archive-files", args: "ARCHIVE FILES...", min: 1, max: -1,
+			help: "Scan files contained in an archive, such as a compressed tar or zip archive,\n" +
+				"an ext4, squashfs or ISO 9660 image, or the payload of a .deb, .rpm or .apk\n" +
+				"package" },
+		{ name: "archive-pkg", args: "ARCHIVE", min: 1, max: 1,
+			help: "Scan packages installed in an archive or image of a filesystem, or the\n" +
+				"package described by a .deb, .rpm or .apk file" },
+		{ name: "docker-npm", args: "CONTAINER PROJECT-DIRECTORIES...", min: 2, max: -1,
+			help: "Scan dependencies of an NPM project inside of a container" },
+		{ name: "docker-image", args: "IMAGE [FILES...]", min: 1, max: -1,
+			help: "Scan packages and files in a docker image, without creating a container.\n" +
+				"Every file is scanned unless some are listed" },
+		{ name: "image-archive", args: "ARCHIVE [FILES...]", min: 1, max: -1,
+			help: "Scan packages and files in an image archive written by docker save" },
+		{ name: "oci-layout", args: "LAYOUT [FILES...]", min: 1, max: -1,
+			help: "Scan packages and files in an OCI image layout directory, or a tar archive\n" +
+				"of one" },
+		{ name: "registry-image", args: "REFERENCE [FILES...]", min: 1, max: -1,
+			help: "Pull an image from a registry and scan its packages and files, logging in\n" +
+				"with the credentials from docker login" },
+		{ name: "disk-image", args: "IMAGE [FILES...]", min: 1, max: -1,
+			help: "Scan packages and files in each partition of a raw disk image with an MBR\n" +
+				"or GPT partition table, such as a VM image converted by qemu-img convert\n" +
+				"-O raw. Every file is scanned unless some are listed" },
+	},
+}
+
+func init () { scanCommand.run = runScan }
+
+// scanConfig describes what a scan should do. It is filled in from the command
+// line and from configuration files.
+type scanConfig struct {
+	PackageDatabases []string
+	FileDatabases    []string
+	Suppressions     []string
+	Targets          []scanTarget
+	Outputs          []scanOutput
+	Policy           scanPolicy
+	// Findings less severe than this are not reported
+	MinSeverity      *severity.Severity
+	// How many files to check at once
+	Jobs             *int
+	// How many targets to scan at once
+	Parallel         *int
+	// How long the whole scan, and each target, may take
+	Timeout          *time.Duration
+	TaskTimeout      *time.Duration
+	// A file to cache the sums of local files in
+	HashCache        string
+	// Whether to ignore sums in the cache
+	Rehash           bool
+	// Whether to remove sums of files which no longer exist from the cache
+	CachePrune       bool
+	// Which files are checked by file scans
+	Filter           scanFilter
+	// Whether a file which cannot be read stops the scan of its target
+	Strict           *bool
+	// Which image to scan in OCI layouts
+	Platform         *imagefs.Platform
+	// The container runtime command or storage directory
+	Runtime          string
+	// Whether to stream containers instead of exporting them to a file
+	Stream           *bool
+}
+
+// scanPolicy decides whether the results of a scan are a failure. Unset
+// values are nil.
+type scanPolicy struct {
+	// Findings at least this severe fail the scan
+	FailOn       *severity.Severity `yaml:"on"`
+	// Whether errors fail the scan
+	FailOnErrors *bool              `yaml:"errors"`
+	// The number of findings allowed before the scan fails
+	MaxFindings  *int               `yaml:"maxFindings"`
+}
+
+// scanTarget is something to be scanned. Its kind is the name of the command
+// line option that would scan it, and its arguments are the arguments of that
+// option. Images are read once and scanned for everything listed in the
+// target.
+type scanTarget struct {
+	kind string
+	args []string
+	// What to scan inside of an image
+	packages bool
+	files    []string
+	projects []string
+}
+
+// scanOutput describes where and how a report should be written.
+type scanOutput struct {
+	Format   string `yaml:"format"`
+	// A text/template file which overrides the format
+	Template string `yaml:"template"`
+	// The file to write to, or an empty string for standard output
+	File     string `yaml:"file"`
+}
+
+func runScan (args []string) int {
+	parsed, code, ok := scanCommand.parse(args)
+	if !ok { return code }
+
+	cli := scanConfig { }
+	cliOutput := scanOutput { }
+	configFile, discover := "", true
+	for _, option := range parsed {
+		args := option.args
+		switch option.name {
+		case "config":    configFile = args[0]
+		case "no-config": discover = false
+		case "pkgdb":     cli.PackageDatabases = append(cli.PackageDatabases, args[0])
+		case "db":        cli.FileDatabases    = append(cli.FileDatabases,    args[0])
+		case "suppress":  cli.Suppressions     = append(cli.Suppressions,     args[0])
+		case "format":    cliOutput.Format   = args[0]
+		case "template":  cliOutput.Template = args[0]
+		case "output":    cliOutput.File     = args[0]
+		case "hash-cache":  cli.HashCache  = args[0]
+		case "runtime":     cli.Runtime    = args[0]
+		case "rehash":      cli.Rehash     = true
+		case "cache-prune": cli.CachePrune = true
+		case "include":       cli.Filter.Include      = append(cli.Filter.Include,      args...)
+		case "exclude":       cli.Filter.Exclude      = append(cli.Filter.Exclude,      args...)
+		case "include-regex": cli.Filter.IncludeRegex = append(cli.Filter.IncludeRegex, args...)
+		case "exclude-regex": cli.Filter.ExcludeRegex = append(cli.Filter.ExcludeRegex, args...)
+		case "type":          cli.Filter.Types        = append(cli.Filter.Types,        args...)
+		case "max-size":      cli.Filter.MaxSize      = &args[0]
+		case "nested-max-size": cli.Filter.NestedMaxSize = &args[0]
+		case "nested-depth":
+			depth, err := strconv.Atoi(args[0])
+			if err != nil || depth < 0 {
+				printError(errors.New(fmt.Sprint (
+					"-nested-depth: not a count: ", args[0])))
+				return exitUsage
+			}
+			cli.Filter.NestedDepth = &depth
+		case "one-filesystem":
+			value := true
+			cli.Filter.OneFilesystem = &value
+		case "strict":
+			value := true
+			cli.Strict = &value
+		case "stream":
+			value := true
+			cli.Stream = &value
+		case "no-default-excludes":
+			value := false
+			cli.Filter.DefaultExcludes = &value
+		case "jobs", "parallel":
+			count, err := strconv.Atoi(args[0])
+			if err != nil || count < 1 {
+				printError(errors.New(fmt.Sprintf (
+					"-%v: not a positive count: %v", option.name, args[0])))
+				return exitUsage
+			}
+			if option.name == "jobs" {
+				cli.Jobs = &count
+			} else {
+				cli.Parallel = &count
+			}
+		case "timeout", "task-timeout":
+			duration, err := time.ParseDuration(args[0])
+			if err != nil || duration <= 0 {
+				printError(errors.New(fmt.Sprintf (
+					"-%v: not a positive duration: %v", option.name, args[0])))
+				return exitUsage
+			}
+			if option.name == "timeout" {
+				cli.Timeout = &duration
+			} else {
+				cli.TaskTimeout = &duration
+			}
+		case "min-severity":
+			level, err := severity.Parse(args[0])
+			if err != nil {
+				printError(err)
+				return exitUsage
+			}
+			cli.MinSeverity = &level
+		case "platform":
+			platform, err := imagefs.ParsePlatform(args[0])
+			if err != nil {
+				printError(err)
+				return exitUsage
+			}
+			cli.Platform = &platform
+		case "fail-on":
+			level, err := severity.Parse(args[0])
+			if err != nil {
+				printError(err)
+				return exitUsage
+			}
+			cli.Policy.FailOn = &level
+		case "fail-on-errors":
+			value := true
+			if len(args) > 0 {
+				parsed, err := strconv.ParseBool(args[0])
+				if err != nil {
+					printError(errors.New(fmt.Sprint (
+						"-fail-on-errors: not a boolean: ", args[0])))
+					return exitUsage
+				}
+				value = parsed
+			}
+			cli.Policy.FailOnErrors = &value
+		case "max-findings":
+			count, err := strconv.Atoi(args[0])
+			if err != nil || count < 0 {
+				printError(errors.New(fmt.Sprint (
+					"-max-findings: not a count: ", args[0])))
+				return exitUsage
+			}
+			cli.Policy.MaxFindings = &count
+		case "docker-image", "image-archive", "oci-layout", "registry-image", "disk-image":
+			files := args[1:]
+			if len(files) == 0 { files = []string { "/" } }
+			cli.Targets = append(cli.Targets, scanTarget {
+				kind:     option.name,
+				args:     args[:1],
+				packages: true,
+				files:    files,
+			})
+		default:
+			cli.Targets = append(cli.Targets, scanTarget {
+				kind: option.name,
+				args: args,
+			})
+		}
+	}
+	if cliOutput != (scanOutput { }) {
+		cli.Outputs = []scanOutput { cliOutput }
+	}
+
+	if configFile == "" && discover {
+		configFile = discoverConfig()
+	}
+	config := scanConfig { }
+	if configFile != "" {
+		fileConfig, err := loadConfig(configFile)
+		if err != nil {
+			printError(err)
+			return exitUsage
+		}
+		config = fileConfig.scanConfig()
+	}
+	config.override(cli)
+
+	if len(config.Targets) == 0 {
+		printError(errors.New("nothing to scan"))
+		scanCommand.usage(os.Stderr)
+		return exitUsage
+	}
+	if len(config.Outputs) == 0 {
+		config.Outputs = []scanOutput { { Format: "text" } }
+	}
+
+	return config.run()
+}
+
+// override replaces each part of the configuration that is also specified by
+// another configuration.
+func (this *scanConfig) override (other scanConfig) {
+	if other.PackageDatabases != nil { this.PackageDatabases = other.PackageDatabases }
+	if other.FileDatabases    != nil { this.FileDatabases    = other.FileDatabases }
+	if other.Suppressions     != nil { this.Suppressions     = other.Suppressions }
+	if other.Targets          != nil { this.Targets          = other.Targets }
+	if other.Outputs          != nil { this.Outputs          = other.Outputs }
+	if other.MinSeverity      != nil { this.MinSeverity      = other.MinSeverity }
+	if other.Jobs             != nil { this.Jobs             = other.Jobs }
+	if other.Parallel         != nil { this.Parallel         = other.Parallel }
+	if other.Timeout          != nil { this.Timeout          = other.Timeout }
+	if other.TaskTimeout      != nil { this.TaskTimeout      = other.TaskTimeout }
+	if other.HashCache        != ""  { this.HashCache        = other.HashCache }
+	if other.Rehash                  { this.Rehash           = true }
+	if other.CachePrune              { this.CachePrune       = true }
+	if other.Strict           != nil { this.Strict           = other.Strict }
+	if other.Platform         != nil { this.Platform         = other.Platform }
+	if other.Runtime          != ""  { this.Runtime          = other.Runtime }
+	if other.Stream           != nil { this.Stream           = other.Stream }
+	this.Policy.override(other.Policy)
+	this.Filter.override(other.Filter)
+}
+
+func (this *scanPolicy) override (other scanPolicy) {
+	if other.FailOn       != nil { this.FailOn       = other.FailOn }
+	if other.FailOnErrors != nil { this.FailOnErrors = other.FailOnErrors }
+	if other.MaxFindings  != nil { this.MaxFindings  = other.MaxFindings }
+}
+
+// exitCode returns the exit code of the scan command given its results.
+func (this *scanPolicy) exitCode (result *report.Report) int {
+	threshold    := severity.Unknown
+	failOnErrors := true
+	maxFindings  := 0
+	if this.FailOn       != nil { threshold    = *this.FailOn }
+	if this.FailOnErrors != nil { failOnErrors = *this.FailOnErrors }
+	if this.MaxFindings  != nil { maxFindings  = *this.MaxFindings }
+
+	// severity.None is used to mean that findings never fail the scan
+	if threshold != severity.None && result.FindingsAtLeast(threshold) > maxFindings {
+		return exitFailure
+	}
+	if failOnErrors && len(result.AllErrors()) > 0 {
+		return exitError
+	}
+	return exitSuccess
+}
+
+func (this *scanConfig) run () int {
+	database     := new(localdb.Database)
+	suppressions := new(localdb.Suppressions)
+
+	for _, name := range this.PackageDatabases {
+		err := readDatabase(name, database.ReadPackageDb)
+		if err != nil {
+			printError(err)
+			return exitUsage
+		}
+	}
+	for _, name := range this.FileDatabases {
+		err := readDatabase(name, database.ReadFileDb)
+		if err != nil {
+			printError(err)
+			return exitUsage
+		}
+	}
+	for _, name := range this.Suppressions {
+		err := readDatabase(name, suppressions.ReadSuppressionDb)
+		if err != nil {
+			printError(err)
+			return exitUsage
+		}
+	}
+
+	templates := make([]*template.Template, len(this.Outputs))
+	for index, output := range this.Outputs {
+		if output.Template != "" {
+			source, err := os.ReadFile(output.Template)
+			if err != nil {
+				printError(err)
+				return exitUsage
+			}
+			templates[index], err = report.ParseTemplate(output.Template, string(source))
+			if err != nil {
+				printError(err)
+				return exitUsage
+			}
+			continue
+		}
+
+		switch output.Format {
+		case "", "text", "html", "markdown", "json":
+		default:
+			printError(errors.New(fmt.Sprint("unknown format ", output.Format)))
+			return exitUsage
+		}
+	}
+
+	resources := scanResources {
+		database: database,
+		jobs:     runtime.NumCPU(),
+	}
+	if this.Jobs   != nil { resources.jobs   = *this.Jobs }
+	if this.Strict != nil { resources.strict = *this.Strict }
+	if this.Stream != nil { resources.stream = *this.Stream }
+	resources.platform = imagefs.DefaultPlatform()
+	if this.Platform != nil { resources.platform = *this.Platform }
+	var err error
+	resources.filter, resources.rootFilter, err = this.Filter.filters()
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	resources.nested, err = this.Filter.nested()
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	resources.runtime, err = container.Select(this.Runtime)
+	if err != nil {
+		printError(err)
+		return exitUsage
+	}
+	if this.HashCache != "" {
+		resources.cache, err = localdb.OpenHashCache(this.HashCache)
+		if err != nil {
+			printError(fmt.Errorf("%v: %w", this.HashCache, err))
+			return exitUsage
+		}
+		resources.cache.Rehash = this.Rehash
+	}
+
+	result := this.scan(&resources)
+	if resources.cache != nil && this.CachePrune {
+		_, err := resources.cache.Prune()
+		if err != nil { result.AddError(fmt.Errorf("%v: %w", this.HashCache, err)) }
+	}
+	if resources.cache != nil {
+		err := resources.cache.Close()
+		if err != nil { result.AddError(fmt.Errorf("%v: %w", this.HashCache, err)) }
+	}
+	result.Suppress(suppressions)
+	if this.MinSeverity != nil {
+		result.Filter(*this.MinSeverity)
+	}
+
+	errs := result.AllErrors()
+	for _, err := range errs {
+		printError(err)
+	}
+	for _, target := range result.Targets {
+		for _, file := range target.Skipped {
+			fmt.Fprintf (
+				os.Stderr, "%v: %v: skipped %v: %v\n",
+				os.Args[0], target.Name, file.Name, file.Reason)
+		}
+	}
+
+	for index, output := range this.Outputs {
+		err := writeOutput(output, templates[index], &result)
+		if err != nil {
+			printError(err)
+			return exitError
+		}
+	}
+
+	fmt.Fprintf (
+		os.Stderr, "%v: %v errors, %v vulns, %v suppressed, %v skipped\n",
+		os.Args[0], len(errs), result.Findings(), result.Suppressed(),
+		result.Skipped())
+	return this.Policy.exitCode(&result)
+}
+
+// scan scans every target, several at a time, until they are done, the scan
+// times out, or the program is interrupted. Results are in the same order as
+// the targets.
+func (this *scanConfig) scan (resources *scanResources) report.Report {
+	parallel := 4
+	if this.Parallel != nil { parallel = *this.Parallel }
+
+	interrupted, stop := interruptContext()
+	defer stop()
+	ctx := interrupted
+	if this.Timeout != nil {
+		var cancel func ()
+		ctx, cancel = context.WithTimeout(ctx, *this.Timeout)
+		defer cancel()
+	}
+
+	results := make([]report.Report, len(this.Targets))
+	slots   := make(chan struct { }, parallel)
+	var group sync.WaitGroup
+	for index := range this.Targets {
+		group.Add(1)
+		go func (index int) {
+			defer group.Done()
+			slots <- struct { } { }
+			defer func () { <- slots }()
+
+			ctx := ctx
+			if this.TaskTimeout != nil {
+				var cancel func ()
+				ctx, cancel = context.WithTimeout(ctx, *this.TaskTimeout)
+				defer cancel()
+			}
+			this.Targets[index].scan(ctx, &results[index], resources)
+		}(index)
+	}
+	group.Wait()
+
+	var result report.Report
+	for index := range results {
+		result.Merge(&results[index])
+	}
+	switch {
+	case interrupted.Err() != nil:
+		result.AddError(errors.New("interrupted"))
+	case ctx.Err() != nil:
+		result.AddError(errors.New(fmt.Sprint("timed out after ", *this.Timeout)))
+	}
+	return result
+}
+
+// scanResources holds what is shared by every target being scanned.
+type scanResources struct {
+	database   *localdb.Database
+	// Caches sums of local files, if it is not nil
+	cache      *localdb.HashCache
+	filter     *binscan.Filter
+	// The filter used when scanning the root directory of the local system
+	rootFilter *binscan.Filter
+	// How file scans descend into archives, or nil if they do not
+	nested     *binscan.Nested
+	jobs       int
+	// Whether to stop scanning files at the first file which cannot be read
+	strict     bool
+	// Which image to scan in OCI layouts
+	platform   imagefs.Platform
+	// Opens containers and saves docker images
+	runtime    container.Runtime
+	// Whether to stream containers instead of exporting them to a file
+	stream     bool
+}
+
+// streamer returns the runtime if containers should be streamed, and it can
+// stream them.
+func (this *scanResources) streamer () (container.Streamer, bool) {
+	if !this.stream { return nil, false }
+	streamer, ok := this.runtime.(container.Streamer)
+	return streamer, ok
+}
+
+// scanner returns a file scanner for a filesystem inside of a container or
+// archive. Archives inside of it stop being read once the context is done.
+func (this *scanResources) scanner (ctx context.Context) *binscan.Scanner {
+	scanner := &binscan.Scanner {
+		Database: this.database,
+		Jobs:     this.jobs,
+		Filter:   this.filter,
+		Strict:   this.strict,
+	}
+	if this.nested != nil {
+		nested := *this.nested
+		nested.Database = this.database
+		nested.Wrap = func (filesystem fs.FS) fs.FS { return withContext(ctx, filesystem) }
+		scanner.Nested = &nested
+	}
+	return scanner
+}
+
+// localScanner returns a file scanner for a directory on the local system.
+func (this *scanResources) localScanner (ctx context.Context, directory string) (*binscan.Scanner, error) {
+	root, err := filepath.Abs(directory)
+	if err != nil { return nil, err }
+
+	scanner := this.scanner(ctx)
+	if root == filepath.Dir(root) {
+		scanner.Filter = this.rootFilter
+	}
+	if this.cache != nil {
+		scanner.Database = localdb.CachedDatabase {
+			Database: this.database,
+			Cache:    this.cache,
+			Root:     root,
+		}
+	}
+	return scanner, nil
+}
+
+// scan scans the target, adding its results to a report.
+func (this scanTarget) scan (ctx context.Context, result *report.Report, resources *scanResources) {
+	database := resources.database
+	args := this.args
+	switch this.kind {
+	case "files":
+		for _, file := range args {
+			target := result.NewTarget("files", file)
+			scanner, err := resources.localScanner(ctx, file)
+			target.AddError(err)
+			if err != nil { continue }
+			list, skipped, err := scanner.Scan(withContext(ctx, os.DirFS(file)), ".")
+			target.AddFiles(list...)
+			target.AddSkipped(skipped...)
+			target.AddError(err)
+		}
+
+	case "pkg":
+		target := result.NewTarget("packages", "/")
+		list, err := pkgscan.Scan(withContext(ctx, os.DirFS("/")), database)
+		target.AddPackages(list...)
+		target.AddError(err)
+
+	case "npm":
+		for _, project := range args {
+			target := result.NewTarget("npm", project)
+			list, err := scanNPMProject(withContext(ctx, os.DirFS(project)), ".", database)
+			target.AddPackages(list...)
+			target.AddError(err)
+		}
+
+	case "sbom":
+		for _, document := range args {
+			target := result.NewTarget("sbom", document)
+			list, err := scanSBOM(document, database)
+			target.AddPackages(list...)
+			target.AddError(err)
+		}
+
+	case "docker-files":
+		target := result.NewTarget("container files", args[0])
+		if streamer, ok := resources.streamer(); ok {
+			roots := make([]string, len(args[1:]))
+			for index, file := range args[1:] {
+				roots[index] = fsPath(file)
+			}
+			list, skipped, _, err := streamContainer (
+				ctx, streamer, args[0], resources.scanner(ctx), roots, nil)
+			target.AddFiles(list...)
+			target.AddSkipped(skipped...)
+			target.AddError(err)
+			return
+		}
+		filesystem, cleanup, err := openContainer(ctx, resources.runtime, args[0])
+		target.AddError(err)
+		if err != nil { return }
+		defer cleanup()
+
+		for _, file := range args[1:] {
+			list, skipped, err := resources.scanner(ctx).Scan(filesystem, fsPath(file))
+			target.AddFiles(list...)
+			target.AddSkipped(skipped...)
+			target.AddError(err)
+		}
+
+	case "docker-pkg":
+		target := result.NewTarget("container packages", args[0])
+		filesystem, cleanup, err := openContainerFiles (
+			ctx, resources.runtime, resources.stream, args[0], pkgscan.NeedsFile)
+		target.AddError(err)
+		if err != nil { return }
+		defer cleanup()
+
+		list, err := pkgscan.Scan(filesystem, database)
+		target.AddPackages(list...)
+		target.AddError(err)
+
+	case "archive-files":
+		target := result.NewTarget("archive files", args[0])
+		filesystem, _, cleanup, err := openArchive(ctx, args[0])
+		target.AddError(err)
+		if err != nil { return }
+		defer cleanup()
+
+		for _, file := range args[1:] {
+			list, skipped, err := resources.scanner(ctx).Scan(filesystem, fsPath(file))
+			target.AddFiles(list...)
+			target.AddSkipped(skipped...)
+			target.AddError(err)
+		}
+
+	case "archive-pkg":
+		target := result.NewTarget("archive packages", args[0])
+		filesystem, pkg, cleanup, err := openArchive(ctx, args[0])
+		target.AddError(err)
+		if err != nil { return }
+		defer cleanup()
+
+		list, err := scanArchivePackages(filesystem, pkg, database)
+		target.AddPackages(list...)
+		target.AddError(err)
+
+	case "docker-npm":
+		target := result.NewTarget("container npm", args[0])
+		filesystem, cleanup, err := openContainerFiles (
+			ctx, resources.runtime, resources.stream, args[0], projectFiles(args[1:]))
+		target.AddError(err)
+		if err != nil { return }
+		defer cleanup()
+
+		for _, project := range args[1:] {
+			list, err := scanNPMProject(filesystem, fsPath(project), database)
+			target.AddPackages(list...)
+			target.AddError(err)
+		}
+
+	case "docker-image", "image-archive", "oci-layout", "registry-image":
+		kind := map[string] string {
+			"docker-image":   "image",
+			"image-archive":  "image archive",
+			"oci-layout":     "oci layout",
+			"registry-image": "registry image",
+		}[this.kind]
+		target := result.NewTarget(kind, args[0])
+		image, merged, cleanup, err := openImage(ctx, resources.runtime, this.kind, args[0], resources.platform)
+		target.AddError(err)
+		if err != nil { return }
+		defer cleanup()
+		filesystem := withContext(ctx, merged)
+
+		if this.packages {
+			list, err := pkgscan.Scan(filesystem, database)
+			target.AddError(err)
+			if len(list) > 0 {
+				target.AddError(attributePackages(ctx, image, database, list))
+			}
+			target.AddPackages(list...)
+		}
+		for _, file := range this.files {
+			list, skipped, err := resources.scanner(ctx).Scan(filesystem, fsPath(file))
+			attributeFiles(merged, list)
+			target.AddFiles(list...)
+			target.AddSkipped(skipped...)
+			target.AddError(err)
+		}
+		for _, project := range this.projects {
+			list, err := scanNPMProject(filesystem, fsPath(project), database)
+			attributeProject(merged, fsPath(project), list)
+			target.AddPackages(list...)
+			target.AddError(err)
+		}
+
+	case "disk-image":
+		partitions, cleanup, err := openDiskImage(ctx, args[0])
+		if err != nil {
+			result.NewTarget("disk image", args[0]).AddError(err)
+			return
+		}
+		defer cleanup()
+
+		// each partition is a target of its own
+		for _, partition := range partitions {
+			target := result.NewTarget("disk image", partition.name)
+			target.AddError(partition.err)
+			if partition.err != nil { continue }
+			filesystem := partition.filesystem
+
+			if this.packages {
+				list, err := pkgscan.Scan(filesystem, database)
+				target.AddPackages(list...)
+				target.AddError(err)
+			}
+			for _, file := range this.files {
+				list, skipped, err := resources.scanner(ctx).Scan(filesystem, fsPath(file))
+				target.AddFiles(list...)
+				target.AddSkipped(skipped...)
+				target.AddError(err)
+			}
+			for _, project := range this.projects {
+				list, err := scanNPMProject(filesystem, fsPath(project), database)
+				target.AddPackages(list...)
+				target.AddError(err)
+			}
+		}
+	}
+}
+
+// writeOutput writes a report to the destination described by an output. If
+// tmpl is not nil, it is used instead of the output's format.
+func writeOutput (output scanOutput, tmpl *template.Template, result *report.Report) error {
+	writer := io.Writer(os.Stdout)
+	if output.File != "" {
+		file, err := os.Create(output.File)
+		if err != nil { return err }
+		defer file.Close()
+		writer = file
+	}
+
+	if tmpl != nil {
+		return report.WriteTemplate(writer, tmpl, result)
+	}
+	switch output.Format {
+	case "", "text": return report.WriteText(writer, result)
+	case "html":     return report.WriteHTML(writer, result)
+	case "markdown": return report.WriteMarkdown(writer, result)
+	case "json":     return report.WriteJSON(writer, result)
+	default: return nil
+	}
+}
+
+// readDatabase opens a database file and reads it using the given function.
+func readDatabase (name string, read func (io.Reader) error) error {
+	file, err := os.Open(name)
+	if err != nil { return err }
+	defer file.Close()
+	err = read(file)
+	if err != nil { return fmt.Errorf("%v: %w", name, err) }
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import "io"
+import "os"
+import "fmt"
+import "io/fs"
+import "path"
+import "errors"
+import "strings"
+import "context"
+import "path/filepath"
+import "github.com/ajblkf/microscope/diskfs"
+import "github.com/ajblkf/microscope/binscan"
+import "github.com/ajblkf/microscope/pkgscan"
+import "github.com/ajblkf/microscope/imagefs"
+import "github.com/ajblkf/microscope/archivefs"
+import "github.com/ajblkf/microscope/container"
+
+// openContainer returns the root filesystem of a container, along with a
+// function that releases it. The filesystem stops working once the context is
+// done.
+func openContainer (ctx context.Context, runtime container.Runtime, name string) (fs.FS, func (), error) {
+	filesystem, cleanup, err := runtime.Open(ctx, name)
+	if err != nil { return nil, nil, err }
+	return withContext(ctx, filesystem), cleanup, nil
+}
+
+// streamContainer reads the root filesystem of a container in a single pass,
+// checking the files under the roots as they are read, without storing it
+// anywhere. Files for which keep returns true are returned as a filesystem, so
+// that they can be read once the stream has ended.
+func streamContainer (
+	ctx      context.Context,
+	streamer container.Streamer,
+	name     string,
+	scanner  *binscan.Scanner,
+	roots    []string,
+	keep     func (name string) bool,
+) (
+	[]binscan.Vulnerability,
+	[]binscan.SkippedFile,
+	fs.FS,
+	error,
+) {
+	archive, err := streamer.Stream(ctx, name)
+	if err != nil { return nil, nil, nil, err }
+	list, skipped, kept, err := scanner.ScanTar(archive, roots, keep)
+	// a failed export explains why the archive could not be read, unless
+	// it was stopped by the context
+	closeErr := archive.Close()
+	switch {
+	case ctx.Err() != nil: return list, skipped, nil, ctx.Err()
+	case closeErr  != nil: return list, skipped, nil, closeErr
+	case err       != nil: return list, skipped, nil, err
+	}
+	return list, skipped, withContext(ctx, kept), nil
+}
+
+// openContainerFiles returns a filesystem holding at least the files of a
+// container for which keep returns true, along with a function that releases
+// it. If stream is true and the runtime can stream containers, only those
+// files are kept as the container is read in a single pass.
+func openContainerFiles (
+	ctx     context.Context,
+	runtime container.Runtime,
+	stream  bool,
+	name    string,
+	keep    func (name string) bool,
+) (
+	fs.FS,
+	func (),
+	error,
+) {
+	streamer, ok := runtime.(container.Streamer)
+	if !stream || !ok { return openContainer(ctx, runtime, name) }
+	_, _, filesystem, err := streamContainer (
+		ctx, streamer, name, &binscan.Scanner { }, nil, keep)
+	if err != nil { return nil, nil, err }
+	return filesystem, func () { }, nil
+}
+
+// projectFiles returns a function which tells whether a file is needed to scan
+// the dependencies of a list of NPM projects.
+func projectFiles (projects []string) func (name string) bool {
+	needed := map[string] bool { }
+	for _, project := range projects {
+		needed[path.Join(fsPath(project), "package-lock.json")] = true
+	}
+	return func (name string) bool { return needed[name] }
+}
+
+// openArchive opens an archive and returns its contents as a filesystem, along
+// with a function that closes it. If the archive is a package file, such as a
+// .deb or .rpm, the package it describes is returned too, and the filesystem
+// holds its payload. The filesystem stops working once the context is done.
+func openArchive (ctx context.Context, name string) (fs.FS, *pkgscan.Package, func (), error) {
+	file, err := os.Open(name)
+	if err != nil { return nil, nil, nil, err }
+	archive, err := archivefs.Open(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	cleanup := func () {
+		archive.Close()
+		file.Close()
+	}
+	return withContext(ctx, archive.FS), archive.Package, cleanup, nil
+}
+
+// scanArchivePackages checks the package described by a package file, or the
+// packages installed in an archive which is not one.
+func scanArchivePackages (
+	filesystem fs.FS,
+	pkg        *pkgscan.Package,
+	database   pkgscan.Database,
+) (
+	[]pkgscan.Vulnerability,
+	error,
+) {
+	if pkg == nil { return pkgscan.Scan(filesystem, database) }
+	vulnerability, err := database.CheckPackage(*pkg)
+	if vulnerability == nil { return nil, err }
+	return []pkgscan.Vulnerability { *vulnerability }, err
+}
+
+// diskPartition is a filesystem in a disk image, named after the image and the
+// partition holding it. If it could not be opened, err says why.
+type diskPartition struct {
+	name       string
+	filesystem fs.FS
+	err        error
+}
+
+// openDiskImage opens the filesystems in the partitions of a raw disk image,
+// along with a function that closes the image. Partitions without a supported
+// filesystem, such as swap, are left out. An image of a single filesystem,
+// without a partition table, is opened as one named after the image. The
+// filesystems stop working once the context is done.
+func openDiskImage (ctx context.Context, name string) ([]diskPartition, func (), error) {
+	file, err := os.Open(name)
+	if err != nil { return nil, nil, err }
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	cleanup := func () { file.Close() }
+
+	if diskfs.Detect(file) != "" {
+		filesystem, err := diskfs.Open(file, info.Size())
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return []diskPartition { { name: name, filesystem: withContext(ctx, filesystem) } }, cleanup, nil
+	}
+
+	partitions, err := diskfs.Partitions(file, info.Size())
+	if err == diskfs.ErrNoPartitionTable {
+		magic := make([]byte, 4)
+		file.ReadAt(magic, 0)
+		if string(magic) == "QFI\xfb" {
+			err = errors.New (
+				"qcow2 images must be converted to raw images first, " +
+				"with qemu-img convert -O raw")
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	var found []diskPartition
+	for _, partition := range partitions {
+		section := io.NewSectionReader(file, partition.Start, partition.Size)
+		if diskfs.Detect(section) == "" { continue }
+		label := fmt.Sprint(name, " (partition ", partition.Number, ")")
+		if partition.Name != "" {
+			label = fmt.Sprint(name, " (partition ", partition.Number, ": ", partition.Name, ")")
+		}
+		filesystem, err := diskfs.Open(section, partition.Size)
+		if err == nil { filesystem = withContext(ctx, filesystem) }
+		found = append(found, diskPartition { name: label, filesystem: filesystem, err: err })
+	}
+	if len(found) == 0 {
+		file.Close()
+		return nil, nil, errors.New("no partitions with a supported filesystem")
+	}
+	return found, cleanup, nil
+}
+
+// openImage reads an image for a platform, and merges its layers into a
+// filesystem. It returns both along with a function that closes them. The kind
+// is the name of the command line option for the image: docker-image,
+// image-archive, oci-layout or registry-image. Docker images are saved with
+// the runtime.
+func openImage (
+	ctx      context.Context,
+	runtime  container.Runtime,
+	kind     string,
+	name     string,
+	platform imagefs.Platform,
+) (
+	*imagefs.Image,
+	*imagefs.FS,
+	func (),
+	error,
+) {
+	var file *os.File
+	var err  error
+	var image *imagefs.Image
+	remove := false
+
+	switch kind {
+	case "docker-image":
+		saver, ok := runtime.(container.ImageSaver)
+		if !ok {
+			return nil, nil, nil, errors.New (
+				"images can only be saved by docker, podman or nerdctl, " +
+				"not by a storage directory")
+		}
+		file, err = saver.Save(ctx, name)
+		if err != nil { return nil, nil, nil, err }
+		remove = true
+		image, err = readDockerArchive(file)
+
+	case "image-archive":
+		file, err = os.Open(name)
+		if err != nil { return nil, nil, nil, err }
+		image, err = readDockerArchive(file)
+
+	case "oci-layout":
+		var info fs.FileInfo
+		info, err = os.Stat(name)
+		if err != nil { return nil, nil, nil, err }
+		if info.IsDir() {
+			image, err = imagefs.ReadOCILayout(os.DirFS(name), platform)
+			if err != nil { return nil, nil, nil, err }
+			break
+		}
+		file, err = os.Open(name)
+		if err != nil { return nil, nil, nil, err }
+		image, err = imagefs.ReadOCIArchive(file, info.Size(), platform)
+
+	case "registry-image":
+		reference, err := imagefs.ParseReference(name)
+		if err != nil { return nil, nil, nil, err }
+		fmt.Fprintf (
+			os.Stderr, "%v: pulling %v for %v\n",
+			os.Args[0], reference, platform)
+		registry := imagefs.Registry { Credentials: imagefs.DockerCredentials }
+		image, err = registry.Pull(ctx, reference, platform)
+		if err != nil { return nil, nil, nil, err }
+
+	default:
+		return nil, nil, nil, errors.New(fmt.Sprint("unknown kind of image ", kind))
+	}
+
+	cleanup := func () {
+		if image != nil { image.Close() }
+		if file == nil { return }
+		file.Close()
+		if remove { os.Remove(file.Name()) }
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	filesystem, err := image.FS()
+	if err != nil {
+		cleanup()
+		return nil, nil, nil, err
+	}
+	return image, filesystem, cleanup, nil
+}
+
+// readDockerArchive reads the first image in an archive written by docker
+// save.
+func readDockerArchive (file *os.File) (*imagefs.Image, error) {
+	info, err := file.Stat()
+	if err != nil { return nil, err }
+	return imagefs.ReadDockerArchive(file, info.Size(), "")
+}
+
+// fsPath converts a path inside of a container, image or archive, which may be
+// absolute, into a path of its filesystem.
+func fsPath (name string) string {
+	name = strings.TrimPrefix(path.Clean("/" + name), "/")
+	if name == "" { return "." }
+	return name
+}
+
+func scanNPMProject (filesystem fs.FS, project string, database pkgscan.Database) ([]pkgscan.Vulnerability, error) {
+	packageLock, err := filesystem.Open(
+		filepath.Join(project,
+		"package-lock.json"))
+	if err != nil { return nil, err }
+	return pkgscan.ScanNPM(packageLock, database)
+}
+
+func scanSBOM (name string, database pkgscan.Database) ([]pkgscan.Vulnerability, error) {
+	document, err := os.Open(name)
+	if err != nil { return nil, err }
+	defer document.Close()
+	return pkgscan.ScanSBOM(document, database)
+}
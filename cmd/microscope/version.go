@@ -0,0 +1,64 @@
+package main
+
+import "os"
+import "fmt"
+import "runtime"
+import "runtime/debug"
+
+// version can be set at build time with -ldflags "-X main.version=VERSION".
+// Otherwise, it is taken from the module version in the build information.
+var version = ""
+
+var versionCommand = command {
+	name: "version",
+	help: "Print version and build information",
+	description: "Prints the version of microscope and how it was built.",
+	exitCodes: []string {
+		"The version was printed",
+		"",
+		"The command line is invalid",
+	},
+}
+
+func init () { versionCommand.run = runVersion }
+
+func runVersion (args []string) int {
+	if len(args) == 1 {
+		switch args[0] {
+		case "help", "-help", "--help", "-h":
+			versionCommand.usage(os.Stdout)
+			return exitSuccess
+		}
+	}
+	if len(args) > 0 {
+		versionCommand.usage(os.Stderr)
+		return exitUsage
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	currentVersion := version
+	if currentVersion == "" && ok {
+		currentVersion = info.Main.Version
+	}
+	if currentVersion == "" || currentVersion == "(devel)" {
+		currentVersion = "devel"
+	}
+	fmt.Println("microscope", currentVersion)
+	fmt.Println(runtime.Version(), runtime.GOOS + "/" + runtime.GOARCH)
+	if !ok { return exitSuccess }
+
+	settings := map[string] string { }
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	if revision := settings["vcs.revision"]; revision != "" {
+		if settings["vcs.modified"] == "true" {
+			revision += " (modified)"
+		}
+		fmt.Println("revision", revision)
+	}
+	if built := settings["vcs.time"]; built != "" {
+		fmt.Println("committed", built)
+	}
+	return exitSuccess
+}
@@ -0,0 +1,117 @@
+package container
+
+import "io"
+import "os"
+import "fmt"
+import "io/fs"
+import "context"
+import "os/exec"
+import "github.com/nlepage/go-tarfs"
+
+// CLI is a runtime controlled through a command which accepts the same
+// arguments as docker, such as podman or nerdctl.
+type CLI struct {
+	Command string
+}
+
+// Open exports the root filesystem of a container into a temporary archive,
+// and returns its contents along with a function which removes the archive.
+func (this CLI) Open (ctx context.Context, name string) (fs.FS, func (), error) {
+	temporary, err := this.Export(ctx, name)
+	if err != nil { return nil, nil, err }
+	cleanup := func () {
+		temporary.Close()
+		os.Remove(temporary.Name())
+	}
+	if ctx.Err() != nil {
+		cleanup()
+		return nil, nil, ctx.Err()
+	}
+	filesystem, err := tarfs.New(temporary)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return filesystem, cleanup, nil
+}
+
+// Export exports the root filesystem of a container into a temporary tar
+// archive, which must be closed and removed by the caller. Where the system
+// allows it, the archive is removed as soon as it is created, so that it is
+// not left behind if the program is killed.
+func (this CLI) Export (ctx context.Context, name string) (*os.File, error) {
+	return this.capture(ctx, "microscope_*.tar", "export", name)
+}
+
+// Stream streams the root filesystem of a container as a tar archive from the
+// output of the export command.
+func (this CLI) Stream (ctx context.Context, name string) (io.ReadCloser, error) {
+	command := this.command(ctx, "export", name)
+	output, err := command.StdoutPipe()
+	if err != nil { return nil, err }
+	err = command.Start()
+	if err != nil { return nil, fmt.Errorf("%v export: %w", this.Command, err) }
+	// processes started by the command may keep writing after it has been
+	// killed, so reading is stopped as well once the context is done
+	stop := context.AfterFunc(ctx, func () { output.Close() })
+	return &exportStream { ReadCloser: output, command: command, stop: stop }, nil
+}
+
+// exportStream is the output of a running export command.
+type exportStream struct {
+	io.ReadCloser
+	command *exec.Cmd
+	stop    func () bool
+}
+
+func (this *exportStream) Close () error {
+	this.stop()
+	// reading may have stopped early, in which case the command is
+	// stopped rather than left waiting to write the rest
+	this.ReadCloser.Close()
+	err := this.command.Wait()
+	if err != nil { return fmt.Errorf("%v export: %w", this.command.Args[0], err) }
+	return nil
+}
+
+// Save saves an image to a temporary archive in the format of docker save,
+// which must be closed and removed by the caller. Like the archives written by
+// Export, it is removed as soon as it is created where the system allows it.
+func (this CLI) Save (ctx context.Context, name string) (*os.File, error) {
+	return this.capture(ctx, "microscope_image_*.tar", "save", name)
+}
+
+// capture runs the runtime with some arguments, and writes its output to a
+// temporary file named after a pattern, which is returned open at its start.
+func (this CLI) capture (ctx context.Context, pattern string, args ...string) (*os.File, error) {
+	temporary, err := os.CreateTemp("", pattern)
+	if err != nil { return nil, err }
+	os.Remove(temporary.Name())
+
+	command := this.command(ctx, args...)
+	command.Stdout = temporary
+	err = command.Run()
+	if err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return nil, fmt.Errorf("%v %v: %w", this.Command, args[0], err)
+	}
+	_, err = temporary.Seek(0, io.SeekStart)
+	if err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return nil, err
+	}
+	return temporary, nil
+}
+
+// command returns a command which runs the runtime with some arguments, and
+// prints it.
+func (this CLI) command (ctx context.Context, args ...string) *exec.Cmd {
+	command := exec.CommandContext(ctx, this.Command, args...)
+	command.Stderr = os.Stderr
+	fmt.Fprintf (
+		os.Stderr, "%v: running %v\n",
+		os.Args[0], command)
+	return command
+}
@@ -0,0 +1,254 @@
+package container
+
+import "io"
+import "os"
+import "sort"
+import "strings"
+import "io/fs"
+import "errors"
+import "path/filepath"
+
+// Overlay is a read-only filesystem made of directories stacked on top of each
+// other, the way overlayfs mounts the layers of a container. Files in higher
+// directories hide those in lower ones, and whiteouts hide them without
+// replacing them. Symbolic links are followed inside of the filesystem rather
+// than on the local system.
+type Overlay struct {
+	// The directories, from the top down
+	Layers []string
+}
+
+// overlayEntry is a file found in an overlay.
+type overlayEntry struct {
+	// where the file is on the local system
+	path string
+	info fs.FileInfo
+	// the directories merged into this one from the top down, or nil if
+	// the file is not a directory
+	dirs []string
+}
+
+// resolve returns the entry with the given name, following symbolic links.
+// The last element of the name is only followed if follow is true.
+func (this Overlay) resolve (operation, name string, follow bool) (*overlayEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError { Op: operation, Path: name, Err: fs.ErrInvalid }
+	}
+	if len(this.Layers) == 0 {
+		return nil, &fs.PathError { Op: operation, Path: name, Err: fs.ErrNotExist }
+	}
+	info, err := os.Stat(this.Layers[0])
+	if err != nil { return nil, err }
+	root := &overlayEntry { path: this.Layers[0], info: info, dirs: this.Layers }
+
+	links := 0
+	parts := strings.Split(name, "/")
+	if name == "." { parts = nil }
+	current := root
+	var stack []*overlayEntry
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".": continue
+		case "..":
+			if len(stack) > 0 {
+				current = stack[len(stack) - 1]
+				stack   = stack[:len(stack) - 1]
+			}
+			continue
+		}
+
+		if current.dirs == nil {
+			return nil, &fs.PathError { Op: operation, Path: name, Err: errors.New("not a directory") }
+		}
+		child, err := lookup(current, part)
+		if err != nil { return nil, &fs.PathError { Op: operation, Path: name, Err: err } }
+		if child == nil {
+			return nil, &fs.PathError { Op: operation, Path: name, Err: fs.ErrNotExist }
+		}
+
+		isLink := child.info.Mode() & fs.ModeSymlink != 0
+		if isLink && (len(parts) > 0 || follow) {
+			links ++
+			if links > 40 {
+				return nil, &fs.PathError { Op: operation, Path: name, Err: errors.New("too many links") }
+			}
+			target, err := os.Readlink(child.path)
+			if err != nil { return nil, &fs.PathError { Op: operation, Path: name, Err: err } }
+			target = filepath.ToSlash(target)
+			if strings.HasPrefix(target, "/") {
+				current = root
+				stack   = nil
+			}
+			parts = append(strings.Split(target, "/"), parts...)
+			continue
+		}
+
+		stack   = append(stack, current)
+		current = child
+	}
+	return current, nil
+}
+
+// lookup finds a file in a directory of an overlay, returning nil if it does
+// not exist or has been removed by a whiteout.
+func lookup (directory *overlayEntry, name string) (*overlayEntry, error) {
+	var found *overlayEntry
+	for _, dir := range directory.dirs {
+		_, err := os.Lstat(filepath.Join(dir, whiteoutPrefix + name))
+		if err == nil { break }
+
+		full := filepath.Join(dir, name)
+		info, err := os.Lstat(full)
+		if errors.Is(err, fs.ErrNotExist) { continue }
+		if err != nil { return nil, err }
+		if isWhiteout(info) { break }
+
+		if found == nil {
+			found = &overlayEntry { path: full, info: info }
+			if !info.IsDir() { return found, nil }
+		} else if !info.IsDir() {
+			// files in lower layers are hidden by directories
+			break
+		}
+		found.dirs = append(found.dirs, full)
+		if isOpaque(full) { break }
+	}
+	return found, nil
+}
+
+// whiteoutPrefix marks files which remove a file of the same name from lower
+// layers, as an alternative to the character devices used by overlayfs.
+const whiteoutPrefix = ".wh."
+
+// opaqueWhiteout marks directories which hide the contents of directories of
+// the same name in lower layers.
+const opaqueWhiteout = ".wh..wh..opq"
+
+// isOpaque returns whether a directory hides the contents of directories of
+// the same name in lower layers.
+func isOpaque (directory string) bool {
+	_, err := os.Lstat(filepath.Join(directory, opaqueWhiteout))
+	return err == nil || hasOpaqueAttribute(directory)
+}
+
+// entries lists the merged contents of a directory, sorted by name.
+func (this *overlayEntry) entries () ([]fs.DirEntry, error) {
+	seen := map[string] bool { }
+	var entries []fs.DirEntry
+	for _, dir := range this.dirs {
+		list, err := os.ReadDir(dir)
+		if err != nil { return nil, err }
+		for _, entry := range list {
+			name := entry.Name()
+			if seen[name] { continue }
+			if strings.HasPrefix(name, whiteoutPrefix) {
+				if name != opaqueWhiteout {
+					seen[strings.TrimPrefix(name, whiteoutPrefix)] = true
+				}
+				continue
+			}
+			seen[name] = true
+			if entry.Type() & fs.ModeCharDevice != 0 {
+				info, err := entry.Info()
+				if err == nil && isWhiteout(info) { continue }
+			}
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func (left, right int) bool {
+		return entries[left].Name() < entries[right].Name()
+	})
+	return entries, nil
+}
+
+func (this Overlay) Open (name string) (fs.File, error) {
+	found, err := this.resolve("open", name, true)
+	if err != nil { return nil, err }
+	opened := &overlayFile { entry: found }
+	if found.info.Mode().IsRegular() {
+		opened.file, err = os.Open(found.path)
+		if err != nil { return nil, &fs.PathError { Op: "open", Path: name, Err: err } }
+	}
+	return opened, nil
+}
+
+func (this Overlay) Stat (name string) (fs.FileInfo, error) {
+	found, err := this.resolve("stat", name, true)
+	if err != nil { return nil, err }
+	return found.info, nil
+}
+
+func (this Overlay) ReadDir (name string) ([]fs.DirEntry, error) {
+	found, err := this.resolve("readdir", name, true)
+	if err != nil { return nil, err }
+	if found.dirs == nil {
+		return nil, &fs.PathError { Op: "readdir", Path: name, Err: errors.New("not a directory") }
+	}
+	entries, err := found.entries()
+	if err != nil { return nil, &fs.PathError { Op: "readdir", Path: name, Err: err } }
+	return entries, nil
+}
+
+// overlayFile is an open file or directory of an overlay. Only regular files
+// are opened on the local system, so that reading devices and pipes does not
+// block.
+type overlayFile struct {
+	entry   *overlayEntry
+	file    *os.File
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (this *overlayFile) Stat () (fs.FileInfo, error) {
+	return this.entry.info, nil
+}
+
+func (this *overlayFile) Read (buffer []byte) (int, error) {
+	if this.file == nil {
+		return 0, &fs.PathError { Op: "read", Path: this.entry.info.Name(), Err: errors.New("not a regular file") }
+	}
+	return this.file.Read(buffer)
+}
+
+func (this *overlayFile) ReadAt (buffer []byte, offset int64) (int, error) {
+	if this.file == nil {
+		return 0, &fs.PathError { Op: "read", Path: this.entry.info.Name(), Err: errors.New("not a regular file") }
+	}
+	return this.file.ReadAt(buffer, offset)
+}
+
+func (this *overlayFile) Seek (offset int64, whence int) (int64, error) {
+	if this.file == nil {
+		return 0, &fs.PathError { Op: "seek", Path: this.entry.info.Name(), Err: errors.New("not a regular file") }
+	}
+	return this.file.Seek(offset, whence)
+}
+
+func (this *overlayFile) ReadDir (count int) ([]fs.DirEntry, error) {
+	if this.entry.dirs == nil {
+		return nil, &fs.PathError { Op: "readdir", Path: this.entry.info.Name(), Err: errors.New("not a directory") }
+	}
+	if !this.listed {
+		entries, err := this.entry.entries()
+		if err != nil { return nil, err }
+		this.entries = entries
+		this.listed  = true
+	}
+	if count <= 0 {
+		entries := this.entries
+		this.entries = nil
+		return entries, nil
+	}
+	if len(this.entries) == 0 { return nil, io.EOF }
+	count = min(count, len(this.entries))
+	entries := this.entries[:count]
+	this.entries = this.entries[count:]
+	return entries, nil
+}
+
+func (this *overlayFile) Close () error {
+	if this.file == nil { return nil }
+	return this.file.Close()
+}
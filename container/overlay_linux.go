@@ -0,0 +1,28 @@
+package container
+
+import "io/fs"
+import "syscall"
+
+// isWhiteout returns whether a file is a character device numbered 0/0, which
+// overlayfs uses to remove a file of the same name from lower layers.
+func isWhiteout (info fs.FileInfo) bool {
+	if info.Mode() & fs.ModeCharDevice == 0 { return false }
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok { return false }
+	return stat.Rdev == 0
+}
+
+// hasOpaqueAttribute returns whether a directory has been marked as opaque by
+// overlayfs, which uses the trusted namespace of extended attributes, or the
+// user namespace when it is mounted without root.
+func hasOpaqueAttribute (directory string) bool {
+	value := make([]byte, 1)
+	for _, attribute := range []string {
+		"trusted.overlay.opaque",
+		"user.overlay.opaque",
+	} {
+		size, err := syscall.Getxattr(directory, attribute, value)
+		if err == nil && size == 1 && value[0] == 'y' { return true }
+	}
+	return false
+}
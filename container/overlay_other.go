@@ -0,0 +1,18 @@
+//go:build !linux
+
+package container
+
+import "io/fs"
+
+// isWhiteout returns whether a file is a character device, which overlayfs
+// uses to remove a file of the same name from lower layers. The device number
+// cannot be checked on this system.
+func isWhiteout (info fs.FileInfo) bool {
+	return info.Mode() & fs.ModeCharDevice != 0
+}
+
+// hasOpaqueAttribute returns false, as the extended attributes overlayfs uses
+// to mark directories as opaque cannot be read on this system.
+func hasOpaqueAttribute (directory string) bool {
+	return false
+}
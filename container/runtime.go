@@ -0,0 +1,117 @@
+// Package container gives access to the root filesystems of containers, either
+// through a container runtime command such as docker, podman or nerdctl, or by
+// reading the directories the runtime stores them in.
+package container
+
+import "io"
+import "os"
+import "fmt"
+import "io/fs"
+import "sync"
+import "errors"
+import "context"
+import "os/exec"
+
+// Runtime gives access to the root filesystems of containers.
+type Runtime interface {
+	// Open returns the root filesystem of a container, along with a
+	// function which releases it.
+	Open (ctx context.Context, name string) (fs.FS, func (), error)
+}
+
+// ImageSaver is a runtime which can also save images into archives in the
+// format of docker save.
+type ImageSaver interface {
+	Runtime
+	// Save saves an image to a temporary file, which must be closed and
+	// removed by the caller.
+	Save (ctx context.Context, name string) (*os.File, error)
+}
+
+// Streamer is a runtime which can also stream the root filesystem of a
+// container as a tar archive, without storing it anywhere.
+type Streamer interface {
+	Runtime
+	// Stream returns a reader of the archive. Closing it waits for the
+	// export to finish, and returns an error if it failed.
+	Stream (ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// Commands lists the runtime commands that are supported, in the order they
+// are looked for when detecting which one is installed.
+var Commands = []string { "docker", "podman", "nerdctl" }
+
+// IsCommand returns whether a runtime name is one of the supported commands,
+// rather than a directory.
+func IsCommand (name string) bool {
+	for _, command := range Commands {
+		if name == command { return true }
+	}
+	return false
+}
+
+// Select returns the runtime with a name, which is either one of the supported
+// commands or a directory holding containers. An empty name or auto detects
+// which command is installed when a container is first opened.
+func Select (name string) (Runtime, error) {
+	switch {
+	case name == "" || name == "auto":
+		return &Auto { }, nil
+	case IsCommand(name):
+		return CLI { Command: name }, nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errors.New(fmt.Sprint("unknown runtime ", name))
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(fmt.Sprint("runtime ", name, " is not a directory"))
+	}
+	return OpenStorage(name)
+}
+
+// Auto is a runtime which uses the first supported command that is installed.
+type Auto struct {
+	once     sync.Once
+	detected CLI
+	err      error
+}
+
+// Detect returns the first supported command that is installed.
+func Detect () (CLI, error) {
+	for _, command := range Commands {
+		_, err := exec.LookPath(command)
+		if err == nil { return CLI { Command: command }, nil }
+	}
+	return CLI { }, errors.New (
+		"no container runtime found, install docker, podman or nerdctl, " +
+		"or choose one with -runtime")
+}
+
+func (this *Auto) cli () (CLI, error) {
+	this.once.Do(func () {
+		this.detected, this.err = Detect()
+	})
+	return this.detected, this.err
+}
+
+func (this *Auto) Open (ctx context.Context, name string) (fs.FS, func (), error) {
+	cli, err := this.cli()
+	if err != nil { return nil, nil, err }
+	return cli.Open(ctx, name)
+}
+
+func (this *Auto) Save (ctx context.Context, name string) (*os.File, error) {
+	cli, err := this.cli()
+	if err != nil { return nil, err }
+	return cli.Save(ctx, name)
+}
+
+func (this *Auto) Stream (ctx context.Context, name string) (io.ReadCloser, error) {
+	cli, err := this.cli()
+	if err != nil { return nil, err }
+	return cli.Stream(ctx, name)
+}
@@ -0,0 +1,191 @@
+package container
+
+import "os"
+import "fmt"
+import "io/fs"
+import "errors"
+import "context"
+import "strings"
+import "path/filepath"
+import "encoding/json"
+
+// OpenStorage returns a runtime which reads containers straight from the
+// directory a runtime keeps them in, without running any command. The
+// directory is either one of containers/storage, which is used by podman,
+// buildah and CRI-O, such as /var/lib/containers/storage, or the data
+// directory of docker, such as /var/lib/docker.
+func OpenStorage (root string) (Runtime, error) {
+	for _, driver := range []string { "overlay", "vfs" } {
+		_, err := os.Stat(filepath.Join(root, driver + "-containers"))
+		if err == nil { return Storage { Root: root, Driver: driver }, nil }
+	}
+	_, err := os.Stat(filepath.Join(root, "containers"))
+	if err == nil {
+		_, err = os.Stat(filepath.Join(root, "image"))
+		if err == nil { return DockerStorage { Root: root }, nil }
+	}
+	return nil, errors.New(fmt.Sprint (
+		root, " is not a containers/storage or docker directory"))
+}
+
+// Storage is a runtime which reads containers from a directory of
+// containers/storage using the overlay or vfs driver.
+type Storage struct {
+	Root   string
+	// The storage driver, which is overlay or vfs
+	Driver string
+}
+
+// storageContainer is a container listed in containers.json.
+type storageContainer struct {
+	ID    string   `json:"id"`
+	Names []string `json:"names"`
+	// The layer holding the changes made by the container
+	Layer string   `json:"layer"`
+}
+
+// storageLayer is a layer listed in layers.json.
+type storageLayer struct {
+	ID     string `json:"id"`
+	Parent string `json:"parent"`
+}
+
+func (this Storage) Open (ctx context.Context, name string) (fs.FS, func (), error) {
+	var containers []storageContainer
+	err := readStorageJSON (
+		filepath.Join(this.Root, this.Driver + "-containers", "containers.json"),
+		&containers)
+	if err != nil { return nil, nil, err }
+
+	ids := make([]string, len(containers))
+	for index, container := range containers {
+		ids[index] = container.ID
+		for _, other := range container.Names {
+			if other == name { ids[index] = name }
+		}
+	}
+	index, err := findContainer(ids, name)
+	if err != nil { return nil, nil, err }
+	top := containers[index].Layer
+
+	if this.Driver == "vfs" {
+		// every layer of the vfs driver is a complete copy of the
+		// filesystem
+		return Overlay { Layers: []string {
+			filepath.Join(this.Root, "vfs", "dir", top),
+		} }, func () { }, nil
+	}
+
+	// container layers are kept apart from image layers, in a file which
+	// is not synced to disk
+	parents := map[string] string { }
+	for _, list := range []string { "layers.json", "volatile-layers.json" } {
+		var layers []storageLayer
+		err := readStorageJSON (
+			filepath.Join(this.Root, this.Driver + "-layers", list),
+			&layers)
+		if errors.Is(err, fs.ErrNotExist) { continue }
+		if err != nil { return nil, nil, err }
+		for _, layer := range layers {
+			parents[layer.ID] = layer.Parent
+		}
+	}
+
+	var layers []string
+	for layer := top; layer != ""; layer = parents[layer] {
+		if len(layers) > len(parents) {
+			return nil, nil, errors.New(fmt.Sprint("layer ", top, " has a cycle of parents"))
+		}
+		layers = append(layers, filepath.Join(this.Root, this.Driver, layer, "diff"))
+	}
+	return Overlay { Layers: layers }, func () { }, nil
+}
+
+// DockerStorage is a runtime which reads containers from the data directory
+// of docker using the overlay2 driver.
+type DockerStorage struct {
+	Root string
+}
+
+// dockerContainer is the part of the config.v2.json file of a docker container
+// which is needed to find its layers.
+type dockerContainer struct {
+	ID     string
+	Name   string
+	Driver string
+}
+
+func (this DockerStorage) Open (ctx context.Context, name string) (fs.FS, func (), error) {
+	directories, err := os.ReadDir(filepath.Join(this.Root, "containers"))
+	if err != nil { return nil, nil, err }
+
+	var containers []dockerContainer
+	ids := []string { }
+	for _, directory := range directories {
+		var container dockerContainer
+		err := readStorageJSON (
+			filepath.Join(this.Root, "containers", directory.Name(), "config.v2.json"),
+			&container)
+		if errors.Is(err, fs.ErrNotExist) { continue }
+		if err != nil { return nil, nil, err }
+		containers = append(containers, container)
+		if strings.TrimPrefix(container.Name, "/") == name {
+			ids = append(ids, name)
+		} else {
+			ids = append(ids, container.ID)
+		}
+	}
+	index, err := findContainer(ids, name)
+	if err != nil { return nil, nil, err }
+	container := containers[index]
+	if container.Driver != "overlay2" {
+		return nil, nil, errors.New(fmt.Sprintf (
+			"container %v uses the %v storage driver, which is not supported",
+			name, container.Driver))
+	}
+
+	mount, err := os.ReadFile(filepath.Join (
+		this.Root, "image", "overlay2", "layerdb", "mounts", container.ID, "mount-id"))
+	if err != nil { return nil, nil, err }
+	directory := filepath.Join(this.Root, "overlay2", strings.TrimSpace(string(mount)))
+	layers := []string { filepath.Join(directory, "diff") }
+
+	// lower layers are listed as short links in the l directory, such as
+	// l/ABC:l/DEF, which are relative to the overlay2 directory
+	lower, err := os.ReadFile(filepath.Join(directory, "lower"))
+	if errors.Is(err, fs.ErrNotExist) { return Overlay { Layers: layers }, func () { }, nil }
+	if err != nil { return nil, nil, err }
+	for _, link := range strings.Split(strings.TrimSpace(string(lower)), ":") {
+		link = filepath.Join(this.Root, "overlay2", link)
+		target, err := os.Readlink(link)
+		if err != nil { return nil, nil, err }
+		layers = append(layers, filepath.Join(filepath.Dir(link), target))
+	}
+	return Overlay { Layers: layers }, func () { }, nil
+}
+
+// findContainer returns the index of the container with a name or ID, or of
+// the only container whose ID starts with the name.
+func findContainer (ids []string, name string) (int, error) {
+	found := -1
+	for index, id := range ids {
+		if id == name { return index, nil }
+		if name != "" && strings.HasPrefix(id, name) {
+			if found >= 0 {
+				return 0, errors.New(fmt.Sprint("more than one container matches ", name))
+			}
+			found = index
+		}
+	}
+	if found < 0 { return 0, errors.New(fmt.Sprint("no such container: ", name)) }
+	return found, nil
+}
+
+func readStorageJSON (name string, value any) error {
+	file, err := os.Open(name)
+	if err != nil { return err }
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(value)
+	if err != nil { return fmt.Errorf("%v: %w", name, err) }
+	return nil
+}
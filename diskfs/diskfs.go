@@ -0,0 +1,420 @@
+// Package diskfs reads filesystem images as read-only filesystems, without
+// mounting them. ext2, ext3 and ext4, squashfs and ISO 9660 images are
+// supported, as are the MBR and GPT partition tables of images of whole disks.
+package diskfs
+
+import "io"
+import "fmt"
+import "path"
+import "sort"
+import "sync"
+import "time"
+import "bytes"
+import "io/fs"
+import "errors"
+import "strings"
+import "encoding/binary"
+
+// Open opens the filesystem held by an image of a number of bytes. Its type is
+// detected from its contents.
+func Open (image io.ReaderAt, size int64) (fs.FS, error) {
+	var tree tree
+	var err  error
+	image = truncatedReader { image }
+	switch Detect(image) {
+	case "ext4":     tree, err = openExt(image, size)
+	case "squashfs": tree, err = openSquashfs(image, size)
+	case "iso9660":  tree, err = openISO9660(image, size)
+	default:         return nil, errors.New("unknown filesystem")
+	}
+	if err != nil { return nil, err }
+	return newFileSystem(tree)
+}
+
+// DetectSize is how much of the start of an image Detect reads.
+const DetectSize = 16 * 2048 + 6
+
+// Detect returns the type of filesystem held by an image, which is one of
+// ext4 (for ext2, ext3 and ext4), squashfs and iso9660, or an empty string if
+// it is none of them.
+func Detect (image io.ReaderAt) string {
+	read := func (offset int64, length int) []byte {
+		data := make([]byte, length)
+		count, _ := image.ReadAt(data, offset)
+		return data[:count]
+	}
+	if bytes.Equal(read(0, 4), []byte("hsqs")) { return "squashfs" }
+	if string(read(16 * 2048 + 1, 5)) == "CD001" { return "iso9660" }
+
+	// the magic number of ext filesystems is short, so other fields of
+	// their superblock are checked as well
+	super := read(1024, 80)
+	if len(super) == 80 && bytes.Equal(super[56:58], []byte { 0x53, 0xef }) {
+		le := binary.LittleEndian
+		if le.Uint32(super[24:]) <= 6 && le.Uint32(super[40:]) != 0 && le.Uint32(super[76:]) <= 1 {
+			return "ext4"
+		}
+	}
+	return ""
+}
+
+// truncatedReader reads an image, failing with io.ErrUnexpectedEOF rather
+// than io.EOF when part of what is read is past its end, as all of it should
+// be inside of the image.
+type truncatedReader struct {
+	io.ReaderAt
+}
+
+func (this truncatedReader) ReadAt (buffer []byte, offset int64) (int, error) {
+	count, err := this.ReaderAt.ReadAt(buffer, offset)
+	if err == io.EOF {
+		if count == len(buffer) { return count, nil }
+		err = io.ErrUnexpectedEOF
+	}
+	return count, err
+}
+
+// tree is a filesystem image, whose files are described by nodes.
+type tree interface {
+	// root returns the root directory.
+	root () (*node, error)
+	// list returns the files in a directory, other than . and ..
+	list (directory *node) ([]*node, error)
+	// open returns the content of a regular file.
+	open (file *node) (io.ReaderAt, error)
+	// readLink returns the target of a symbolic link.
+	readLink (link *node) (string, error)
+}
+
+// node is a file in a filesystem image.
+type node struct {
+	name    string
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	// identifies the file inside of the image, such as an inode number,
+	// and must be comparable
+	key     any
+	// whatever else the image needs to read the file
+	data    any
+}
+
+func (this *node) Name    () string      { return this.name }
+func (this *node) Size    () int64       { return this.size }
+func (this *node) Mode    () fs.FileMode { return this.mode }
+func (this *node) ModTime () time.Time   { return this.modTime }
+func (this *node) IsDir   () bool        { return this.mode.IsDir() }
+func (this *node) Sys     () any         { return nil }
+
+// maxLinks is how many symbolic links may be followed to resolve a path.
+const maxLinks = 40
+
+// maxCached is how many directories are kept in memory once listed.
+const maxCached = 4096
+
+// maxDirectorySize is how large a directory may be, as larger ones are taken
+// to be corrupt rather than read into memory.
+const maxDirectorySize = 1 << 28
+
+// fileSystem reads a tree as a filesystem. Symbolic links are resolved inside
+// of the image, with absolute links referring to its root. It is safe to use
+// from several goroutines at once.
+type fileSystem struct {
+	tree tree
+	top  *node
+
+	lock        sync.Mutex
+	directories map[any] *directory
+	// where each directory was first found, by its key
+	places      map[any] place
+}
+
+// place is where a directory was found, as the key of its parent and its name.
+type place struct {
+	parent any
+	name   string
+}
+
+// directory is a directory which has been listed.
+type directory struct {
+	entries []*node
+	byName  map[string] *node
+}
+
+func newFileSystem (tree tree) (*fileSystem, error) {
+	top, err := tree.root()
+	if err != nil { return nil, err }
+	if !top.IsDir() { return nil, errors.New("root is not a directory") }
+	top.name = "."
+	return &fileSystem {
+		tree:        tree,
+		top:         top,
+		directories: map[any] *directory { },
+		places:      map[any] place { },
+	}, nil
+}
+
+func (this *fileSystem) Open (name string) (fs.File, error) {
+	found, err := this.resolve("open", name)
+	if err != nil { return nil, err }
+
+	switch {
+	case found.IsDir():
+		return &dirFile { fileSystem: this, node: found, path: name }, nil
+	case found.Mode().IsRegular():
+		content, err := this.tree.open(found)
+		if err != nil { return nil, &fs.PathError { Op: "open", Path: name, Err: err } }
+		return &file {
+			SectionReader: io.NewSectionReader(content, 0, found.size),
+			node:          found,
+		}, nil
+	}
+	// devices, pipes and sockets have no content in an image
+	return &file {
+		SectionReader: io.NewSectionReader(bytes.NewReader(nil), 0, 0),
+		node:          found,
+	}, nil
+}
+
+func (this *fileSystem) ReadDir (name string) ([]fs.DirEntry, error) {
+	found, err := this.resolve("readdir", name)
+	if err != nil { return nil, err }
+	if !found.IsDir() {
+		return nil, &fs.PathError { Op: "readdir", Path: name, Err: errors.New("not a directory") }
+	}
+	listed, err := this.list(found)
+	if err != nil { return nil, &fs.PathError { Op: "readdir", Path: name, Err: err } }
+	entries := make([]fs.DirEntry, len(listed.entries))
+	for index, entry := range listed.entries {
+		entries[index] = fs.FileInfoToDirEntry(entry)
+	}
+	return entries, nil
+}
+
+func (this *fileSystem) Stat (name string) (fs.FileInfo, error) {
+	found, err := this.resolve("stat", name)
+	if err != nil { return nil, err }
+	if name == "." { return found, nil }
+	// files reached through symbolic links keep the name they were
+	// looked up by
+	renamed := *found
+	renamed.name = path.Base(name)
+	return &renamed, nil
+}
+
+// list lists a directory, sorted by name, or returns it from the cache.
+func (this *fileSystem) list (parent *node) (*directory, error) {
+	this.lock.Lock()
+	listed, cached := this.directories[parent.key]
+	this.lock.Unlock()
+	if cached { return listed, nil }
+
+	entries, err := this.tree.list(parent)
+	if err != nil { return nil, err }
+	sort.Slice(entries, func (first, second int) bool {
+		return entries[first].name < entries[second].name
+	})
+
+	this.lock.Lock()
+	listed = &directory { byName: map[string] *node { } }
+	for _, entry := range entries {
+		if _, duplicate := listed.byName[entry.name]; duplicate { continue }
+		if entry.IsDir() {
+			// directories cannot be in two places at once, and a
+			// corrupt image which puts one inside of itself must not
+			// be walked forever
+			here := place { parent: parent.key, name: entry.name }
+			found, seen := this.places[entry.key]
+			if entry.key == this.top.key || seen && found != here { continue }
+			this.places[entry.key] = here
+		}
+		listed.entries = append(listed.entries, entry)
+		listed.byName[entry.name] = entry
+	}
+	if len(this.directories) >= maxCached {
+		this.directories = map[any] *directory { }
+	}
+	this.directories[parent.key] = listed
+	this.lock.Unlock()
+	return listed, nil
+}
+
+// resolve finds the file at a path, following symbolic links.
+func (this *fileSystem) resolve (op, name string) (*node, error) {
+	fail := func (err error) (*node, error) {
+		return nil, &fs.PathError { Op: op, Path: name, Err: err }
+	}
+	if !fs.ValidPath(name) { return fail(fs.ErrInvalid) }
+
+	// the directories leading to the current file, from the root
+	parents    := []*node { }
+	current    := this.top
+	components := strings.Split(name, "/")
+	if name == "." { components = nil }
+	links := 0
+	for len(components) > 0 {
+		component := components[0]
+		components = components[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if len(parents) > 0 {
+				current = parents[len(parents) - 1]
+				parents = parents[:len(parents) - 1]
+			}
+			continue
+		}
+
+		if !current.IsDir() { return fail(fs.ErrNotExist) }
+		listed, err := this.list(current)
+		if err != nil { return fail(err) }
+		child, found := listed.byName[component]
+		if !found { return fail(fs.ErrNotExist) }
+		if child.mode & fs.ModeSymlink == 0 {
+			parents = append(parents, current)
+			current = child
+			continue
+		}
+
+		links ++
+		if links > maxLinks { return fail(errors.New("too many levels of symbolic links")) }
+		target, err := this.tree.readLink(child)
+		if err != nil { return fail(err) }
+		if strings.HasPrefix(target, "/") {
+			current = this.top
+			parents = parents[:0]
+		}
+		components = append(strings.Split(target, "/"), components...)
+	}
+	return current, nil
+}
+
+type file struct {
+	*io.SectionReader
+	node *node
+}
+
+func (this *file) Stat () (fs.FileInfo, error) {
+	return this.node, nil
+}
+
+func (this *file) Close () error {
+	return nil
+}
+
+type dirFile struct {
+	*fileSystem
+	node    *node
+	path    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (this *dirFile) Stat () (fs.FileInfo, error) {
+	return this.node, nil
+}
+
+func (this *dirFile) Read ([]byte) (int, error) {
+	return 0, &fs.PathError { Op: "read", Path: this.path, Err: errors.New("is a directory") }
+}
+
+func (this *dirFile) ReadDir (count int) ([]fs.DirEntry, error) {
+	if !this.read {
+		entries, err := this.fileSystem.ReadDir(this.path)
+		if err != nil { return nil, err }
+		this.entries, this.read = entries, true
+	}
+	if count <= 0 {
+		entries := this.entries
+		this.entries = nil
+		return entries, nil
+	}
+	if len(this.entries) == 0 { return nil, io.EOF }
+	count = min(count, len(this.entries))
+	entries := this.entries[:count]
+	this.entries = this.entries[count:]
+	return entries, nil
+}
+
+func (this *dirFile) Close () error {
+	return nil
+}
+
+// extent is a run of bytes of a file, stored contiguously in an image.
+type extent struct {
+	// where the run starts in the file and in the image
+	logical  int64
+	physical int64
+	length   int64
+}
+
+// extentReader reads a file made of extents. Bytes not covered by any extent
+// read as zeros.
+type extentReader struct {
+	image   io.ReaderAt
+	extents []extent
+	size    int64
+}
+
+func (this *extentReader) ReadAt (buffer []byte, offset int64) (int, error) {
+	if offset >= this.size { return 0, io.EOF }
+	wanted := buffer
+	if int64(len(wanted)) > this.size - offset { wanted = wanted[:this.size - offset] }
+
+	// the first extent which ends after the offset
+	index := sort.Search(len(this.extents), func (index int) bool {
+		extent := this.extents[index]
+		return extent.logical + extent.length > offset
+	})
+	done := 0
+	for done < len(wanted) {
+		position := offset + int64(done)
+		part := wanted[done:]
+		if index >= len(this.extents) || this.extents[index].logical > position {
+			// a hole, up to the next extent
+			if index < len(this.extents) {
+				part = part[:min(int64(len(part)), this.extents[index].logical - position)]
+			}
+			clear(part)
+			done += len(part)
+			continue
+		}
+		extent := this.extents[index]
+		part = part[:min(int64(len(part)), extent.logical + extent.length - position)]
+		count, err := this.image.ReadAt(part, extent.physical + position - extent.logical)
+		done += count
+		if err != nil && !(err == io.EOF && count == len(part)) {
+			if err == io.EOF { err = io.ErrUnexpectedEOF }
+			return done, err
+		}
+		index ++
+	}
+	if len(wanted) < len(buffer) { return done, io.EOF }
+	return done, nil
+}
+
+// unixMode converts the mode of a file on a Unix system into a file mode.
+func unixMode (mode uint32) fs.FileMode {
+	converted := fs.FileMode(mode & 0777)
+	if mode & 04000 != 0 { converted |= fs.ModeSetuid }
+	if mode & 02000 != 0 { converted |= fs.ModeSetgid }
+	if mode & 01000 != 0 { converted |= fs.ModeSticky }
+	switch mode & 0170000 {
+	case 0010000: converted |= fs.ModeNamedPipe
+	case 0020000: converted |= fs.ModeDevice | fs.ModeCharDevice
+	case 0040000: converted |= fs.ModeDir
+	case 0060000: converted |= fs.ModeDevice
+	case 0120000: converted |= fs.ModeSymlink
+	case 0140000: converted |= fs.ModeSocket
+	case 0100000:
+	default:      converted |= fs.ModeIrregular
+	}
+	return converted
+}
+
+// errCorrupt returns an error for an image which cannot be read because of
+// its contents.
+func errCorrupt (kind string, details ...any) error {
+	return errors.New(fmt.Sprint(append([]any { "corrupt ", kind, " image: " }, details...)...))
+}
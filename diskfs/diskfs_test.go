@@ -0,0 +1,206 @@
+package diskfs
+
+import "bytes"
+import "errors"
+import "io/fs"
+import "strings"
+import "testing"
+import "encoding/binary"
+
+// testExt returns an ext2 filesystem of blocks of 1024 bytes, holding a file
+// named hello. Its group descriptors are in the third block, its inodes in
+// the sixth, the root directory in the eleventh and hello in the twelfth.
+func testExt () []byte {
+	image := make([]byte, 12 * 1024)
+	le := binary.LittleEndian
+	super := image[1024:]
+	le.PutUint32(super[0:], 16)
+	le.PutUint32(super[20:], 1)
+	le.PutUint32(super[40:], 16)
+	le.PutUint16(super[56:], 0xef53)
+	le.PutUint32(super[96:], extIncompatFiletype)
+	le.PutUint32(image[2048 + 8:], 5)
+
+	inode := func (number int, mode uint16, size uint32, block uint32) {
+		raw := image[5 * 1024 + (number - 1) * 128:]
+		le.PutUint16(raw[0:], mode)
+		le.PutUint32(raw[4:], size)
+		le.PutUint32(raw[0x28:], block)
+	}
+	inode(2, 0040755, 1024, 10)
+	inode(12, 0100644, 5, 11)
+
+	entry := func (offset int, number uint32, length uint16, name string) {
+		raw := image[10 * 1024 + offset:]
+		le.PutUint32(raw[0:], number)
+		le.PutUint16(raw[4:], length)
+		raw[6] = byte(len(name))
+		copy(raw[8:], name)
+	}
+	entry(0, 2, 12, ".")
+	entry(12, 2, 12, "..")
+	entry(24, 12, 1000, "hello")
+	copy(image[11 * 1024:], "world")
+	return image
+}
+
+// testISO returns an ISO 9660 filesystem holding a file named hello.txt, whose
+// root directory is in the nineteenth sector and hello.txt in the twentieth.
+func testISO () []byte {
+	image := make([]byte, 20 * 2048)
+	record := func (data []byte, extent, size uint32, flags byte, name string) int {
+		length := 33 + len(name)
+		length += length % 2
+		data[0] = byte(length)
+		binary.LittleEndian.PutUint32(data[2:], extent)
+		binary.BigEndian.PutUint32(data[6:], extent)
+		binary.LittleEndian.PutUint32(data[10:], size)
+		binary.BigEndian.PutUint32(data[14:], size)
+		data[18], data[19], data[20] = 124, 1, 1
+		data[25] = flags
+		data[32] = byte(len(name))
+		copy(data[33:], name)
+		return length
+	}
+	primary := image[16 * 2048:]
+	primary[0] = 1
+	copy(primary[1:], "CD001")
+	binary.LittleEndian.PutUint16(primary[128:], 2048)
+	record(primary[156:], 18, 2048, isoFlagDirectory, "\x00")
+	terminator := image[17 * 2048:]
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+
+	directory := image[18 * 2048:]
+	offset := record(directory, 18, 2048, isoFlagDirectory, "\x00")
+	offset += record(directory[offset:], 18, 2048, isoFlagDirectory, "\x01")
+	record(directory[offset:], 19, 5, 0, "HELLO.TXT;1")
+	copy(image[19 * 2048:], "world")
+	return image
+}
+
+func TestOpen (test *testing.T) {
+	le := binary.LittleEndian
+	cases := []struct {
+		name     string
+		image    []byte
+		// changes the image before it is opened
+		corrupt  func ([]byte)
+		// part of the error expected, if any
+		err      string
+	} {
+		{
+			name:  "ext",
+			image: testExt(),
+		}, {
+			name:    "ext with too many groups",
+			image:   testExt(),
+			corrupt: func (image []byte) {
+				le.PutUint32(image[1024 + 0:], 1 << 24 + 1)
+				le.PutUint32(image[1024 + 40:], 1)
+			},
+			err:     "too many groups",
+		}, {
+			name:    "ext with descriptors past the end",
+			image:   testExt(),
+			corrupt: func (image []byte) {
+				le.PutUint32(image[1024 + 0:], 1 << 24)
+				le.PutUint32(image[1024 + 40:], 1)
+			},
+			err:     "group descriptors past the end of the image",
+		}, {
+			name:    "ext with large descriptors past the end",
+			image:   testExt(),
+			corrupt: func (image []byte) {
+				le.PutUint32(image[1024 + 96:], extIncompatFiletype | extIncompat64Bit)
+				le.PutUint16(image[1024 + 254:], 1024)
+				le.PutUint32(image[1024 + 40:], 1)
+			},
+			err:     "group descriptors past the end of the image",
+		}, {
+			name:    "ext with descriptors too large",
+			image:   testExt(),
+			corrupt: func (image []byte) {
+				le.PutUint32(image[1024 + 96:], extIncompatFiletype | extIncompat64Bit)
+				le.PutUint16(image[1024 + 254:], 0xffff)
+			},
+			err:     "invalid group descriptor size",
+		}, {
+			name:    "ext with a directory larger than the image",
+			image:   testExt(),
+			corrupt: func (image []byte) { le.PutUint32(image[5 * 1024 + 128 + 4:], 1 << 20) },
+			err:     "directory too large",
+		}, {
+			name:    "ext with an invalid inode",
+			image:   testExt(),
+			corrupt: func (image []byte) { le.PutUint32(image[10 * 1024 + 24:], 17) },
+			err:     "invalid inode 17",
+		}, {
+			name:  "ISO 9660",
+			image: testISO(),
+		}, {
+			name:    "ISO 9660 with a directory past the end",
+			image:   testISO(),
+			corrupt: func (image []byte) { le.PutUint32(image[16 * 2048 + 156 + 2:], 20) },
+			err:     "directory too large",
+		}, {
+			name:    "ISO 9660 with a directory larger than the image",
+			image:   testISO(),
+			corrupt: func (image []byte) { le.PutUint32(image[16 * 2048 + 156 + 10:], 1 << 20) },
+			err:     "directory too large",
+		}, {
+			name:    "ISO 9660 with an invalid block size",
+			image:   testISO(),
+			corrupt: func (image []byte) { le.PutUint16(image[16 * 2048 + 128:], 0) },
+			err:     "invalid block size",
+		},
+	}
+	for _, current := range cases {
+		if current.corrupt != nil { current.corrupt(current.image) }
+		filesystem, err := Open(bytes.NewReader(current.image), int64(len(current.image)))
+		var content []byte
+		if err == nil { content, err = readHello(filesystem) }
+		switch {
+		case current.err != "":
+			if err == nil || !strings.Contains(err.Error(), current.err) {
+				test.Errorf("%v: expected %q, got %v", current.name, current.err, err)
+			}
+		case err != nil:
+			test.Errorf("%v: %v", current.name, err)
+		case string(content) != "world":
+			test.Errorf("%v: read %q", current.name, content)
+		}
+	}
+}
+
+// readHello reads the file named hello in either of the images.
+func readHello (filesystem fs.FS) ([]byte, error) {
+	content, err := fs.ReadFile(filesystem, "hello")
+	if err == nil || !errors.Is(err, fs.ErrNotExist) { return content, err }
+	return fs.ReadFile(filesystem, "hello.txt")
+}
+
+func TestOpenTruncated (test *testing.T) {
+	for name, image := range map[string] []byte { "ext": testExt(), "ISO 9660": testISO() } {
+		// images cut short must either fail, or still hold all of hello
+		for size := len(image) - 1; size >= DetectSize; size -= 256 {
+			filesystem, err := Open(bytes.NewReader(image[:size]), int64(size))
+			var content []byte
+			if err == nil { content, err = readHello(filesystem) }
+			if err == nil && string(content) != "world" {
+				test.Errorf("%v cut to %v bytes: read %q", name, size, content)
+			}
+		}
+	}
+}
+
+func TestDetect (test *testing.T) {
+	for expected, image := range map[string] []byte {
+		"ext4":    testExt(),
+		"iso9660": testISO(),
+		"":        make([]byte, 4096),
+	} {
+		found := Detect(bytes.NewReader(image))
+		if found != expected { test.Errorf("expected %q, detected %q", expected, found) }
+	}
+}
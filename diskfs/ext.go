@@ -0,0 +1,335 @@
+package diskfs
+
+import "io"
+import "time"
+import "bytes"
+import "errors"
+import "encoding/binary"
+
+// features of ext filesystems which change how they are read
+const (
+	extIncompatCompression = 0x1
+	extIncompatFiletype    = 0x2
+	extIncompatMetaBG      = 0x10
+	extIncompat64Bit       = 0x80
+	extIncompatEncrypt     = 0x10000
+)
+
+// flags of ext inodes
+const (
+	extFlagExtents = 0x80000
+	extFlagInline  = 0x10000000
+)
+
+// extTree is an ext2, ext3 or ext4 filesystem.
+type extTree struct {
+	image           io.ReaderAt
+	size            int64
+	blockSize       int64
+	inodeSize       int64
+	inodesPerGroup  uint32
+	inodeCount      uint32
+	// where the table of inodes of each group starts, in blocks
+	inodeTables     []uint64
+	filetype        bool
+}
+
+// extInode is what is needed of an inode to read its file.
+type extInode struct {
+	number uint32
+	raw    []byte
+	flags  uint32
+}
+
+func openExt (image io.ReaderAt, size int64) (*extTree, error) {
+	super := make([]byte, 1024)
+	_, err := image.ReadAt(super, 1024)
+	if err != nil { return nil, err }
+	le := binary.LittleEndian
+
+	incompat := le.Uint32(super[96:])
+	if incompat & (extIncompatCompression | extIncompatMetaBG | extIncompatEncrypt) != 0 {
+		return nil, errors.New("unsupported ext4 features")
+	}
+	logSize := le.Uint32(super[24:])
+	if logSize > 6 { return nil, errCorrupt("ext4", "invalid block size") }
+	this := &extTree {
+		image:          image,
+		size:           size,
+		blockSize:      1024 << logSize,
+		inodeSize:      128,
+		inodesPerGroup: le.Uint32(super[40:]),
+		inodeCount:     le.Uint32(super[0:]),
+		filetype:       incompat & extIncompatFiletype != 0,
+	}
+	if le.Uint32(super[76:]) >= 1 { this.inodeSize = int64(le.Uint16(super[88:])) }
+	if this.inodeSize < 128 || this.inodesPerGroup == 0 {
+		return nil, errCorrupt("ext4", "invalid superblock")
+	}
+
+	descriptorSize := int64(32)
+	if incompat & extIncompat64Bit != 0 {
+		descriptorSize = int64(le.Uint16(super[254:]))
+		if descriptorSize < 32 || descriptorSize > 1024 {
+			return nil, errCorrupt("ext4", "invalid group descriptor size")
+		}
+	}
+	groups := (int64(this.inodeCount) + int64(this.inodesPerGroup) - 1) / int64(this.inodesPerGroup)
+	if groups > 1 << 24 { return nil, errCorrupt("ext4", "too many groups") }
+	firstDataBlock := int64(le.Uint32(super[20:]))
+	// the descriptors of every group must be inside of the image, before
+	// any memory is set aside for them
+	start := (firstDataBlock + 1) * this.blockSize
+	if start + groups * descriptorSize > size {
+		return nil, errCorrupt("ext4", "group descriptors past the end of the image")
+	}
+	descriptors := make([]byte, groups * descriptorSize)
+	_, err = image.ReadAt(descriptors, start)
+	if err != nil { return nil, err }
+	for group := int64(0); group < groups; group ++ {
+		descriptor := descriptors[group * descriptorSize:][:descriptorSize]
+		table := uint64(le.Uint32(descriptor[8:]))
+		if descriptorSize >= 64 { table |= uint64(le.Uint32(descriptor[0x28:])) << 32 }
+		this.inodeTables = append(this.inodeTables, table)
+	}
+	return this, nil
+}
+
+func (this *extTree) root () (*node, error) {
+	return this.node("", 2)
+}
+
+// node reads an inode, and describes it as a file with a name.
+func (this *extTree) node (name string, number uint32) (*node, error) {
+	if number == 0 || number > this.inodeCount {
+		return nil, errCorrupt("ext4", "invalid inode ", number)
+	}
+	index := number - 1
+	group := index / this.inodesPerGroup
+	raw := make([]byte, this.inodeSize)
+	offset := int64(this.inodeTables[group]) * this.blockSize +
+		int64(index % this.inodesPerGroup) * this.inodeSize
+	_, err := this.image.ReadAt(raw, offset)
+	if err != nil { return nil, err }
+
+	le := binary.LittleEndian
+	mode := uint32(le.Uint16(raw[0:]))
+	size := int64(le.Uint32(raw[4:]))
+	if this.inodeSize > 0x6c { size |= int64(le.Uint32(raw[0x6c:])) << 32 }
+	return &node {
+		name:    name,
+		mode:    unixMode(mode),
+		size:    size,
+		modTime: time.Unix(int64(int32(le.Uint32(raw[0x10:]))), 0),
+		key:     number,
+		data:    &extInode { number: number, raw: raw, flags: le.Uint32(raw[0x20:]) },
+	}, nil
+}
+
+func (this *extTree) list (directory *node) ([]*node, error) {
+	if directory.size > maxDirectorySize || directory.size > this.size {
+		return nil, errCorrupt("ext4", "directory too large")
+	}
+	content, err := this.open(directory)
+	if err != nil { return nil, err }
+	data := make([]byte, directory.size)
+	_, err = content.ReadAt(data, 0)
+	if err != nil && err != io.EOF { return nil, err }
+
+	inode := directory.data.(*extInode)
+	if inode.flags & extFlagInline != 0 {
+		// inline directories start with the inode of their parent
+		// instead of entries for . and ..
+		if len(data) < 4 { return nil, errCorrupt("ext4", "invalid inline directory") }
+		data = data[4:]
+	}
+
+	le := binary.LittleEndian
+	var entries []*node
+	for len(data) >= 8 {
+		number := le.Uint32(data[0:])
+		length := int(le.Uint16(data[4:]))
+		nameLength := int(data[6])
+		if !this.filetype { nameLength |= int(data[7]) << 8 }
+		if length < 8 || length > len(data) || 8 + nameLength > length {
+			// the rest of a block holding a corrupt entry is skipped
+			skip := int(this.blockSize) - (int(directory.size) - len(data)) % int(this.blockSize)
+			if skip <= 0 || skip > len(data) { break }
+			data = data[skip:]
+			continue
+		}
+		name := string(data[8:8 + nameLength])
+		data = data[length:]
+		if number == 0 || name == "." || name == ".." { continue }
+
+		entry, err := this.node(name, number)
+		if err != nil { return nil, err }
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (this *extTree) open (file *node) (io.ReaderAt, error) {
+	inode := file.data.(*extInode)
+	switch {
+	case inode.flags & extFlagInline != 0:
+		data, err := this.inlineData(inode)
+		if err != nil { return nil, err }
+		return bytes.NewReader(data), nil
+	case inode.flags & extFlagExtents != 0:
+		var extents []extent
+		err := this.readExtents(inode.raw[0x28:0x28 + 60], &extents, 0)
+		if err != nil { return nil, err }
+		return &extentReader { image: this.image, extents: extents, size: file.size }, nil
+	}
+	extents, err := this.readBlockMap(inode.raw[0x28:0x28 + 60], file.size)
+	if err != nil { return nil, err }
+	return &extentReader { image: this.image, extents: extents, size: file.size }, nil
+}
+
+func (this *extTree) readLink (link *node) (string, error) {
+	inode := link.data.(*extInode)
+	// short targets are stored in the inode instead of in blocks
+	if link.size < 60 && inode.flags & (extFlagExtents | extFlagInline) == 0 {
+		return string(inode.raw[0x28:0x28 + link.size]), nil
+	}
+	if link.size > 4096 { return "", errCorrupt("ext4", "symbolic link too long") }
+	content, err := this.open(link)
+	if err != nil { return "", err }
+	target := make([]byte, link.size)
+	_, err = content.ReadAt(target, 0)
+	if err != nil && err != io.EOF { return "", err }
+	return string(target), nil
+}
+
+// readExtents reads a node of the extent tree of a file.
+func (this *extTree) readExtents (data []byte, extents *[]extent, depth int) error {
+	le := binary.LittleEndian
+	if len(data) < 12 || le.Uint16(data[0:]) != 0xf30a || depth > 5 {
+		return errCorrupt("ext4", "invalid extent tree")
+	}
+	count := int(le.Uint16(data[2:]))
+	if 12 + 12 * count > len(data) { return errCorrupt("ext4", "invalid extent tree") }
+	leaf := le.Uint16(data[6:]) == 0
+
+	for index := 0; index < count; index ++ {
+		entry := data[12 + 12 * index:]
+		if leaf {
+			length := int64(le.Uint16(entry[4:]))
+			// extents longer than this are allocated but not written,
+			// and read as zeros
+			if length > 32768 { continue }
+			start := int64(le.Uint16(entry[6:])) << 32 | int64(le.Uint32(entry[8:]))
+			*extents = append(*extents, extent {
+				logical:  int64(le.Uint32(entry[0:])) * this.blockSize,
+				physical: start * this.blockSize,
+				length:   length * this.blockSize,
+			})
+			continue
+		}
+		child := int64(le.Uint32(entry[4:])) | int64(le.Uint16(entry[8:])) << 32
+		block := make([]byte, this.blockSize)
+		_, err := this.image.ReadAt(block, child * this.blockSize)
+		if err != nil { return err }
+		err = this.readExtents(block, extents, depth + 1)
+		if err != nil { return err }
+	}
+	return nil
+}
+
+// readBlockMap reads the blocks of a file of ext2 or ext3, which are listed
+// directly in its inode for the first twelve, and through blocks of pointers
+// for the rest.
+func (this *extTree) readBlockMap (pointers []byte, size int64) ([]extent, error) {
+	le := binary.LittleEndian
+	blocks := (size + this.blockSize - 1) / this.blockSize
+	perBlock := this.blockSize / 4
+	var extents []extent
+	logical := int64(0)
+
+	add := func (block uint32) {
+		if block != 0 {
+			physical := int64(block) * this.blockSize
+			last := len(extents) - 1
+			if last >= 0 &&
+				extents[last].logical  + extents[last].length == logical * this.blockSize &&
+				extents[last].physical + extents[last].length == physical {
+				extents[last].length += this.blockSize
+			} else {
+				extents = append(extents, extent {
+					logical:  logical * this.blockSize,
+					physical: physical,
+					length:   this.blockSize,
+				})
+			}
+		}
+		logical ++
+	}
+
+	// walk reads a block of pointers, which are themselves blocks of
+	// pointers at a depth above zero
+	var walk func (block uint32, depth int) error
+	walk = func (block uint32, depth int) error {
+		span := int64(1)
+		for level := 0; level < depth; level ++ { span *= perBlock }
+		if block == 0 {
+			// a hole as large as everything the block points to
+			logical += span * perBlock
+			return nil
+		}
+		data := make([]byte, this.blockSize)
+		_, err := this.image.ReadAt(data, int64(block) * this.blockSize)
+		if err != nil { return err }
+		for index := int64(0); index < perBlock && logical < blocks; index ++ {
+			pointer := le.Uint32(data[index * 4:])
+			if depth == 0 {
+				add(pointer)
+				continue
+			}
+			err = walk(pointer, depth - 1)
+			if err != nil { return err }
+		}
+		return nil
+	}
+
+	for index := 0; index < 12 && logical < blocks; index ++ {
+		add(le.Uint32(pointers[index * 4:]))
+	}
+	for depth := 0; depth < 3 && logical < blocks; depth ++ {
+		err := walk(le.Uint32(pointers[(12 + depth) * 4:]), depth)
+		if err != nil { return nil, err }
+	}
+	return extents, nil
+}
+
+// inlineData reads a file stored inside of its inode, which starts in the
+// space usually holding its blocks, and continues in an extended attribute.
+func (this *extTree) inlineData (inode *extInode) ([]byte, error) {
+	data := append([]byte(nil), inode.raw[0x28:0x28 + 60]...)
+	le := binary.LittleEndian
+	if len(inode.raw) <= 0x82 { return data, nil }
+	start := 128 + int(le.Uint16(inode.raw[0x80:]))
+	if start + 4 > len(inode.raw) || le.Uint32(inode.raw[start:]) != 0xea020000 {
+		return data, nil
+	}
+	attributes := inode.raw[start + 4:]
+	for offset := 0; offset + 16 <= len(attributes); {
+		entry := attributes[offset:]
+		nameLength := int(entry[0])
+		if nameLength == 0 && le.Uint32(entry) == 0 { break }
+		if 16 + nameLength > len(entry) { break }
+		name := string(entry[16:16 + nameLength])
+		// the system.data attribute
+		if entry[1] == 7 && name == "data" {
+			valueOffset := int(le.Uint16(entry[2:]))
+			valueSize   := int(le.Uint32(entry[8:]))
+			if valueOffset + valueSize > len(attributes) {
+				return nil, errCorrupt("ext4", "invalid inline data of inode ", inode.number)
+			}
+			data = append(data, attributes[valueOffset:valueOffset + valueSize]...)
+			break
+		}
+		offset += (16 + nameLength + 3) &^ 3
+	}
+	return data, nil
+}
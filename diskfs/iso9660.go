@@ -0,0 +1,293 @@
+package diskfs
+
+import "io"
+import "time"
+import "io/fs"
+import "strings"
+import "unicode/utf16"
+import "encoding/binary"
+
+// isoSectorSize is how large the sectors holding the volume descriptors are.
+const isoSectorSize = 2048
+
+// flags of ISO 9660 directory records
+const (
+	isoFlagDirectory   = 0x2
+	isoFlagMultiExtent = 0x80
+)
+
+// isoTree is an ISO 9660 filesystem. Names and attributes are read from Rock
+// Ridge extensions where present, and otherwise from Joliet ones.
+type isoTree struct {
+	image     io.ReaderAt
+	size      int64
+	blockSize int64
+	top       []byte
+	joliet    bool
+	rockRidge bool
+	// how many bytes to skip at the start of the system use area of each
+	// directory record, as given by Rock Ridge
+	skip      int
+}
+
+// isoFile is what is needed of a directory record to read its file.
+type isoFile struct {
+	extents []extent
+	target  string
+}
+
+func openISO9660 (image io.ReaderAt, size int64) (*isoTree, error) {
+	this := &isoTree { image: image, size: size }
+	var joliet []byte
+	sector := make([]byte, isoSectorSize)
+	for index := int64(16); index < 16 + 64; index ++ {
+		_, err := image.ReadAt(sector, index * isoSectorSize)
+		if err != nil { return nil, err }
+		if string(sector[1:6]) != "CD001" { return nil, errCorrupt("ISO 9660", "invalid volume descriptor") }
+		kind := sector[0]
+		if kind == 255 { break }
+		switch {
+		case kind == 1 && this.top == nil:
+			this.blockSize = int64(binary.LittleEndian.Uint16(sector[128:]))
+			this.top = append([]byte(nil), sector[156:156 + 34]...)
+		case kind == 2 && joliet == nil:
+			escape := string(sector[88:91])
+			if escape == "%/@" || escape == "%/C" || escape == "%/E" {
+				joliet = append([]byte(nil), sector[156:156 + 34]...)
+			}
+		}
+	}
+	if this.top == nil { return nil, errCorrupt("ISO 9660", "no primary volume descriptor") }
+	if this.blockSize < 512 || this.blockSize > isoSectorSize {
+		return nil, errCorrupt("ISO 9660", "invalid block size")
+	}
+
+	// Rock Ridge is announced by the first record of the root directory
+	root, err := this.node(this.top)
+	if err != nil { return nil, err }
+	data, err := this.readDirectory(root)
+	if err != nil { return nil, err }
+	if len(data) > 0 && int(data[0]) <= len(data) {
+		system := systemUse(data[:data[0]])
+		if len(system) >= 7 && string(system[0:2]) == "SP" && system[4] == 0xbe && system[5] == 0xef {
+			this.rockRidge = true
+			this.skip = int(system[6])
+		}
+	}
+	if !this.rockRidge && joliet != nil {
+		this.top, this.joliet = joliet, true
+	}
+	return this, nil
+}
+
+func (this *isoTree) root () (*node, error) {
+	return this.node(this.top)
+}
+
+// node describes the file of a directory record, without its name.
+func (this *isoTree) node (record []byte) (*node, error) {
+	if len(record) < 34 || int(record[0]) > len(record) { return nil, errCorrupt("ISO 9660", "invalid directory record") }
+	le := binary.LittleEndian
+	start := int64(le.Uint32(record[2:])) + int64(record[1])
+	size := int64(le.Uint32(record[10:]))
+	date := record[18:25]
+	mode := uint32(0100444)
+	if record[25] & isoFlagDirectory != 0 { mode = 0040555 }
+	return &node {
+		mode:    unixMode(mode),
+		size:    size,
+		modTime: time.Date(
+			1900 + int(date[0]), time.Month(date[1]), int(date[2]),
+			int(date[3]), int(date[4]), int(date[5]), 0,
+			time.FixedZone("", int(int8(date[6])) * 15 * 60)),
+		key:     start,
+		data:    &isoFile { extents: []extent { {
+			physical: start * this.blockSize,
+			length:   size,
+		} } },
+	}, nil
+}
+
+// readDirectory reads the records of a directory.
+func (this *isoTree) readDirectory (directory *node) ([]byte, error) {
+	start := directory.data.(*isoFile).extents[0].physical
+	if directory.size > maxDirectorySize || start + directory.size > this.size {
+		return nil, errCorrupt("ISO 9660", "directory too large")
+	}
+	data := make([]byte, directory.size)
+	_, err := this.image.ReadAt(data, start)
+	if err != nil { return nil, err }
+	return data, nil
+}
+
+func (this *isoTree) list (directory *node) ([]*node, error) {
+	data, err := this.readDirectory(directory)
+	if err != nil { return nil, err }
+
+	var entries []*node
+	// the file whose last record was marked as continuing in the next
+	var continued *node
+	for position := 0; position < len(data); {
+		length := int(data[position])
+		if length == 0 {
+			// records do not cross blocks, which are padded with zeros
+			position = int((int64(position) / this.blockSize + 1) * this.blockSize)
+			continue
+		}
+		if length < 34 || position + length > len(data) {
+			return nil, errCorrupt("ISO 9660", "invalid directory record")
+		}
+		record := data[position:position + length]
+		position += length
+
+		found, err := this.node(record)
+		if err != nil { return nil, err }
+		if continued != nil {
+			// the parts of files larger than 4 GiB follow each other
+			file   := continued.data.(*isoFile)
+			part   := found.data.(*isoFile).extents[0]
+			part.logical = continued.size
+			file.extents = append(file.extents, part)
+			continued.size += found.size
+			if record[25] & isoFlagMultiExtent == 0 { continued = nil }
+			continue
+		}
+
+		nameLength := int(record[32])
+		if 33 + nameLength > len(record) { return nil, errCorrupt("ISO 9660", "invalid directory record") }
+		name := record[33:33 + nameLength]
+		// the records for . and ..
+		if nameLength == 1 && (name[0] == 0 || name[0] == 1) { continue }
+
+		switch {
+		case this.rockRidge:
+			relocated, err := this.rockRidgeEntry(found, record)
+			if err != nil { return nil, err }
+			if relocated { continue }
+		case this.joliet:
+			units := make([]uint16, nameLength / 2)
+			for index := range units {
+				units[index] = binary.BigEndian.Uint16(name[index * 2:])
+			}
+			found.name = string(utf16.Decode(units))
+		}
+		plain := found.name == ""
+		if plain {
+			// plain names are upper case
+			found.name = strings.ToLower(string(name))
+		}
+		if plain || this.joliet {
+			// and both they and Joliet names end in a version
+			found.name, _, _ = strings.Cut(found.name, ";")
+			if !found.IsDir() { found.name = strings.TrimSuffix(found.name, ".") }
+		}
+		if record[25] & isoFlagMultiExtent != 0 { continued = found }
+		entries = append(entries, found)
+	}
+	return entries, nil
+}
+
+func (this *isoTree) open (file *node) (io.ReaderAt, error) {
+	return &extentReader { image: this.image, extents: file.data.(*isoFile).extents, size: file.size }, nil
+}
+
+func (this *isoTree) readLink (link *node) (string, error) {
+	return link.data.(*isoFile).target, nil
+}
+
+// systemUse returns the system use area of a directory record, which holds
+// extensions such as Rock Ridge.
+func systemUse (record []byte) []byte {
+	if len(record) < 33 { return nil }
+	start := 33 + int(record[32])
+	if start % 2 == 1 { start ++ }
+	if start > len(record) { return nil }
+	return record[start:]
+}
+
+// rockRidgeEntry reads the Rock Ridge extensions of a directory record into the
+// file it describes. It returns true for directories which were relocated,
+// which must be skipped as they are reached from elsewhere.
+func (this *isoTree) rockRidgeEntry (found *node, record []byte) (bool, error) {
+	system := systemUse(record)
+	if this.skip > len(system) { return false, nil }
+	system = system[this.skip:]
+
+	var name   strings.Builder
+	var target strings.Builder
+	// whether the last component of a symbolic link continues in the next
+	continuing := false
+	relocated  := false
+	var child  *node
+	var err    error
+	le := binary.LittleEndian
+
+	for areas := 0; len(system) >= 4 && areas < 32; {
+		length := int(system[2])
+		if length < 4 || length > len(system) { break }
+		entry := system[:length]
+		system = system[length:]
+		switch string(entry[0:2]) {
+		case "NM":
+			if length > 5 { name.Write(entry[5:]) }
+		case "PX":
+			if length >= 12 {
+				mode := le.Uint32(entry[4:])
+				found.mode = unixMode(mode)
+			}
+		case "SL":
+			if length < 5 { break }
+			for components := entry[5:]; len(components) >= 2; {
+				flags := components[0]
+				size  := int(components[1])
+				if 2 + size > len(components) { break }
+				if target.Len() > 0 && !continuing && !strings.HasSuffix(target.String(), "/") {
+					target.WriteByte('/')
+				}
+				switch {
+				case flags & 0x2 != 0: target.WriteString(".")
+				case flags & 0x4 != 0: target.WriteString("..")
+				case flags & 0x8 != 0: target.WriteString("/")
+				default:               target.Write(components[2:2 + size])
+				}
+				continuing = flags & 0x1 != 0
+				components = components[2 + size:]
+			}
+		case "CL":
+			// a directory moved elsewhere to keep the tree shallow
+			if length < 12 { break }
+			start := int64(le.Uint32(entry[4:]))
+			moved := make([]byte, 34)
+			_, err = this.image.ReadAt(moved, start * this.blockSize)
+			if err != nil { return false, err }
+			child, err = this.node(moved)
+			if err != nil { return false, err }
+		case "RE":
+			relocated = true
+		case "CE":
+			// the extensions continue elsewhere
+			if length < 28 { break }
+			start  := int64(le.Uint32(entry[4:])) * this.blockSize + int64(le.Uint32(entry[12:]))
+			size   := int64(le.Uint32(entry[20:]))
+			if size > this.blockSize { return false, errCorrupt("ISO 9660", "invalid continuation area") }
+			system = make([]byte, size)
+			_, err = this.image.ReadAt(system, start)
+			if err != nil { return false, err }
+			areas ++
+		case "ST":
+			system = nil
+		}
+	}
+	found.name = name.String()
+	if child != nil {
+		found.mode = fs.ModeDir | found.mode.Perm()
+		found.size = child.size
+		found.key  = child.key
+		found.data = child.data
+	}
+	if found.mode & fs.ModeSymlink != 0 {
+		found.data.(*isoFile).target = target.String()
+		found.size = int64(target.Len())
+	}
+	return relocated, nil
+}
@@ -0,0 +1,188 @@
+package diskfs
+
+import "io"
+import "fmt"
+import "bytes"
+import "errors"
+import "unicode/utf16"
+import "encoding/binary"
+
+// ErrNoPartitionTable is returned by Partitions for images of disks without an
+// MBR or GPT partition table.
+var ErrNoPartitionTable = errors.New("no MBR or GPT partition table")
+
+// Partition is a partition of a disk image.
+type Partition struct {
+	// Number is the position of the partition in its table, from one.
+	// Logical partitions of MBR tables are numbered from five, as they are
+	// by Linux.
+	Number int
+	// Type is the partition type of an MBR, such as 0x83, or the partition
+	// type GUID of a GPT.
+	Type   string
+	// Name is the label of a GPT partition
+	Name   string
+	// Where the partition is in the image, in bytes
+	Start  int64
+	Size   int64
+}
+
+// maxPartitions is how many partitions are read from a table, which also
+// bounds how many extended boot records are followed.
+const maxPartitions = 256
+
+// MBR partition types holding further partitions
+var mbrExtended = map[byte] bool { 0x05: true, 0x0f: true, 0x85: true }
+
+// mbrProtective is the MBR partition type covering a disk with a GPT.
+const mbrProtective = 0xee
+
+// Partitions reads the partition table of a disk image of a number of bytes,
+// which is either an MBR or a GPT. Empty partitions, and extended partitions
+// of MBRs, are left out.
+func Partitions (image io.ReaderAt, size int64) ([]Partition, error) {
+	image = truncatedReader { image }
+	// a GPT may follow a sector of 4096 bytes on disks which have them
+	for _, sector := range []int64 { 512, 4096 } {
+		partitions, err := readGPT(image, size, sector)
+		if err != ErrNoPartitionTable { return partitions, err }
+	}
+	return readMBR(image, size)
+}
+
+// mbrEntries reads the four partition entries of a boot record, which must end
+// with its signature and have valid boot indicators.
+func mbrEntries (image io.ReaderAt, offset int64) ([][]byte, error) {
+	record := make([]byte, 512)
+	_, err := image.ReadAt(record, offset)
+	if err != nil { return nil, err }
+	if record[510] != 0x55 || record[511] != 0xaa { return nil, ErrNoPartitionTable }
+	entries := make([][]byte, 4)
+	for index := range entries {
+		entries[index] = record[446 + 16 * index:][:16]
+		if entries[index][0] & 0x7f != 0 { return nil, ErrNoPartitionTable }
+	}
+	return entries, nil
+}
+
+func readMBR (image io.ReaderAt, size int64) ([]Partition, error) {
+	if size < 512 { return nil, ErrNoPartitionTable }
+	entries, err := mbrEntries(image, 0)
+	if err != nil { return nil, err }
+
+	le := binary.LittleEndian
+	var partitions []Partition
+	// partition adds the partition of an entry, whose start is relative to
+	// the sector at base
+	partition := func (number int, entry []byte, base int64) error {
+		start  := (base + int64(le.Uint32(entry[8:]))) * 512
+		length := int64(le.Uint32(entry[12:])) * 512
+		if start + length > size { return errCorrupt("MBR", "partition ", number, " is past the end of the disk") }
+		partitions = append(partitions, Partition {
+			Number: number,
+			Type:   fmt.Sprintf("0x%02x", entry[4]),
+			Start:  start,
+			Size:   length,
+		})
+		return nil
+	}
+
+	var extended []byte
+	for index, entry := range entries {
+		kind := entry[4]
+		switch {
+		case kind == 0 || le.Uint32(entry[12:]) == 0:
+			continue
+		case kind == mbrProtective:
+			return nil, errCorrupt("GPT", "no valid header behind its protective MBR")
+		case mbrExtended[kind]:
+			if extended == nil { extended = entry }
+			continue
+		}
+		err = partition(index + 1, entry, 0)
+		if err != nil { return nil, err }
+	}
+	if extended == nil { return partitions, nil }
+
+	// logical partitions are described by a chain of boot records inside
+	// of the extended partition, each locating the next relative to it
+	first := int64(le.Uint32(extended[8:]))
+	next  := first
+	seen  := map[int64] bool { }
+	for number := 5; number < 5 + maxPartitions && !seen[next]; number ++ {
+		seen[next] = true
+		entries, err := mbrEntries(image, next * 512)
+		if err == ErrNoPartitionTable { return nil, errCorrupt("MBR", "invalid extended boot record") }
+		if err != nil { return nil, err }
+		if entries[0][4] != 0 && le.Uint32(entries[0][12:]) != 0 {
+			err = partition(number, entries[0], next)
+			if err != nil { return nil, err }
+		}
+		link := int64(le.Uint32(entries[1][8:]))
+		if link == 0 || !mbrExtended[entries[1][4]] { break }
+		next = first + link
+	}
+	return partitions, nil
+}
+
+func readGPT (image io.ReaderAt, size int64, sector int64) ([]Partition, error) {
+	if size < 2 * sector { return nil, ErrNoPartitionTable }
+	header := make([]byte, 92)
+	_, err := image.ReadAt(header, sector)
+	if err != nil { return nil, err }
+	if !bytes.Equal(header[:8], []byte("EFI PART")) { return nil, ErrNoPartitionTable }
+
+	le := binary.LittleEndian
+	table := int64(le.Uint64(header[72:]))
+	count := int64(le.Uint32(header[80:]))
+	entrySize := int64(le.Uint32(header[84:]))
+	if entrySize < 128 || count * entrySize > 1 << 20 || table < 2 || table > size / sector {
+		return nil, errCorrupt("GPT", "invalid header")
+	}
+	entries := make([]byte, count * entrySize)
+	_, err = image.ReadAt(entries, table * sector)
+	if err != nil { return nil, err }
+
+	var partitions []Partition
+	for index := int64(0); index < count; index ++ {
+		entry := entries[index * entrySize:][:entrySize]
+		// unused entries have a type of zeros
+		if bytes.Equal(entry[:16], make([]byte, 16)) { continue }
+		if len(partitions) == maxPartitions { return nil, errCorrupt("GPT", "too many partitions") }
+		first := le.Uint64(entry[32:])
+		last  := le.Uint64(entry[40:])
+		if last < first || last >= uint64(size / sector) {
+			return nil, errCorrupt("GPT", "partition ", index + 1, " is past the end of the disk")
+		}
+		units := make([]uint16, 36)
+		for unit := range units {
+			units[unit] = le.Uint16(entry[56 + 2 * unit:])
+		}
+		if end := indexUint16(units, 0); end >= 0 { units = units[:end] }
+		partitions = append(partitions, Partition {
+			Number: int(index + 1),
+			Type:   guid(entry[:16]),
+			Name:   string(utf16.Decode(units)),
+			Start:  int64(first) * sector,
+			Size:   int64(last - first + 1) * sector,
+		})
+	}
+	return partitions, nil
+}
+
+// guid formats a GUID as it is usually written, with its first three fields
+// stored little endian.
+func guid (data []byte) string {
+	le := binary.LittleEndian
+	return fmt.Sprintf (
+		"%08X-%04X-%04X-%X-%X",
+		le.Uint32(data[0:]), le.Uint16(data[4:]), le.Uint16(data[6:]),
+		data[8:10], data[10:16])
+}
+
+func indexUint16 (units []uint16, value uint16) int {
+	for index, unit := range units {
+		if unit == value { return index }
+	}
+	return -1
+}
@@ -0,0 +1,191 @@
+package diskfs
+
+import "bytes"
+import "errors"
+import "strings"
+import "testing"
+import "reflect"
+import "unicode/utf16"
+import "encoding/binary"
+
+// testDisk is an image of a disk of sectors of 512 bytes.
+type testDisk []byte
+
+func newTestDisk (sectors int) testDisk {
+	return make(testDisk, sectors * 512)
+}
+
+// bootRecord writes a boot record at a sector, with up to four entries.
+func (this testDisk) bootRecord (sector int, entries ...[]byte) testDisk {
+	record := this[sector * 512:][:512]
+	for index, entry := range entries {
+		copy(record[446 + 16 * index:], entry)
+	}
+	record[510], record[511] = 0x55, 0xaa
+	return this
+}
+
+func mbrEntry (kind byte, start, count uint32) []byte {
+	entry := make([]byte, 16)
+	entry[4] = kind
+	binary.LittleEndian.PutUint32(entry[8:], start)
+	binary.LittleEndian.PutUint32(entry[12:], count)
+	return entry
+}
+
+// the partition type GUID of Linux filesystems, as stored
+var linuxGUID = []byte {
+	0xaf, 0x3d, 0xc6, 0x0f, 0x83, 0x84, 0x72, 0x47,
+	0x8e, 0x79, 0x3d, 0x69, 0xd8, 0x47, 0x7d, 0xe4,
+}
+
+type gptEntry struct {
+	first, last uint64
+	name        string
+}
+
+// gpt writes a GPT whose entries start at the third sector, behind a
+// protective MBR.
+func (this testDisk) gpt (sector int, entrySize uint32, entries ...gptEntry) testDisk {
+	this.bootRecord(0, mbrEntry(mbrProtective, 1, uint32(len(this) / sector - 1)))
+	le := binary.LittleEndian
+	header := this[sector:]
+	copy(header, "EFI PART")
+	le.PutUint64(header[72:], 2)
+	le.PutUint32(header[80:], 4)
+	le.PutUint32(header[84:], entrySize)
+	for index, entry := range entries {
+		// unused entries are left as zeros
+		if entry == (gptEntry { }) { continue }
+		raw := this[2 * sector + index * int(entrySize):]
+		copy(raw, linuxGUID)
+		le.PutUint64(raw[32:], entry.first)
+		le.PutUint64(raw[40:], entry.last)
+		for unit, value := range utf16.Encode([]rune(entry.name)) {
+			le.PutUint16(raw[56 + 2 * unit:], value)
+		}
+	}
+	return this
+}
+
+func TestPartitions (test *testing.T) {
+	const linux = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+	cases := []struct {
+		name     string
+		disk     testDisk
+		expected []Partition
+		// part of the error expected, if any
+		err      string
+	} {
+		{
+			name: "primary partitions",
+			disk: newTestDisk(100).bootRecord(0,
+				mbrEntry(0x83, 10, 20), mbrEntry(0, 0, 0), mbrEntry(0x82, 40, 10)),
+			expected: []Partition {
+				{ Number: 1, Type: "0x83", Start: 10 * 512, Size: 20 * 512 },
+				{ Number: 3, Type: "0x82", Start: 40 * 512, Size: 10 * 512 },
+			},
+		}, {
+			name: "logical partitions",
+			disk: newTestDisk(100).
+				bootRecord(0, mbrEntry(0x83, 10, 20), mbrEntry(0x05, 50, 40)).
+				bootRecord(50, mbrEntry(0x83, 1, 9), mbrEntry(0x05, 20, 20)).
+				bootRecord(70, mbrEntry(0x0c, 1, 10)),
+			expected: []Partition {
+				{ Number: 1, Type: "0x83", Start: 10 * 512, Size: 20 * 512 },
+				{ Number: 5, Type: "0x83", Start: 51 * 512, Size: 9 * 512 },
+				{ Number: 6, Type: "0x0c", Start: 71 * 512, Size: 10 * 512 },
+			},
+		}, {
+			name: "looping extended boot records",
+			disk: newTestDisk(100).
+				bootRecord(0, mbrEntry(0x05, 50, 40)).
+				bootRecord(50, mbrEntry(0x83, 1, 9), mbrEntry(0x05, 20, 20)).
+				bootRecord(70, mbrEntry(0x83, 1, 9), mbrEntry(0x05, 20, 20)),
+			expected: []Partition {
+				{ Number: 5, Type: "0x83", Start: 51 * 512, Size: 9 * 512 },
+				{ Number: 6, Type: "0x83", Start: 71 * 512, Size: 9 * 512 },
+			},
+		}, {
+			name: "invalid extended boot record",
+			disk: newTestDisk(100).bootRecord(0, mbrEntry(0x05, 50, 40)),
+			err:  "invalid extended boot record",
+		}, {
+			name: "extended boot record past the end",
+			disk: newTestDisk(100).bootRecord(0, mbrEntry(0x05, 500, 40)),
+			err:  "unexpected EOF",
+		}, {
+			name: "primary partition past the end",
+			disk: newTestDisk(100).bootRecord(0, mbrEntry(0x83, 10, 91)),
+			err:  "partition 1 is past the end of the disk",
+		}, {
+			name: "logical partition past the end",
+			disk: newTestDisk(100).
+				bootRecord(0, mbrEntry(0x05, 50, 40)).
+				bootRecord(50, mbrEntry(0x83, 1, 0xffffffff)),
+			err:  "partition 5 is past the end of the disk",
+		}, {
+			name: "GPT",
+			disk: newTestDisk(100).gpt(512, 128,
+				gptEntry { 34, 43, "root" }, gptEntry { }, gptEntry { 44, 99, "" }),
+			expected: []Partition {
+				{ Number: 1, Type: linux, Name: "root", Start: 34 * 512, Size: 10 * 512 },
+				{ Number: 3, Type: linux, Start: 44 * 512, Size: 56 * 512 },
+			},
+		}, {
+			name: "GPT of sectors of 4096 bytes",
+			disk: newTestDisk(80).gpt(4096, 128, gptEntry { 6, 9, "data" }),
+			expected: []Partition {
+				{ Number: 1, Type: linux, Name: "data", Start: 6 * 4096, Size: 4 * 4096 },
+			},
+		}, {
+			name: "GPT partition past the end",
+			disk: newTestDisk(100).gpt(512, 128, gptEntry { 34, 100, "" }),
+			err:  "partition 1 is past the end of the disk",
+		}, {
+			name: "GPT partition ending before it starts",
+			disk: newTestDisk(100).gpt(512, 128, gptEntry { 34, 33, "" }),
+			err:  "partition 1 is past the end of the disk",
+		}, {
+			name: "GPT entries too small",
+			disk: newTestDisk(100).gpt(512, 64),
+			err:  "corrupt GPT image: invalid header",
+		}, {
+			name: "GPT entries too large",
+			disk: newTestDisk(100).gpt(512, 1 << 30),
+			err:  "corrupt GPT image: invalid header",
+		}, {
+			name: "protective MBR without a GPT",
+			disk: newTestDisk(100).bootRecord(0, mbrEntry(mbrProtective, 1, 99)),
+			err:  "no valid header behind its protective MBR",
+		},
+	}
+	for _, current := range cases {
+		partitions, err := Partitions(bytes.NewReader(current.disk), int64(len(current.disk)))
+		switch {
+		case current.err != "":
+			if err == nil || !strings.Contains(err.Error(), current.err) {
+				test.Errorf("%v: expected %q, got %v", current.name, current.err, err)
+			}
+		case err != nil:
+			test.Errorf("%v: %v", current.name, err)
+		case !reflect.DeepEqual(partitions, current.expected):
+			test.Errorf("%v: expected %+v, got %+v", current.name, current.expected, partitions)
+		}
+	}
+}
+
+func TestNoPartitionTable (test *testing.T) {
+	invalidBoot := newTestDisk(100).bootRecord(0, mbrEntry(0x83, 10, 20))
+	invalidBoot[446] = 0x12
+	for name, disk := range map[string] testDisk {
+		"empty disk":             newTestDisk(100),
+		"short disk":             newTestDisk(1)[:300],
+		"invalid boot indicator": invalidBoot,
+	} {
+		_, err := Partitions(bytes.NewReader(disk), int64(len(disk)))
+		if !errors.Is(err, ErrNoPartitionTable) {
+			test.Errorf("%v: expected no partition table, got %v", name, err)
+		}
+	}
+}
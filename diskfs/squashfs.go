@@ -0,0 +1,432 @@
+package diskfs
+
+import "io"
+import "sync"
+import "time"
+import "bytes"
+import "errors"
+import "compress/zlib"
+import "encoding/binary"
+import "github.com/ulikunitz/xz"
+import "github.com/pierrec/lz4/v4"
+import "github.com/ulikunitz/xz/lzma"
+import "github.com/klauspost/compress/zstd"
+
+// squashfsMetadataSize is how large blocks of metadata are once decompressed.
+const squashfsMetadataSize = 8192
+
+// squashfsUncompressed marks blocks of data stored without compression.
+const squashfsUncompressed = 1 << 24
+
+// squashfsTree is a squashfs filesystem, of version 4.
+type squashfsTree struct {
+	image          io.ReaderAt
+	blockSize      int64
+	rootInode      uint64
+	inodeTable     int64
+	directoryTable int64
+	fragmentTable  int64
+	fragmentCount  uint32
+	decompress     func (data []byte, limit int64) ([]byte, error)
+
+	lock     sync.Mutex
+	metadata map[int64] squashfsBlock
+}
+
+// squashfsBlock is a block of metadata, along with where the next one starts.
+type squashfsBlock struct {
+	data []byte
+	next int64
+}
+
+// squashfsInode is what is needed of an inode to read its file.
+type squashfsInode struct {
+	// where the first block of a regular file starts, and how large each
+	// block is
+	start      int64
+	blocks     []uint32
+	// the fragment holding the end of a regular file, if any
+	fragment   uint32
+	offset     uint32
+	// where the entries of a directory start
+	dirBlock   int64
+	dirOffset  int
+	target     string
+}
+
+// squashfsNoFragment marks files which do not end in a fragment.
+const squashfsNoFragment = 0xffffffff
+
+func openSquashfs (image io.ReaderAt, size int64) (*squashfsTree, error) {
+	super := make([]byte, 96)
+	_, err := image.ReadAt(super, 0)
+	if err != nil { return nil, err }
+	le := binary.LittleEndian
+	if le.Uint16(super[28:]) != 4 {
+		return nil, errors.New("unsupported squashfs version")
+	}
+	if int64(le.Uint64(super[40:])) > size {
+		return nil, errCorrupt("squashfs", "truncated")
+	}
+
+	this := &squashfsTree {
+		image:          image,
+		blockSize:      int64(le.Uint32(super[12:])),
+		fragmentCount:  le.Uint32(super[16:]),
+		rootInode:      le.Uint64(super[32:]),
+		inodeTable:     int64(le.Uint64(super[64:])),
+		directoryTable: int64(le.Uint64(super[72:])),
+		fragmentTable:  int64(le.Uint64(super[80:])),
+		metadata:       map[int64] squashfsBlock { },
+	}
+	if this.blockSize < 4096 || this.blockSize > 1 << 20 {
+		return nil, errCorrupt("squashfs", "invalid block size")
+	}
+	this.decompress, err = squashfsDecompressor(le.Uint16(super[20:]))
+	if err != nil { return nil, err }
+	return this, nil
+}
+
+// squashfsDecompressor returns what decompresses the blocks of a squashfs
+// filesystem compressed in a way, as numbered in its superblock.
+func squashfsDecompressor (compressor uint16) (func ([]byte, int64) ([]byte, error), error) {
+	stream := func (open func (io.Reader) (io.Reader, error)) func ([]byte, int64) ([]byte, error) {
+		return func (data []byte, limit int64) ([]byte, error) {
+			reader, err := open(bytes.NewReader(data))
+			if err != nil { return nil, err }
+			return readLimited(reader, limit)
+		}
+	}
+	switch compressor {
+	case 1:
+		return stream(func (reader io.Reader) (io.Reader, error) {
+			return zlib.NewReader(reader)
+		}), nil
+	case 2:
+		return stream(func (reader io.Reader) (io.Reader, error) {
+			return lzma.NewReader(reader)
+		}), nil
+	case 4:
+		return stream(func (reader io.Reader) (io.Reader, error) {
+			return xz.NewReader(reader)
+		}), nil
+	case 5:
+		return func (data []byte, limit int64) ([]byte, error) {
+			decompressed := make([]byte, limit)
+			count, err := lz4.UncompressBlock(data, decompressed)
+			if err != nil { return nil, err }
+			return decompressed[:count], nil
+		}, nil
+	case 6:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil { return nil, err }
+		return func (data []byte, limit int64) ([]byte, error) {
+			decompressed, err := decoder.DecodeAll(data, make([]byte, 0, limit))
+			if err != nil { return nil, err }
+			if int64(len(decompressed)) > limit { return nil, errCorrupt("squashfs", "block too large") }
+			return decompressed, nil
+		}, nil
+	}
+	return nil, errors.New("unsupported squashfs compression")
+}
+
+// readLimited reads everything from a reader, failing if it is larger than a
+// limit.
+func readLimited (reader io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, limit + 1))
+	if err != nil { return nil, err }
+	if int64(len(data)) > limit { return nil, errCorrupt("squashfs", "block too large") }
+	return data, nil
+}
+
+// readMetadata reads the block of metadata at a position in the image.
+func (this *squashfsTree) readMetadata (position int64) (squashfsBlock, error) {
+	this.lock.Lock()
+	block, cached := this.metadata[position]
+	this.lock.Unlock()
+	if cached { return block, nil }
+
+	header := make([]byte, 2)
+	_, err := this.image.ReadAt(header, position)
+	if err != nil { return block, err }
+	length := int64(binary.LittleEndian.Uint16(header) & 0x7fff)
+	data := make([]byte, length)
+	_, err = this.image.ReadAt(data, position + 2)
+	if err != nil { return block, err }
+	if binary.LittleEndian.Uint16(header) & 0x8000 == 0 {
+		data, err = this.decompress(data, squashfsMetadataSize)
+		if err != nil { return block, err }
+	}
+	block = squashfsBlock { data: data, next: position + 2 + length }
+
+	this.lock.Lock()
+	if len(this.metadata) >= maxCached { this.metadata = map[int64] squashfsBlock { } }
+	this.metadata[position] = block
+	this.lock.Unlock()
+	return block, nil
+}
+
+// squashfsReader reads metadata which continues from one block into the next.
+type squashfsReader struct {
+	tree   *squashfsTree
+	block  squashfsBlock
+	offset int
+}
+
+func (this *squashfsTree) metadataReader (position int64, offset int) (*squashfsReader, error) {
+	block, err := this.readMetadata(position)
+	if err != nil { return nil, err }
+	if offset > len(block.data) { return nil, errCorrupt("squashfs", "invalid metadata reference") }
+	return &squashfsReader { tree: this, block: block, offset: offset }, nil
+}
+
+// read reads a number of bytes of metadata.
+func (this *squashfsReader) read (count int) ([]byte, error) {
+	if count < 0 || count > 1 << 24 { return nil, errCorrupt("squashfs", "invalid metadata") }
+	data := make([]byte, 0, count)
+	for len(data) < count {
+		if this.offset >= len(this.block.data) {
+			block, err := this.tree.readMetadata(this.block.next)
+			if err != nil { return nil, err }
+			if len(block.data) == 0 { return nil, errCorrupt("squashfs", "empty metadata block") }
+			this.block, this.offset = block, 0
+		}
+		part := this.block.data[this.offset:]
+		part = part[:min(len(part), count - len(data))]
+		data = append(data, part...)
+		this.offset += len(part)
+	}
+	return data, nil
+}
+
+func (this *squashfsTree) root () (*node, error) {
+	return this.node("", this.rootInode)
+}
+
+// node reads the inode a reference points to, and describes it as a file with
+// a name.
+func (this *squashfsTree) node (name string, reference uint64) (*node, error) {
+	reader, err := this.metadataReader(this.inodeTable + int64(reference >> 16), int(reference & 0xffff))
+	if err != nil { return nil, err }
+	header, err := reader.read(16)
+	if err != nil { return nil, err }
+	le := binary.LittleEndian
+	kind := le.Uint16(header[0:])
+	found := &node {
+		name:    name,
+		modTime: time.Unix(int64(le.Uint32(header[8:])), 0),
+		key:     reference,
+	}
+	inode := &squashfsInode { fragment: squashfsNoFragment }
+	found.data = inode
+
+	// the kinds of files, and their extended versions which have more
+	// fields
+	modes := map[uint16] uint32 {
+		1: 0040000, 2: 0100000, 3: 0120000, 4: 0060000, 5: 0020000, 6: 0010000, 7: 0140000,
+	}
+	base := kind
+	if base > 7 { base -= 7 }
+	mode, known := modes[base]
+	if !known { return nil, errCorrupt("squashfs", "invalid inode type ", kind) }
+	found.mode = unixMode(mode | uint32(le.Uint16(header[2:])) & 07777)
+
+	switch kind {
+	case 1:
+		fields, err := reader.read(16)
+		if err != nil { return nil, err }
+		inode.dirBlock  = int64(le.Uint32(fields[0:]))
+		found.size      = int64(le.Uint16(fields[8:]))
+		inode.dirOffset = int(le.Uint16(fields[10:]))
+	case 8:
+		fields, err := reader.read(24)
+		if err != nil { return nil, err }
+		found.size      = int64(le.Uint32(fields[4:]))
+		inode.dirBlock  = int64(le.Uint32(fields[8:]))
+		inode.dirOffset = int(le.Uint16(fields[18:]))
+	case 2, 9:
+		if kind == 2 {
+			fields, err := reader.read(16)
+			if err != nil { return nil, err }
+			inode.start    = int64(le.Uint32(fields[0:]))
+			inode.fragment = le.Uint32(fields[4:])
+			inode.offset   = le.Uint32(fields[8:])
+			found.size     = int64(le.Uint32(fields[12:]))
+		} else {
+			fields, err := reader.read(40)
+			if err != nil { return nil, err }
+			inode.start    = int64(le.Uint64(fields[0:]))
+			found.size     = int64(le.Uint64(fields[8:]))
+			inode.fragment = le.Uint32(fields[28:])
+			inode.offset   = le.Uint32(fields[32:])
+		}
+		if found.size < 0 { return nil, errCorrupt("squashfs", "invalid file size") }
+		count := found.size / this.blockSize
+		if inode.fragment == squashfsNoFragment && found.size % this.blockSize != 0 { count ++ }
+		sizes, err := reader.read(int(count) * 4)
+		if err != nil { return nil, err }
+		inode.blocks = make([]uint32, count)
+		for index := range inode.blocks {
+			inode.blocks[index] = le.Uint32(sizes[index * 4:])
+		}
+	case 3, 10:
+		fields, err := reader.read(8)
+		if err != nil { return nil, err }
+		target, err := reader.read(int(le.Uint32(fields[4:])))
+		if err != nil { return nil, err }
+		inode.target = string(target)
+		found.size   = int64(len(target))
+	}
+	return found, nil
+}
+
+func (this *squashfsTree) list (directory *node) ([]*node, error) {
+	inode := directory.data.(*squashfsInode)
+	// the size of directories counts three bytes more than their entries
+	remaining := int(directory.size) - 3
+	if remaining <= 0 { return nil, nil }
+	reader, err := this.metadataReader(this.directoryTable + inode.dirBlock, inode.dirOffset)
+	if err != nil { return nil, err }
+
+	le := binary.LittleEndian
+	var entries []*node
+	for remaining >= 12 {
+		header, err := reader.read(12)
+		if err != nil { return nil, err }
+		remaining -= 12
+		count := int(le.Uint32(header[0:])) + 1
+		start := uint64(le.Uint32(header[4:]))
+		if count > 256 { return nil, errCorrupt("squashfs", "invalid directory") }
+
+		for index := 0; index < count && remaining >= 8; index ++ {
+			fields, err := reader.read(8)
+			if err != nil { return nil, err }
+			name, err := reader.read(int(le.Uint16(fields[6:])) + 1)
+			if err != nil { return nil, err }
+			remaining -= 8 + len(name)
+
+			entry, err := this.node(string(name), start << 16 | uint64(le.Uint16(fields[0:])))
+			if err != nil { return nil, err }
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (this *squashfsTree) open (file *node) (io.ReaderAt, error) {
+	inode := file.data.(*squashfsInode)
+	reader := &squashfsFile {
+		tree:    this,
+		size:    file.size,
+		offsets: make([]int64, len(inode.blocks) + 1),
+		inode:   inode,
+		cached:  -1,
+	}
+	reader.offsets[0] = inode.start
+	for index, size := range inode.blocks {
+		reader.offsets[index + 1] = reader.offsets[index] + int64(size &^ squashfsUncompressed)
+	}
+	return reader, nil
+}
+
+func (this *squashfsTree) readLink (link *node) (string, error) {
+	return link.data.(*squashfsInode).target, nil
+}
+
+// readBlock reads a block of data, which may be compressed, and is as large as
+// a block once decompressed, or as a limit.
+func (this *squashfsTree) readBlock (position int64, size uint32, limit int64) ([]byte, error) {
+	length := int64(size &^ squashfsUncompressed)
+	if length == 0 {
+		// sparse blocks are not stored at all
+		return make([]byte, limit), nil
+	}
+	if length > this.blockSize * 2 { return nil, errCorrupt("squashfs", "invalid block size") }
+	data := make([]byte, length)
+	_, err := this.image.ReadAt(data, position)
+	if err != nil { return nil, err }
+	if size & squashfsUncompressed != 0 { return data, nil }
+	return this.decompress(data, this.blockSize)
+}
+
+// readFragment reads a fragment, which holds the ends of several files.
+func (this *squashfsTree) readFragment (index uint32) ([]byte, error) {
+	if index >= this.fragmentCount { return nil, errCorrupt("squashfs", "invalid fragment ", index) }
+	// the fragment table is a list of blocks of metadata, each holding
+	// 512 entries
+	pointer := make([]byte, 8)
+	_, err := this.image.ReadAt(pointer, this.fragmentTable + int64(index / 512) * 8)
+	if err != nil { return nil, err }
+	reader, err := this.metadataReader(int64(binary.LittleEndian.Uint64(pointer)), int(index % 512) * 16)
+	if err != nil { return nil, err }
+	entry, err := reader.read(16)
+	if err != nil { return nil, err }
+	le := binary.LittleEndian
+	return this.readBlock(int64(le.Uint64(entry[0:])), le.Uint32(entry[8:]), this.blockSize)
+}
+
+// squashfsFile reads a regular file, keeping the last block it read.
+type squashfsFile struct {
+	tree    *squashfsTree
+	size    int64
+	inode   *squashfsInode
+	// where each block starts in the image
+	offsets []int64
+
+	lock    sync.Mutex
+	cached  int
+	block   []byte
+}
+
+// read returns a block of the file, where the block after the last is its
+// fragment.
+func (this *squashfsFile) read (index int) ([]byte, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if index == this.cached { return this.block, nil }
+
+	var block []byte
+	var err   error
+	if index < len(this.inode.blocks) {
+		limit := min(this.tree.blockSize, this.size - int64(index) * this.tree.blockSize)
+		block, err = this.tree.readBlock(this.offsets[index], this.inode.blocks[index], limit)
+	} else {
+		var fragment []byte
+		fragment, err = this.tree.readFragment(this.inode.fragment)
+		if err == nil {
+			end := int64(this.inode.offset) + this.size % this.tree.blockSize
+			if end > int64(len(fragment)) { return nil, errCorrupt("squashfs", "invalid fragment offset") }
+			block = fragment[this.inode.offset:end]
+		}
+	}
+	if err != nil { return nil, err }
+	this.cached, this.block = index, block
+	return block, nil
+}
+
+func (this *squashfsFile) ReadAt (buffer []byte, offset int64) (int, error) {
+	if offset >= this.size { return 0, io.EOF }
+	wanted := buffer
+	if int64(len(wanted)) > this.size - offset { wanted = wanted[:this.size - offset] }
+
+	done := 0
+	for done < len(wanted) {
+		position := offset + int64(done)
+		index := int(position / this.tree.blockSize)
+		block, err := this.read(index)
+		if err != nil { return done, err }
+		within := position - int64(index) * this.tree.blockSize
+		if within >= int64(len(block)) {
+			// blocks which decompress short are padded with zeros
+			end := min(this.tree.blockSize, this.size - int64(index) * this.tree.blockSize)
+			part := wanted[done:]
+			part = part[:min(int64(len(part)), end - within)]
+			clear(part)
+			done += len(part)
+			continue
+		}
+		done += copy(wanted[done:], block[within:])
+	}
+	if len(wanted) < len(buffer) { return done, io.EOF }
+	return done, nil
+}
@@ -5,7 +5,11 @@ go 1.21
 require (
 	github.com/gabriel-vasile/mimetype v1.4.3
 	github.com/glebarez/go-sqlite v1.21.2
+	github.com/klauspost/compress v1.17.11
 	github.com/nlepage/go-tarfs v1.2.1
+	github.com/pierrec/lz4/v4 v4.1.21
+	github.com/ulikunitz/xz v0.5.15
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
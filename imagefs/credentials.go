@@ -0,0 +1,105 @@
+package imagefs
+
+import "os"
+import "fmt"
+import "bytes"
+import "errors"
+import "os/exec"
+import "strings"
+import "path/filepath"
+import "encoding/json"
+import "encoding/base64"
+
+// dockerConfig is the part of a docker configuration file which holds
+// credentials for registries.
+type dockerConfig struct {
+	Auths map[string] struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	// The default credential helper
+	CredsStore  string             `json:"credsStore"`
+	// Credential helpers for particular registries
+	CredHelpers map[string] string `json:"credHelpers"`
+}
+
+// dockerHubServer is the name docker stores credentials for Docker Hub under.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// DockerCredentials returns the credentials docker login stored for a
+// registry, from $DOCKER_CONFIG/config.json or ~/.docker/config.json. Both
+// plain credentials and credential helpers are supported. If there are none,
+// empty strings are returned without an error.
+func DockerCredentials (registry string) (user, password string, err error) {
+	directory := os.Getenv("DOCKER_CONFIG")
+	if directory == "" {
+		home, err := os.UserHomeDir()
+		if err != nil { return "", "", nil }
+		directory = filepath.Join(home, ".docker")
+	}
+	name := filepath.Join(directory, "config.json")
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) { return "", "", nil }
+	if err != nil { return "", "", err }
+	var config dockerConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil { return "", "", fmt.Errorf("%v: %w", name, err) }
+
+	server := registry
+	if registry == "docker.io" { server = dockerHubServer }
+	helper := config.CredHelpers[registry]
+	if helper == "" { helper = config.CredsStore }
+
+	for key, entry := range config.Auths {
+		if credentialHost(key) != credentialHost(server) { continue }
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil { return "", "", fmt.Errorf("%v: %v: %w", name, key, err) }
+			user, password, _ := strings.Cut(string(decoded), ":")
+			return user, password, nil
+		}
+		if entry.Username != "" {
+			return entry.Username, entry.Password, nil
+		}
+	}
+
+	if helper != "" { return credentialHelper(helper, server) }
+	return "", "", nil
+}
+
+// credentialHost returns the host of a server in a docker configuration file,
+// which may be a URL.
+func credentialHost (server string) string {
+	server = strings.TrimPrefix(server, "https://")
+	server = strings.TrimPrefix(server, "http://")
+	server, _, _ = strings.Cut(server, "/")
+	if server == "index.docker.io" || server == "registry-1.docker.io" {
+		server = "docker.io"
+	}
+	return server
+}
+
+// credentialHelper gets credentials for a server from a docker credential
+// helper program, such as docker-credential-pass.
+func credentialHelper (helper, server string) (string, string, error) {
+	command := exec.Command("docker-credential-" + helper, "get")
+	command.Stdin = strings.NewReader(server)
+	output, err := command.Output()
+	if err != nil {
+		// helpers fail when they have no credentials for a server, which
+		// means it is accessed anonymously
+		if bytes.Contains(output, []byte("credentials not found")) {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("docker-credential-%v: %w", helper, err)
+	}
+
+	var credentials struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	err = json.Unmarshal(output, &credentials)
+	if err != nil { return "", "", fmt.Errorf("docker-credential-%v: %w", helper, err) }
+	return credentials.Username, credentials.Secret, nil
+}
@@ -0,0 +1,145 @@
+package imagefs
+
+import "io"
+import "fmt"
+import "path"
+import "errors"
+import "archive/tar"
+
+// dockerManifest is an entry of the manifest.json file of a docker save
+// archive.
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// imageConfig is the part of an image configuration that describes its
+// platform and layers.
+type imageConfig struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	History []struct {
+		CreatedBy  string `json:"created_by"`
+		EmptyLayer bool   `json:"empty_layer"`
+	} `json:"history"`
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ReadDockerArchive reads an image from an archive written by docker save. If
+// the archive contains several images, the one with the given tag is read, or
+// the first one if the tag is empty. The archive must stay open until the
+// image is closed.
+func ReadDockerArchive (archive io.ReaderAt, size int64, tag string) (*Image, error) {
+	members, err := tarMembers(io.NewSectionReader(archive, 0, size))
+	if err != nil { return nil, err }
+	open := memberOpener(members)
+
+	var manifests []dockerManifest
+	err = readBlobJSON(open, "manifest.json", &manifests)
+	if err != nil { return nil, err }
+	if len(manifests) == 0 {
+		return nil, errors.New("manifest.json lists no images")
+	}
+
+	manifest := manifests[0]
+	if tag != "" {
+		found := false
+		for _, candidate := range manifests {
+			for _, candidateTag := range candidate.RepoTags {
+				if candidateTag == tag {
+					manifest, found = candidate, true
+				}
+			}
+		}
+		if !found {
+			return nil, errors.New(fmt.Sprint("no image tagged ", tag))
+		}
+	}
+
+	var config imageConfig
+	err = readBlobJSON(open, manifest.Config, &config)
+	if err != nil { return nil, err }
+
+	image := &Image { Tags: manifest.RepoTags }
+	for _, name := range manifest.Layers {
+		member, err := open(name)
+		if err == nil { err = image.addLayer(&config, member) }
+		if err != nil {
+			image.Close()
+			return nil, fmt.Errorf("layer %v: %w", name, err)
+		}
+	}
+	return image, nil
+}
+
+func (this *imageConfig) platform () Platform {
+	return Platform {
+		OS:           this.OS,
+		Architecture: this.Architecture,
+		Variant:      this.Variant,
+	}
+}
+
+// diffID returns the digest of the uncompressed layer at an index.
+func (this *imageConfig) diffID (index int) string {
+	if index >= len(this.RootFS.DiffIDs) { return "" }
+	return this.RootFS.DiffIDs[index]
+}
+
+// createdBy returns the command that created the layer at an index. History
+// entries of instructions which did not create a layer are skipped.
+func (this *imageConfig) createdBy (index int) string {
+	for _, entry := range this.History {
+		if entry.EmptyLayer { continue }
+		if index == 0 { return entry.CreatedBy }
+		index --
+	}
+	return ""
+}
+
+// tarMembers returns the contents of every regular file in a tar archive,
+// without reading them. Symbolic links to regular files, which docker save
+// uses for layers shared between images, are included as well.
+func tarMembers (archive *io.SectionReader) (map[string] *io.SectionReader, error) {
+	members := make(map[string] *io.SectionReader)
+	links   := make(map[string] string)
+	reader  := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF { break }
+		if err != nil    { return nil, err }
+		name := path.Clean(header.Name)
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			offset, err := archive.Seek(0, io.SeekCurrent)
+			if err != nil { return nil, err }
+			members[name] = io.NewSectionReader(archive, offset, header.Size)
+		case tar.TypeSymlink:
+			links[name] = path.Join(path.Dir(name), header.Linkname)
+		case tar.TypeLink:
+			links[name] = path.Clean(header.Linkname)
+		}
+	}
+
+	for name, target := range links {
+		if member, ok := members[target]; ok {
+			members[name] = member
+		}
+	}
+	return members, nil
+}
+
+// memberOpener returns a function which opens members of a tar archive found
+// by tarMembers.
+func memberOpener (members map[string] *io.SectionReader) blobOpener {
+	return func (name string) (*io.SectionReader, error) {
+		member, ok := members[path.Clean(name)]
+		if !ok { return nil, errors.New(fmt.Sprint("missing ", name)) }
+		return member, nil
+	}
+}
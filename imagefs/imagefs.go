@@ -0,0 +1,480 @@
+// Package imagefs reads container images, and merges their layers into a
+// single read-only filesystem.
+package imagefs
+
+import "io"
+import "os"
+import "fmt"
+import "path"
+import "sort"
+import "bytes"
+import "bufio"
+import "time"
+import "io/fs"
+import "errors"
+import "strings"
+import "archive/tar"
+import "compress/gzip"
+import "github.com/klauspost/compress/zstd"
+
+// Layer is a single layer of an image, which is a tar archive of changes to
+// the filesystem of the layers below it.
+type Layer struct {
+	// The digest of the uncompressed layer, such as sha256:...
+	Digest    string
+	// The command which created the layer, if it is known
+	CreatedBy string
+	// The uncompressed tar archive
+	Archive   *io.SectionReader
+}
+
+// Image is a container image which has been read from somewhere.
+type Image struct {
+	// The tags the image was stored with
+	Tags   []string
+	// The layers of the image, from the bottom up
+	Layers []Layer
+
+	// a directory holding decompressed copies of layers
+	temporary string
+	// files to close along with the image
+	files     []io.Closer
+}
+
+// Close removes all temporary files used by the image. Filesystems of the
+// image stop working once it is closed.
+func (this *Image) Close () error {
+	for _, file := range this.files {
+		file.Close()
+	}
+	this.files = nil
+	if this.temporary == "" { return nil }
+	err := os.RemoveAll(this.temporary)
+	this.temporary = ""
+	return err
+}
+
+// FS merges the layers of the image into a single filesystem.
+func (this *Image) FS () (*FS, error) {
+	return Merge(this.Layers)
+}
+
+// addLayer decompresses a layer archive and adds it on top of the image.
+func (this *Image) addLayer (config *imageConfig, archive *io.SectionReader) error {
+	index := len(this.Layers)
+	uncompressed, err := this.uncompressed(archive)
+	if err != nil { return err }
+	this.Layers = append(this.Layers, Layer {
+		Digest:    config.diffID(index),
+		CreatedBy: config.createdBy(index),
+		Archive:   uncompressed,
+	})
+	return nil
+}
+
+// uncompressed returns an uncompressed copy of a layer archive, which may be
+// compressed with gzip or zstd. Compressed archives are decompressed into a
+// temporary file.
+func (this *Image) uncompressed (archive *io.SectionReader) (*io.SectionReader, error) {
+	magic := make([]byte, 4)
+	_, err := archive.ReadAt(magic, 0)
+	if err != nil && err != io.EOF { return nil, err }
+	if !bytes.HasPrefix(magic, gzipMagic) && !bytes.HasPrefix(magic, zstdMagic) {
+		return archive, nil
+	}
+	return this.decompress(io.NewSectionReader(archive, 0, archive.Size()))
+}
+
+// decompress copies a stream of a layer archive, which may be compressed with
+// gzip or zstd, into an uncompressed temporary file.
+func (this *Image) decompress (reader io.Reader) (*io.SectionReader, error) {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF { return nil, err }
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		reader, err := gzip.NewReader(buffered)
+		if err != nil { return nil, err }
+		defer reader.Close()
+		return this.spool(reader)
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		reader, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil { return nil, err }
+		defer reader.Close()
+		return this.spool(reader)
+
+	default:
+		return this.spool(buffered)
+	}
+}
+
+var gzipMagic = []byte { 0x1f, 0x8b }
+var zstdMagic = []byte { 0x28, 0xb5, 0x2f, 0xfd }
+
+// spool copies a stream into a temporary file which is removed when the image
+// is closed.
+func (this *Image) spool (reader io.Reader) (*io.SectionReader, error) {
+	if this.temporary == "" {
+		directory, err := os.MkdirTemp("", "microscope_layers_*")
+		if err != nil { return nil, err }
+		this.temporary = directory
+	}
+	file, err := os.CreateTemp(this.temporary, "layer_*.tar")
+	if err != nil { return nil, err }
+	this.files = append(this.files, file)
+
+	size, err := io.Copy(file, reader)
+	if err != nil { return nil, err }
+	return io.NewSectionReader(file, 0, size), nil
+}
+
+// FS is a read-only filesystem made of the layers of an image. It is safe for
+// concurrent use.
+type FS struct {
+	root   *node
+	layers []Layer
+}
+
+// node is a file or directory in a merged filesystem.
+type node struct {
+	name     string
+	// the header of the file, or nil for directories which only exist
+	// because something was stored inside of them
+	header   *tar.Header
+	// the contents of regular files
+	data     *io.SectionReader
+	// the index of the layer the node came from
+	layer    int
+	children map[string] *node
+}
+
+// Merge applies layers on top of each other, from the first to the last, and
+// returns the resulting filesystem. Whiteout files (.wh.NAME) remove files of
+// lower layers, and opaque directory markers (.wh..wh..opq) hide the entire
+// contents a directory had in lower layers.
+func Merge (layers []Layer) (*FS, error) {
+	filesystem := &FS { root: newDirectory(".", -1), layers: layers }
+	for index, layer := range layers {
+		err := filesystem.apply(index, layer.Archive)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf (
+				"layer %v (%v): %v", index + 1, layer.Digest, err))
+		}
+	}
+	return filesystem, nil
+}
+
+func newDirectory (name string, layer int) *node {
+	return &node {
+		name:     name,
+		layer:    layer,
+		children: make(map[string] *node),
+	}
+}
+
+func (this *FS) apply (index int, layer *io.SectionReader) error {
+	archive := io.NewSectionReader(layer, 0, layer.Size())
+	reader  := tar.NewReader(archive)
+	// the nodes this layer wrote, or stored something inside of, and the
+	// opaque directories whose other contents are hidden once the whole
+	// layer has been read, wherever their markers were in it
+	written := map[*node] bool { }
+	var opaque []string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF { break }
+		if err != nil    { return err }
+		if header.Typeflag == tar.TypeXGlobalHeader { continue }
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if name == "." || !fs.ValidPath(name) { continue }
+		directory, base := path.Split(name)
+		directory = path.Clean(directory)
+
+		switch {
+		case base == ".wh..wh..opq":
+			this.makeDirectories(directory, index, written)
+			opaque = append(opaque, directory)
+			continue
+
+		case strings.HasPrefix(base, ".wh."):
+			parent := this.lookup(directory)
+			if parent != nil && parent.children != nil {
+				delete(parent.children, strings.TrimPrefix(base, ".wh."))
+			}
+			continue
+		}
+
+		parent := this.makeDirectories(directory, index, written)
+		created := &node {
+			name:   base,
+			header: header,
+			layer:  index,
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			existing := parent.children[base]
+			if existing != nil && existing.children != nil {
+				// the directory keeps its contents from lower layers
+				existing.header = header
+				existing.layer  = index
+				written[existing] = true
+				continue
+			}
+			created.children = make(map[string] *node)
+
+		case tar.TypeLink:
+			target := this.lookup(path.Clean(strings.TrimPrefix(header.Linkname, "/")))
+			if target == nil || target.header == nil || target.children != nil {
+				// a broken link is left out rather than failing to
+				// read the entire image
+				continue
+			}
+			linked := *target.header
+			linked.Name   = header.Name
+			created.header = &linked
+			created.data   = target.data
+
+		case tar.TypeReg, tar.TypeRegA:
+			offset, err := archive.Seek(0, io.SeekCurrent)
+			if err != nil { return err }
+			created.data = io.NewSectionReader(archive, offset, header.Size)
+		}
+		parent.children[base] = created
+		written[created] = true
+	}
+
+	for _, name := range opaque {
+		directory := this.lookup(name)
+		if directory != nil && directory.children != nil { hideLower(directory, written) }
+	}
+	return nil
+}
+
+// hideLower removes everything inside of a directory which the layer being
+// applied did not write, including what lower layers left inside of the
+// directories it did.
+func hideLower (directory *node, written map[*node] bool) {
+	for name, child := range directory.children {
+		switch {
+		case !written[child]:       delete(directory.children, name)
+		case child.children != nil: hideLower(child, written)
+		}
+	}
+}
+
+// makeDirectories returns the directory with the given name, creating it and
+// any of its parents if they do not exist. Anything else in the way of the
+// directory is replaced. The directories are marked as written by the layer.
+func (this *FS) makeDirectories (name string, layer int, written map[*node] bool) *node {
+	current := this.root
+	if name == "." { return current }
+	for _, part := range strings.Split(name, "/") {
+		child := current.children[part]
+		if child == nil || child.children == nil {
+			child = newDirectory(part, layer)
+			current.children[part] = child
+		}
+		written[child] = true
+		current = child
+	}
+	return current
+}
+
+// lookup returns the node with the given name without following symbolic
+// links, or nil if it does not exist.
+func (this *FS) lookup (name string) *node {
+	current := this.root
+	if name == "." { return current }
+	for _, part := range strings.Split(name, "/") {
+		if current.children == nil { return nil }
+		current = current.children[part]
+		if current == nil { return nil }
+	}
+	return current
+}
+
+// resolve returns the node with the given name, following symbolic links.
+// The last element of the name is only followed if follow is true.
+func (this *FS) resolve (operation, name string, follow bool) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError { Op: operation, Path: name, Err: fs.ErrInvalid }
+	}
+
+	links := 0
+	parts := strings.Split(name, "/")
+	if name == "." { parts = nil }
+	current := this.root
+	var stack []*node
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".": continue
+		case "..":
+			if len(stack) > 0 {
+				current = stack[len(stack) - 1]
+				stack   = stack[:len(stack) - 1]
+			}
+			continue
+		}
+
+		if current.children == nil {
+			return nil, &fs.PathError { Op: operation, Path: name, Err: fs.ErrNotExist }
+		}
+		child := current.children[part]
+		if child == nil {
+			return nil, &fs.PathError { Op: operation, Path: name, Err: fs.ErrNotExist }
+		}
+
+		isLink := child.header != nil && child.header.Typeflag == tar.TypeSymlink
+		if isLink && (len(parts) > 0 || follow) {
+			links ++
+			if links > 40 {
+				return nil, &fs.PathError { Op: operation, Path: name, Err: errors.New("too many links") }
+			}
+			target := child.header.Linkname
+			if strings.HasPrefix(target, "/") {
+				current = this.root
+				stack   = nil
+			}
+			parts = append(strings.Split(target, "/"), parts...)
+			continue
+		}
+
+		stack   = append(stack, current)
+		current = child
+	}
+	return current, nil
+}
+
+// Origin returns the layer a file came from, which is the highest layer that
+// created or changed it. Symbolic links are followed, except for the file
+// itself.
+func (this *FS) Origin (name string) (Layer, bool) {
+	found, err := this.resolve("origin", name, false)
+	if err != nil || found.layer < 0 { return Layer { }, false }
+	return this.layers[found.layer], true
+}
+
+func (this *FS) Open (name string) (fs.File, error) {
+	found, err := this.resolve("open", name, true)
+	if err != nil { return nil, err }
+	opened := &file { node: found }
+	if found.data != nil {
+		opened.reader = io.NewSectionReader(found.data, 0, found.data.Size())
+	}
+	return opened, nil
+}
+
+func (this *FS) Stat (name string) (fs.FileInfo, error) {
+	found, err := this.resolve("stat", name, true)
+	if err != nil { return nil, err }
+	return found.info(), nil
+}
+
+func (this *FS) ReadDir (name string) ([]fs.DirEntry, error) {
+	found, err := this.resolve("readdir", name, true)
+	if err != nil { return nil, err }
+	if found.children == nil {
+		return nil, &fs.PathError { Op: "readdir", Path: name, Err: errors.New("not a directory") }
+	}
+	return found.entries(), nil
+}
+
+func (this *node) entries () []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(this.children))
+	for _, child := range this.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info()))
+	}
+	sort.Slice(entries, func (left, right int) bool {
+		return entries[left].Name() < entries[right].Name()
+	})
+	return entries
+}
+
+func (this *node) info () fs.FileInfo {
+	return nodeInfo { this }
+}
+
+type nodeInfo struct {
+	*node
+}
+
+func (this nodeInfo) Name () string { return this.name }
+
+func (this nodeInfo) Size () int64 {
+	if this.data == nil { return 0 }
+	return this.data.Size()
+}
+
+func (this nodeInfo) Mode () fs.FileMode {
+	if this.header == nil { return fs.ModeDir | 0755 }
+	return this.header.FileInfo().Mode()
+}
+
+func (this nodeInfo) ModTime () time.Time {
+	if this.header == nil { return time.Time { } }
+	return this.header.ModTime
+}
+
+func (this nodeInfo) IsDir () bool { return this.children != nil }
+
+func (this nodeInfo) Sys () any {
+	if this.header == nil { return nil }
+	return this.header
+}
+
+// file is an open file or directory of a merged filesystem.
+type file struct {
+	node    *node
+	reader  *io.SectionReader
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (this *file) Stat () (fs.FileInfo, error) {
+	return this.node.info(), nil
+}
+
+func (this *file) Read (buffer []byte) (int, error) {
+	if this.node.children != nil {
+		return 0, &fs.PathError { Op: "read", Path: this.node.name, Err: errors.New("is a directory") }
+	}
+	if this.reader == nil { return 0, io.EOF }
+	return this.reader.Read(buffer)
+}
+
+func (this *file) ReadAt (buffer []byte, offset int64) (int, error) {
+	if this.reader == nil { return 0, io.EOF }
+	return this.reader.ReadAt(buffer, offset)
+}
+
+func (this *file) Seek (offset int64, whence int) (int64, error) {
+	if this.reader == nil { return 0, nil }
+	return this.reader.Seek(offset, whence)
+}
+
+func (this *file) ReadDir (count int) ([]fs.DirEntry, error) {
+	if this.node.children == nil {
+		return nil, &fs.PathError { Op: "readdir", Path: this.node.name, Err: errors.New("not a directory") }
+	}
+	if !this.listed {
+		this.entries = this.node.entries()
+		this.listed  = true
+	}
+	if count <= 0 {
+		entries := this.entries
+		this.entries = nil
+		return entries, nil
+	}
+	if len(this.entries) == 0 { return nil, io.EOF }
+	if count > len(this.entries) { count = len(this.entries) }
+	entries := this.entries[:count]
+	this.entries = this.entries[count:]
+	return entries, nil
+}
+
+func (this *file) Close () error { return nil }
@@ -0,0 +1,206 @@
+package imagefs
+
+import "io"
+import "bytes"
+import "io/fs"
+import "errors"
+import "strings"
+import "testing"
+import "reflect"
+import "archive/tar"
+
+// layerEntry is a file of a layer, which is a regular file unless it has
+// another type.
+type layerEntry struct {
+	name    string
+	kind    byte
+	content string
+	link    string
+}
+
+func testLayerArchive (test *testing.T, entries ...layerEntry) *io.SectionReader {
+	buffer := bytes.Buffer { }
+	writer := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &tar.Header {
+			Name:     entry.name,
+			Mode:     0644,
+			Typeflag: entry.kind,
+			Linkname: entry.link,
+		}
+		if entry.kind == 0 {
+			header.Typeflag = tar.TypeReg
+			header.Size     = int64(len(entry.content))
+		}
+		err := writer.WriteHeader(header)
+		if err != nil { test.Fatal(err) }
+		writer.Write([]byte(entry.content))
+	}
+	err := writer.Close()
+	if err != nil { test.Fatal(err) }
+	return io.NewSectionReader(bytes.NewReader(buffer.Bytes()), 0, int64(buffer.Len()))
+}
+
+func testLayers (test *testing.T) []Layer {
+	return []Layer { {
+		Digest:  "sha256:1",
+		Archive: testLayerArchive (
+			test,
+			layerEntry { name: "etc/", kind: tar.TypeDir },
+			layerEntry { name: "etc/passwd", content: "root" },
+			layerEntry { name: "etc/shadow", content: "secret" },
+			layerEntry { name: "opt/app/old", content: "1" },
+			layerEntry { name: "opt/app/lib/keep", content: "1" },
+			layerEntry { name: "bin/sh", content: "shell" },
+			layerEntry { name: "var/run", content: "file" }),
+	}, {
+		Digest:  "sha256:2",
+		Archive: testLayerArchive (
+			test,
+			layerEntry { name: "etc/.wh.shadow" },
+			layerEntry { name: "etc/passwd", content: "root,user" },
+			layerEntry { name: "opt/app/.wh..wh..opq" },
+			layerEntry { name: "opt/app/new", content: "2" },
+			layerEntry { name: "bin/bash", kind: tar.TypeLink, link: "bin/sh" },
+			layerEntry { name: "link", kind: tar.TypeSymlink, link: "/etc" },
+			// whiteouts of files which do not exist change nothing
+			layerEntry { name: "missing/.wh.file" },
+			layerEntry { name: "etc/.wh.missing" }),
+	}, {
+		Digest:  "sha256:3",
+		Archive: testLayerArchive (
+			test,
+			// a hard link keeps the content of a file which is removed
+			layerEntry { name: "bin/.wh.sh" },
+			layerEntry { name: "var/run/pid", content: "3" }),
+	} }
+}
+
+func TestMerge (test *testing.T) {
+	filesystem, err := Merge(testLayers(test))
+	if err != nil { test.Fatal(err) }
+
+	files := []struct {
+		name    string
+		// the content of the file, and the layer it came from, unless
+		// it does not exist
+		content string
+		origin  string
+	} {
+		{ "etc/passwd",       "root,user", "sha256:2" },
+		{ "etc/shadow",       "",          "" },
+		{ "etc/.wh.shadow",   "",          "" },
+		{ "opt/app/old",      "",          "" },
+		{ "opt/app/lib/keep", "",          "" },
+		{ "opt/app/new",      "2",         "sha256:2" },
+		{ "bin/sh",           "",          "" },
+		{ "bin/bash",         "shell",     "sha256:2" },
+		{ "link/passwd",      "root,user", "sha256:2" },
+		{ "var/run/pid",      "3",         "sha256:3" },
+	}
+	for _, file := range files {
+		content, err := fs.ReadFile(filesystem, file.name)
+		if file.content == "" {
+			if !errors.Is(err, fs.ErrNotExist) {
+				test.Errorf("%v: expected it to be removed, got %q, %v", file.name, content, err)
+			}
+			continue
+		}
+		if err != nil {
+			test.Errorf("%v: %v", file.name, err)
+			continue
+		}
+		if string(content) != file.content {
+			test.Errorf("%v: expected %q, got %q", file.name, file.content, content)
+		}
+		layer, found := filesystem.Origin(file.name)
+		if !found || layer.Digest != file.origin {
+			test.Errorf("%v: expected it to come from %v, got %v", file.name, file.origin, layer.Digest)
+		}
+	}
+
+	directories := map[string] []string {
+		".":       { "bin", "etc", "link", "opt", "var" },
+		"etc":     { "passwd" },
+		"opt/app": { "new" },
+		"var/run": { "pid" },
+	}
+	for name, expected := range directories {
+		entries, err := fs.ReadDir(filesystem, name)
+		if err != nil {
+			test.Errorf("%v: %v", name, err)
+			continue
+		}
+		var names []string
+		for _, entry := range entries { names = append(names, entry.Name()) }
+		if !reflect.DeepEqual(names, expected) {
+			test.Errorf("%v: expected %v, got %v", name, expected, names)
+		}
+	}
+}
+
+func TestMergeOrder (test *testing.T) {
+	// the opaque marker only hides what lower layers held, wherever it is
+	// among the files of its own layer
+	lower := Layer {
+		Digest:  "sha256:1",
+		Archive: testLayerArchive (
+			test,
+			layerEntry { name: "opt/app/old", content: "1" },
+			layerEntry { name: "opt/app/lib/keep", content: "1" },
+			layerEntry { name: "opt/app/sub/old", content: "1" }),
+	}
+	orders := map[string] []layerEntry {
+		"marker last": {
+			layerEntry { name: "opt/app/new", content: "2" },
+			layerEntry { name: "opt/app/lib/", kind: tar.TypeDir },
+			layerEntry { name: "opt/app/sub/new", content: "2" },
+			layerEntry { name: "opt/app/.wh..wh..opq" },
+		},
+		"marker first": {
+			layerEntry { name: "opt/app/.wh..wh..opq" },
+			layerEntry { name: "opt/app/new", content: "2" },
+			layerEntry { name: "opt/app/lib/", kind: tar.TypeDir },
+			layerEntry { name: "opt/app/sub/new", content: "2" },
+		},
+		"marker between": {
+			layerEntry { name: "opt/app/lib/", kind: tar.TypeDir },
+			layerEntry { name: "opt/app/.wh..wh..opq" },
+			layerEntry { name: "opt/app/sub/new", content: "2" },
+			layerEntry { name: "opt/app/new", content: "2" },
+		},
+	}
+	directories := map[string] []string {
+		"opt/app":     { "lib", "new", "sub" },
+		"opt/app/lib": nil,
+		"opt/app/sub": { "new" },
+	}
+	for order, entries := range orders {
+		layers := []Layer { lower, { Digest: "sha256:2", Archive: testLayerArchive(test, entries...) } }
+		filesystem, err := Merge(layers)
+		if err != nil { test.Fatal(err) }
+		for name, expected := range directories {
+			entries, err := fs.ReadDir(filesystem, name)
+			if err != nil {
+				test.Errorf("%v: %v: %v", order, name, err)
+				continue
+			}
+			var names []string
+			for _, entry := range entries { names = append(names, entry.Name()) }
+			if !reflect.DeepEqual(names, expected) {
+				test.Errorf("%v: %v: expected %v, got %v", order, name, expected, names)
+			}
+		}
+	}
+}
+
+func TestMergeCorrupt (test *testing.T) {
+	// the second layer is cut inside of the header of its second file, as
+	// a cut between files would look like the end of the archive
+	layers := testLayers(test)
+	layers[1].Archive = io.NewSectionReader(layers[1].Archive, 0, 512 + 100)
+	_, err := Merge(layers)
+	if err == nil || !strings.HasPrefix(err.Error(), "layer 2 (sha256:2): ") {
+		test.Errorf("expected an error in the second layer, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package imagefs
+
+import "io"
+import "fmt"
+import "path"
+import "io/fs"
+import "errors"
+import "regexp"
+import "strings"
+import "runtime"
+import "encoding/json"
+
+// Platform is the operating system and processor architecture an image was
+// built for.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	// The variant of the architecture, such as v7 for arm
+	Variant      string `json:"variant,omitempty"`
+}
+
+// DefaultPlatform returns linux on the architecture of the running program.
+func DefaultPlatform () Platform {
+	return Platform { OS: "linux", Architecture: runtime.GOARCH }
+}
+
+// ParsePlatform parses a platform in the form OS/ARCHITECTURE[/VARIANT], such as
+// linux/arm64 or linux/arm/v7.
+func ParsePlatform (text string) (Platform, error) {
+	parts := strings.Split(text, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform { }, errors.New(fmt.Sprint("invalid platform ", text))
+	}
+	platform := Platform { OS: parts[0], Architecture: parts[1] }
+	if len(parts) == 3 { platform.Variant = parts[2] }
+	return platform, nil
+}
+
+func (this Platform) String () string {
+	if this.Variant == "" { return this.OS + "/" + this.Architecture }
+	return this.OS + "/" + this.Architecture + "/" + this.Variant
+}
+
+func (this Platform) MarshalText () ([]byte, error) {
+	return []byte(this.String()), nil
+}
+
+func (this *Platform) UnmarshalText (text []byte) error {
+	platform, err := ParsePlatform(string(text))
+	if err != nil { return err }
+	*this = platform
+	return nil
+}
+
+// Matches returns whether an image built for another platform can be used on
+// this one. A platform without a variant matches any variant.
+func (this Platform) Matches (other Platform) bool {
+	return this.OS == other.OS &&
+		this.Architecture == other.Architecture &&
+		(this.Variant == "" || this.Variant == other.Variant)
+}
+
+// jsonPlatform is a platform as it is stored in image indexes, which is an
+// object rather than the text form of Platform.
+type jsonPlatform Platform
+
+// descriptor refers to a blob of an OCI image layout.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *jsonPlatform     `json:"platform"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ociIndex is an OCI image index, or a docker manifest list.
+type ociIndex struct {
+	Manifests []descriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI image manifest, or a docker image manifest.
+type ociManifest struct {
+	Config descriptor   `json:"config"`
+	Layers []descriptor `json:"layers"`
+}
+
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+const (
+	indexMediaType          = "application/vnd.oci.image.index.v1+json"
+	manifestMediaType       = "application/vnd.oci.image.manifest.v1+json"
+	manifestListMediaType   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// blobOpener returns the contents of a file of an OCI image layout.
+type blobOpener func (name string) (*io.SectionReader, error)
+
+// blobFetcher returns the contents of a blob with a digest. Manifests and
+// indexes are fetched with manifest set to true, since registries store them
+// apart from other blobs.
+type blobFetcher func (digest string, manifest bool) (*io.SectionReader, error)
+
+// layoutFetcher returns a function which fetches blobs from an OCI image
+// layout.
+func layoutFetcher (open blobOpener) blobFetcher {
+	return func (digest string, manifest bool) (*io.SectionReader, error) {
+		name, err := blobName(digest)
+		if err != nil { return nil, err }
+		return open(name)
+	}
+}
+
+// ReadOCILayout reads the image for a platform from an OCI image layout
+// directory. If the layout contains images for several platforms, the first
+// one matching the platform is read. Files of the layout must implement
+// io.ReaderAt, like those of os.DirFS.
+func ReadOCILayout (layout fs.FS, platform Platform) (*Image, error) {
+	image := &Image { }
+	open := func (name string) (*io.SectionReader, error) {
+		file, err := layout.Open(name)
+		if err != nil { return nil, err }
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		reader, ok := file.(io.ReaderAt)
+		if !ok {
+			file.Close()
+			return nil, errors.New(fmt.Sprint(name, ": cannot read at an offset"))
+		}
+		image.files = append(image.files, file)
+		return io.NewSectionReader(reader, 0, info.Size()), nil
+	}
+	err := image.readOCI(open, platform)
+	if err != nil {
+		image.Close()
+		return nil, err
+	}
+	return image, nil
+}
+
+// ReadOCIArchive reads the image for a platform from a tar archive of an OCI
+// image layout. The archive must stay open until the image is closed.
+func ReadOCIArchive (archive io.ReaderAt, size int64, platform Platform) (*Image, error) {
+	members, err := tarMembers(io.NewSectionReader(archive, 0, size))
+	if err != nil { return nil, err }
+
+	image := &Image { }
+	err = image.readOCI(memberOpener(members), platform)
+	if err != nil {
+		image.Close()
+		return nil, err
+	}
+	return image, nil
+}
+
+func (this *Image) readOCI (open blobOpener, platform Platform) error {
+	var index ociIndex
+	err := readBlobJSON(open, "index.json", &index)
+	if err != nil { return err }
+
+	fetch := layoutFetcher(open)
+	manifest, config, reference, err := selectImage(fetch, index.Manifests, platform)
+	if err != nil { return err }
+
+	if reference != "" { this.Tags = []string { reference } }
+	for _, layer := range manifest.Layers {
+		err := checkLayer(layer)
+		if err != nil { return err }
+		archive, err := fetch(layer.Digest, false)
+		if err != nil { return err }
+		err = this.addLayer(config, archive)
+		if err != nil { return fmt.Errorf("layer %v: %w", layer.Digest, err) }
+	}
+	return nil
+}
+
+// selectImage returns the first manifest for a platform, along with its
+// configuration and reference name, or an error listing the platforms there
+// are images for if none match.
+func selectImage (
+	fetch       blobFetcher,
+	descriptors []descriptor,
+	platform    Platform,
+) (
+	*ociManifest,
+	*imageConfig,
+	string,
+	error,
+) {
+	var found []Platform
+	manifest, config, reference, err := selectManifest(fetch, descriptors, platform, &found, 0)
+	if err != nil { return nil, nil, "", err }
+	if manifest == nil {
+		available := make([]string, len(found))
+		for index, other := range found {
+			available[index] = other.String()
+		}
+		return nil, nil, "", errors.New(fmt.Sprintf (
+			"no image for platform %v (found %v)",
+			platform, strings.Join(available, ", ")))
+	}
+	return manifest, config, reference, nil
+}
+
+// checkLayer returns an error if a layer is not a tar archive.
+func checkLayer (layer descriptor) error {
+	if strings.Contains(layer.MediaType, "tar") { return nil }
+	return errors.New(fmt.Sprintf (
+		"layer %v: unsupported media type %v",
+		layer.Digest, layer.MediaType))
+}
+
+// selectManifest returns the first manifest for a platform, along with its
+// configuration and reference name, by following a list of descriptors and any
+// indexes they refer to. Platforms of images which do not match are added to
+// found. If no manifest matches, nil is returned without an error.
+func selectManifest (
+	fetch       blobFetcher,
+	descriptors []descriptor,
+	platform    Platform,
+	found       *[]Platform,
+	depth       int,
+) (
+	*ociManifest,
+	*imageConfig,
+	string,
+	error,
+) {
+	if depth > 4 { return nil, nil, "", errors.New("image indexes are nested too deeply") }
+	for _, candidate := range descriptors {
+		if candidate.Platform != nil && !platform.Matches(Platform(*candidate.Platform)) {
+			// attestations are stored as images of an unknown
+			// platform, which are not worth mentioning
+			if candidate.Platform.OS != "unknown" {
+				*found = append(*found, Platform(*candidate.Platform))
+			}
+			continue
+		}
+		switch candidate.MediaType {
+		case indexMediaType, manifestListMediaType:
+			var index ociIndex
+			err := fetchJSON(fetch, candidate.Digest, true, &index)
+			if err != nil { return nil, nil, "", err }
+			manifest, config, reference, err := selectManifest (
+				fetch, index.Manifests, platform, found, depth + 1)
+			if manifest != nil || err != nil {
+				if reference == "" {
+					reference = candidate.Annotations[refNameAnnotation]
+				}
+				return manifest, config, reference, err
+			}
+
+		default:
+			var manifest ociManifest
+			err := fetchJSON(fetch, candidate.Digest, true, &manifest)
+			if err != nil { return nil, nil, "", err }
+			var config imageConfig
+			err = fetchJSON(fetch, manifest.Config.Digest, false, &config)
+			if err != nil { return nil, nil, "", err }
+
+			// images without a platform in their descriptor, which
+			// are common in single image layouts, declare it in
+			// their configuration instead
+			if config.OS != "" && !platform.Matches(config.platform()) {
+				*found = append(*found, config.platform())
+				continue
+			}
+			return &manifest, &config, candidate.Annotations[refNameAnnotation], nil
+		}
+	}
+	return nil, nil, "", nil
+}
+
+var digestPattern = regexp.MustCompile(`^([a-z0-9]+):([a-zA-Z0-9=_-]+)$`)
+
+// blobName returns the name of the file holding a blob in an OCI image layout.
+func blobName (digest string) (string, error) {
+	parts := digestPattern.FindStringSubmatch(digest)
+	if parts == nil { return "", errors.New(fmt.Sprint("invalid digest ", digest)) }
+	return path.Join("blobs", parts[1], parts[2]), nil
+}
+
+func fetchJSON (fetch blobFetcher, digest string, manifest bool, value any) error {
+	blob, err := fetch(digest, manifest)
+	if err != nil { return err }
+	err = json.NewDecoder(io.NewSectionReader(blob, 0, blob.Size())).Decode(value)
+	if err != nil { return fmt.Errorf("%v: %w", digest, err) }
+	return nil
+}
+
+func readBlobJSON (open blobOpener, name string, value any) error {
+	blob, err := open(name)
+	if err != nil { return err }
+	err = json.NewDecoder(io.NewSectionReader(blob, 0, blob.Size())).Decode(value)
+	if err != nil { return fmt.Errorf("%v: %w", name, err) }
+	return nil
+}
@@ -0,0 +1,385 @@
+package imagefs
+
+import "io"
+import "fmt"
+import "net"
+import "bytes"
+import "errors"
+import "regexp"
+import "context"
+import "strings"
+import "net/url"
+import "net/http"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+import "encoding/base64"
+
+// Reference names an image in a registry, such as nginx:1.25,
+// registry.example.com/team/app@sha256:... or localhost:5000/app.
+type Reference struct {
+	// The host and optional port of the registry, which is docker.io for
+	// Docker Hub
+	Registry   string
+	Repository string
+	// The tag of the image, which is latest if neither it nor the digest
+	// are given
+	Tag        string
+	Digest     string
+}
+
+var repositoryPattern = regexp.MustCompile(`^[a-z0-9]+([._/-]+[a-z0-9]+)*$`)
+var tagPattern        = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+
+// ParseReference parses an image reference the way docker does, so that an
+// image without a registry comes from Docker Hub.
+func ParseReference (text string) (Reference, error) {
+	reference := Reference { }
+	rest := text
+	if at := strings.Index(rest, "@"); at >= 0 {
+		reference.Digest = rest[at + 1:]
+		rest = rest[:at]
+		if !digestPattern.MatchString(reference.Digest) {
+			return Reference { }, errors.New(fmt.Sprint("invalid digest in ", text))
+		}
+	}
+	if colon := strings.LastIndex(rest, ":"); colon > strings.LastIndex(rest, "/") {
+		reference.Tag = rest[colon + 1:]
+		rest = rest[:colon]
+		if !tagPattern.MatchString(reference.Tag) {
+			return Reference { }, errors.New(fmt.Sprint("invalid tag in ", text))
+		}
+	}
+
+	first, remainder, found := strings.Cut(rest, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		reference.Registry = first
+		rest = remainder
+	} else {
+		reference.Registry = "docker.io"
+		if !found { rest = "library/" + rest }
+	}
+	reference.Repository = rest
+
+	if !repositoryPattern.MatchString(reference.Repository) {
+		return Reference { }, errors.New(fmt.Sprint("invalid image reference ", text))
+	}
+	if reference.Tag == "" && reference.Digest == "" { reference.Tag = "latest" }
+	return reference, nil
+}
+
+func (this Reference) String () string {
+	text := this.Registry + "/" + this.Repository
+	if this.Tag    != "" { text += ":" + this.Tag }
+	if this.Digest != "" { text += "@" + this.Digest }
+	return text
+}
+
+// Registry downloads images over the OCI distribution API. Registries on the
+// loopback interface, such as localhost:5000, are accessed over plain HTTP and
+// all others over HTTPS.
+type Registry struct {
+	// The client to make requests with, or nil to use http.DefaultClient
+	Client      *http.Client
+	// Returns the user name and password to log in to a registry with, or
+	// empty strings to access it anonymously. It may be nil.
+	Credentials func (registry string) (user, password string, err error)
+}
+
+// maxManifestSize is the largest manifest, index or configuration that is
+// downloaded.
+const maxManifestSize = 16 << 20
+
+// Pull downloads the image for a platform. Layers are decompressed into
+// temporary files as they are downloaded, and verified against their digests.
+func (this *Registry) Pull (ctx context.Context, reference Reference, platform Platform) (*Image, error) {
+	session := &registrySession {
+		registry:  this,
+		ctx:       ctx,
+		reference: reference,
+		manifests: make(map[string] []byte),
+	}
+	session.base = session.scheme() + "://" + reference.host() +
+		"/v2/" + reference.Repository
+
+	name := reference.Digest
+	if name == "" { name = reference.Tag }
+	data, mediaType, err := session.manifest(name)
+	if err != nil { return nil, err }
+	digest := reference.Digest
+	if digest == "" { digest = digestOf(data) }
+	if mediaType == "" {
+		var contents struct {
+			MediaType string          `json:"mediaType"`
+			Manifests json.RawMessage `json:"manifests"`
+		}
+		json.Unmarshal(data, &contents)
+		mediaType = contents.MediaType
+		if mediaType == "" && contents.Manifests != nil {
+			mediaType = indexMediaType
+		}
+	}
+	session.manifests[digest] = data
+
+	top := []descriptor { { MediaType: mediaType, Digest: digest } }
+	manifest, config, _, err := selectImage(session.fetch, top, platform)
+	if err != nil { return nil, err }
+
+	image := &Image { Tags: []string { reference.String() } }
+	for _, layer := range manifest.Layers {
+		err := checkLayer(layer)
+		if err == nil { err = session.layer(image, config, layer) }
+		if err != nil {
+			image.Close()
+			return nil, fmt.Errorf("layer %v: %w", layer.Digest, err)
+		}
+	}
+	return image, nil
+}
+
+// host returns the host of the registry to connect to.
+func (this Reference) host () string {
+	if this.Registry == "docker.io" { return "registry-1.docker.io" }
+	return this.Registry
+}
+
+// registrySession downloads a single image from a registry.
+type registrySession struct {
+	registry      *Registry
+	ctx           context.Context
+	reference     Reference
+	// the URL of the repository, ending in /v2/NAME
+	base          string
+	// the value of the Authorization header, once it is known
+	authorization string
+	// manifests which have already been downloaded, by digest
+	manifests     map[string] []byte
+}
+
+func (this *registrySession) scheme () string {
+	host := this.reference.Registry
+	if name, _, err := net.SplitHostPort(host); err == nil { host = name }
+	if host == "localhost" { return "http" }
+	if address := net.ParseIP(host); address != nil && address.IsLoopback() {
+		return "http"
+	}
+	return "https"
+}
+
+// manifest downloads a manifest or index by tag or digest, and returns it
+// along with its media type.
+func (this *registrySession) manifest (name string) ([]byte, string, error) {
+	response, err := this.get(this.base + "/manifests/" + name, strings.Join([]string {
+		indexMediaType, manifestMediaType,
+		manifestListMediaType, dockerManifestMediaType,
+	}, ", "))
+	if err != nil { return nil, "", err }
+	defer response.Body.Close()
+	data, err := readLimited(response.Body, maxManifestSize)
+	if err != nil { return nil, "", err }
+	if digestPattern.MatchString(name) {
+		err := verify(name, data)
+		if err != nil { return nil, "", err }
+	}
+	mediaType, _, _ := strings.Cut(response.Header.Get("Content-Type"), ";")
+	if mediaType == "application/json" || mediaType == "text/plain" { mediaType = "" }
+	return data, strings.TrimSpace(mediaType), nil
+}
+
+// fetch is a blobFetcher for manifests and configurations.
+func (this *registrySession) fetch (digest string, manifest bool) (*io.SectionReader, error) {
+	if !digestPattern.MatchString(digest) {
+		return nil, errors.New(fmt.Sprint("invalid digest ", digest))
+	}
+	data, ok := this.manifests[digest]
+	if !ok && manifest {
+		var err error
+		data, _, err = this.manifest(digest)
+		if err != nil { return nil, err }
+		this.manifests[digest] = data
+	}
+	if !ok && !manifest {
+		response, err := this.get(this.base + "/blobs/" + digest, "")
+		if err != nil { return nil, err }
+		defer response.Body.Close()
+		data, err = readLimited(response.Body, maxManifestSize)
+		if err != nil { return nil, err }
+		err = verify(digest, data)
+		if err != nil { return nil, err }
+	}
+	return io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))), nil
+}
+
+// layer downloads a layer and adds it on top of an image.
+func (this *registrySession) layer (image *Image, config *imageConfig, layer descriptor) error {
+	if !strings.HasPrefix(layer.Digest, "sha256:") {
+		return errors.New(fmt.Sprint("unsupported digest ", layer.Digest))
+	}
+	response, err := this.get(this.base + "/blobs/" + layer.Digest, "")
+	if err != nil { return err }
+	defer response.Body.Close()
+
+	hash := sha256.New()
+	index := len(image.Layers)
+	uncompressed, err := image.decompress(io.TeeReader(response.Body, hash))
+	if err != nil { return err }
+	// the decompressor may stop before the end of the stream
+	_, err = io.Copy(hash, response.Body)
+	if err != nil { return err }
+	if "sha256:" + hex.EncodeToString(hash.Sum(nil)) != layer.Digest {
+		return errors.New("digest does not match the contents")
+	}
+
+	image.Layers = append(image.Layers, Layer {
+		Digest:    config.diffID(index),
+		CreatedBy: config.createdBy(index),
+		Archive:   uncompressed,
+	})
+	return nil
+}
+
+// get makes a request to the registry, logging in if the registry asks for it.
+func (this *registrySession) get (address, accept string) (*http.Response, error) {
+	response, err := this.request(address, accept)
+	if err != nil { return nil, err }
+	if response.StatusCode == http.StatusUnauthorized && this.authorization == "" {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		err := this.authorize(challenge)
+		if err != nil { return nil, err }
+		response, err = this.request(address, accept)
+		if err != nil { return nil, err }
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, errors.New(fmt.Sprintf (
+			"GET %v: %v", address, response.Status))
+	}
+	return response, nil
+}
+
+func (this *registrySession) request (address, accept string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(this.ctx, http.MethodGet, address, nil)
+	if err != nil { return nil, err }
+	if accept != "" { request.Header.Set("Accept", accept) }
+	if this.authorization != "" {
+		request.Header.Set("Authorization", this.authorization)
+	}
+	return this.client().Do(request)
+}
+
+func (this *registrySession) client () *http.Client {
+	if this.registry.Client != nil { return this.registry.Client }
+	return http.DefaultClient
+}
+
+// authorize logs in to the registry in response to a WWW-Authenticate header,
+// using either basic authentication or a bearer token.
+func (this *registrySession) authorize (challenge string) error {
+	user, password := "", ""
+	if this.registry.Credentials != nil {
+		var err error
+		user, password, err = this.registry.Credentials(this.reference.Registry)
+		if err != nil { return err }
+	}
+
+	scheme, parameters := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if user == "" {
+			return errors.New(fmt.Sprint(this.reference.Registry, ": no credentials"))
+		}
+		this.authorization = "Basic " + basicCredentials(user, password)
+		return nil
+
+	case "bearer":
+		return this.bearer(parameters, user, password)
+
+	default:
+		return errors.New(fmt.Sprintf (
+			"%v: unsupported authentication %v",
+			this.reference.Registry, challenge))
+	}
+}
+
+// bearer requests a token from the authorization server named in a bearer
+// challenge.
+func (this *registrySession) bearer (parameters map[string] string, user, password string) error {
+	realm, err := url.Parse(parameters["realm"])
+	if err != nil || realm.Host == "" {
+		return errors.New(fmt.Sprint(this.reference.Registry, ": invalid token realm"))
+	}
+	query := realm.Query()
+	if service := parameters["service"]; service != "" { query.Set("service", service) }
+	scope := parameters["scope"]
+	if scope == "" { scope = "repository:" + this.reference.Repository + ":pull" }
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	request, err := http.NewRequestWithContext(this.ctx, http.MethodGet, realm.String(), nil)
+	if err != nil { return err }
+	if user != "" { request.SetBasicAuth(user, password) }
+	response, err := this.client().Do(request)
+	if err != nil { return err }
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf (
+			"%v: requesting a token: %v",
+			this.reference.Registry, response.Status))
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(io.LimitReader(response.Body, maxManifestSize)).Decode(&token)
+	if err != nil { return fmt.Errorf("%v: requesting a token: %w", this.reference.Registry, err) }
+	if token.Token == "" { token.Token = token.AccessToken }
+	if token.Token == "" {
+		return errors.New(fmt.Sprint(this.reference.Registry, ": no token was given"))
+	}
+	this.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry". The scheme
+// is returned in lower case.
+func parseChallenge (challenge string) (string, map[string] string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	parameters := make(map[string] string)
+	for _, match := range challengePattern.FindAllStringSubmatch(rest, -1) {
+		value := match[2]
+		if value == "" { value = match[3] }
+		parameters[strings.ToLower(match[1])] = value
+	}
+	return strings.ToLower(scheme), parameters
+}
+
+var challengePattern = regexp.MustCompile(`([a-zA-Z0-9_-]+)=(?:"([^"]*)"|([^,\s]*))`)
+
+func basicCredentials (user, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+}
+
+func digestOf (data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// verify returns an error if data does not match a sha256 digest. Other kinds
+// of digests are not checked.
+func verify (digest string, data []byte) error {
+	if !strings.HasPrefix(digest, "sha256:") { return nil }
+	if digestOf(data) != digest {
+		return errors.New(fmt.Sprint(digest, ": digest does not match the contents"))
+	}
+	return nil
+}
+
+func readLimited (reader io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, limit + 1))
+	if err != nil { return nil, err }
+	if int64(len(data)) > limit { return nil, errors.New("response is too large") }
+	return data, nil
+}
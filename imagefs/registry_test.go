@@ -0,0 +1,359 @@
+package imagefs
+
+import "fmt"
+import "bytes"
+import "io/fs"
+import "strings"
+import "context"
+import "testing"
+import "net/http"
+import "archive/tar"
+import "compress/gzip"
+import "encoding/json"
+import "net/http/httptest"
+
+// testRegistry is a registry serving a single repository, named app, which
+// holds an index of images for linux/amd64 and linux/arm64 under the tag 1.0.
+type testRegistry struct {
+	server *httptest.Server
+	// how clients must log in: "", "basic" or "bearer"
+	auth   string
+	// blobs and manifests by digest, and the media types of manifests
+	blobs     map[string] []byte
+	manifests map[string] []byte
+	types     map[string] string
+	tags      map[string] string
+	// the digest of the layer of each platform
+	layers    map[string] string
+	// the scope asked for with the last token
+	scope     string
+}
+
+const testUser, testPassword, testToken = "user", "secret", "token-1234"
+
+func newTestRegistry (test *testing.T, auth string) *testRegistry {
+	this := &testRegistry {
+		auth:      auth,
+		blobs:     map[string] []byte { },
+		manifests: map[string] []byte { },
+		types:     map[string] string { },
+		tags:      map[string] string { },
+		layers:    map[string] string { },
+	}
+	var platforms []descriptor
+	for _, architecture := range []string { "amd64", "arm64" } {
+		layer := testLayer(test, map[string] string { "etc/arch": architecture })
+		uncompressed := testTar(test, map[string] string { "etc/arch": architecture })
+		config := this.addBlob(testJSON(test, map[string] any {
+			"os":           "linux",
+			"architecture": architecture,
+			"rootfs":       map[string] any { "diff_ids": []string { digestOf(uncompressed) } },
+			"history":      []any { map[string] any { "created_by": "COPY arch" } },
+		}))
+		this.layers[architecture] = this.addBlob(layer)
+		manifest := this.addManifest(manifestMediaType, testJSON(test, map[string] any {
+			"schemaVersion": 2,
+			"mediaType":     manifestMediaType,
+			"config": descriptor {
+				MediaType: "application/vnd.oci.image.config.v1+json",
+				Digest:    config,
+			},
+			"layers": []descriptor { {
+				MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+				Digest:    this.layers[architecture],
+			} },
+		}))
+		platforms = append(platforms, descriptor {
+			MediaType: manifestMediaType,
+			Digest:    manifest,
+			Platform:  &jsonPlatform { OS: "linux", Architecture: architecture },
+		})
+	}
+	this.tags["1.0"] = this.addManifest(indexMediaType, testJSON(test, map[string] any {
+		"schemaVersion": 2,
+		"mediaType":     indexMediaType,
+		"manifests":     platforms,
+	}))
+	this.server = httptest.NewServer(http.HandlerFunc(this.serve))
+	test.Cleanup(this.server.Close)
+	return this
+}
+
+func (this *testRegistry) addBlob (data []byte) string {
+	digest := digestOf(data)
+	this.blobs[digest] = data
+	return digest
+}
+
+func (this *testRegistry) addManifest (mediaType string, data []byte) string {
+	digest := digestOf(data)
+	this.manifests[digest] = data
+	this.types[digest] = mediaType
+	return digest
+}
+
+// reference returns a reference to the image of the registry with a tag or
+// digest.
+func (this *testRegistry) reference (test *testing.T, name string) Reference {
+	separator := ":"
+	if strings.Contains(name, ":") { separator = "@" }
+	reference, err := ParseReference(strings.TrimPrefix(this.server.URL, "http://") + "/app" + separator + name)
+	if err != nil { test.Fatal(err) }
+	return reference
+}
+
+func (this *testRegistry) serve (writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == "/token" {
+		user, password, ok := request.BasicAuth()
+		if !ok || user != testUser || password != testPassword {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		this.scope = request.URL.Query().Get("scope")
+		fmt.Fprintf(writer, `{"token":%q}`, testToken)
+		return
+	}
+
+	authorization := request.Header.Get("Authorization")
+	switch this.auth {
+	case "basic":
+		user, password, ok := request.BasicAuth()
+		if !ok || user != testUser || password != testPassword {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case "bearer":
+		if authorization != "Bearer " + testToken {
+			writer.Header().Set ("WWW-Authenticate", fmt.Sprintf (
+				`Bearer realm="%v/token",service="registry.test"`,
+				this.server.URL))
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	name, found := strings.CutPrefix(request.URL.Path, "/v2/app/manifests/")
+	if found {
+		if digest, ok := this.tags[name]; ok { name = digest }
+		data, ok := this.manifests[name]
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Header().Set("Content-Type", this.types[name])
+		writer.Write(data)
+		return
+	}
+	name, found = strings.CutPrefix(request.URL.Path, "/v2/app/blobs/")
+	if data, ok := this.blobs[name]; found && ok {
+		writer.Write(data)
+		return
+	}
+	http.NotFound(writer, request)
+}
+
+func testTar (test *testing.T, files map[string] string) []byte {
+	buffer := bytes.Buffer { }
+	writer := tar.NewWriter(&buffer)
+	for name, content := range files {
+		err := writer.WriteHeader(&tar.Header {
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil { test.Fatal(err) }
+		writer.Write([]byte(content))
+	}
+	err := writer.Close()
+	if err != nil { test.Fatal(err) }
+	return buffer.Bytes()
+}
+
+func testLayer (test *testing.T, files map[string] string) []byte {
+	buffer := bytes.Buffer { }
+	writer := gzip.NewWriter(&buffer)
+	writer.Write(testTar(test, files))
+	err := writer.Close()
+	if err != nil { test.Fatal(err) }
+	return buffer.Bytes()
+}
+
+func testJSON (test *testing.T, value any) []byte {
+	data, err := json.Marshal(value)
+	if err != nil { test.Fatal(err) }
+	return data
+}
+
+func testCredentials (registry string) (string, string, error) {
+	return testUser, testPassword, nil
+}
+
+// pullFile pulls an image and returns the content of a file in it.
+func pullFile (registry *Registry, reference Reference, platform Platform, name string) (string, *Image, error) {
+	image, err := registry.Pull(context.Background(), reference, platform)
+	if err != nil { return "", nil, err }
+	filesystem, err := image.FS()
+	if err != nil {
+		image.Close()
+		return "", nil, err
+	}
+	content, err := fs.ReadFile(filesystem, name)
+	if err != nil {
+		image.Close()
+		return "", nil, err
+	}
+	return string(content), image, nil
+}
+
+func TestPullSelectsPlatform (test *testing.T) {
+	for _, auth := range []string { "", "basic", "bearer" } {
+		for _, architecture := range []string { "amd64", "arm64" } {
+			registry := newTestRegistry(test, auth)
+			client := &Registry { Client: registry.server.Client(), Credentials: testCredentials }
+			platform := Platform { OS: "linux", Architecture: architecture }
+			content, image, err := pullFile(client, registry.reference(test, "1.0"), platform, "etc/arch")
+			if err != nil {
+				test.Errorf("%v %v: %v", auth, architecture, err)
+				continue
+			}
+			if content != architecture {
+				test.Errorf("%v %v: pulled the image for %v", auth, architecture, content)
+			}
+			if len(image.Layers) != 1 || image.Layers[0].CreatedBy != "COPY arch" {
+				test.Errorf("%v %v: wrong layers %+v", auth, architecture, image.Layers)
+			}
+			image.Close()
+			if auth == "bearer" && registry.scope != "repository:app:pull" {
+				test.Errorf("token requested for scope %q", registry.scope)
+			}
+		}
+	}
+}
+
+func TestPullByDigest (test *testing.T) {
+	registry := newTestRegistry(test, "")
+	client := &Registry { Client: registry.server.Client() }
+	reference := registry.reference(test, registry.tags["1.0"])
+	content, image, err := pullFile(client, reference, Platform { OS: "linux", Architecture: "arm64" }, "etc/arch")
+	if err != nil { test.Fatal(err) }
+	image.Close()
+	if content != "arm64" { test.Errorf("pulled the image for %v", content) }
+}
+
+func TestPullFailures (test *testing.T) {
+	arm64 := Platform { OS: "linux", Architecture: "arm64" }
+	cases := []struct {
+		name     string
+		auth     string
+		// changes the registry before pulling
+		tamper   func (*testRegistry)
+		platform Platform
+		noLogin  bool
+		// part of the error expected
+		expected string
+	} {
+		{
+			name:     "missing platform",
+			platform: Platform { OS: "linux", Architecture: "s390x" },
+			expected: "no image for platform linux/s390x (found linux/amd64, linux/arm64)",
+		}, {
+			name:     "tampered layer",
+			platform: arm64,
+			tamper:   func (registry *testRegistry) {
+				digest := registry.layers["arm64"]
+				registry.blobs[digest] = testLayer(test, map[string] string { "etc/arch": "evil" })
+			},
+			expected: "digest does not match the contents",
+		}, {
+			name:     "tampered manifest",
+			platform: arm64,
+			tamper:   func (registry *testRegistry) {
+				for digest, mediaType := range registry.types {
+					if mediaType == manifestMediaType {
+						registry.manifests[digest] = append(registry.manifests[digest], ' ')
+					}
+				}
+			},
+			expected: "digest does not match the contents",
+		}, {
+			name:     "tampered configuration",
+			platform: arm64,
+			tamper:   func (registry *testRegistry) {
+				for digest, data := range registry.blobs {
+					if bytes.HasPrefix(data, []byte("{")) {
+						registry.blobs[digest] = append(data, ' ')
+					}
+				}
+			},
+			expected: "digest does not match the contents",
+		}, {
+			name:     "basic without credentials",
+			auth:     "basic",
+			platform: arm64,
+			noLogin:  true,
+			expected: "no credentials",
+		}, {
+			name:     "bearer without credentials",
+			auth:     "bearer",
+			platform: arm64,
+			noLogin:  true,
+			expected: "requesting a token: 401",
+		},
+	}
+	for _, current := range cases {
+		registry := newTestRegistry(test, current.auth)
+		if current.tamper != nil { current.tamper(registry) }
+		client := &Registry { Client: registry.server.Client(), Credentials: testCredentials }
+		if current.noLogin { client.Credentials = nil }
+		_, image, err := pullFile(client, registry.reference(test, "1.0"), current.platform, "etc/arch")
+		if err == nil {
+			image.Close()
+			test.Errorf("%v: pulled without an error", current.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), current.expected) {
+			test.Errorf("%v: expected %q, got %v", current.name, current.expected, err)
+		}
+	}
+}
+
+func TestPullLargeManifest (test *testing.T) {
+	registry := newTestRegistry(test, "")
+	registry.manifests[registry.tags["1.0"]] = bytes.Repeat([]byte(" "), maxManifestSize + 1)
+	client := &Registry { Client: registry.server.Client() }
+	_, err := client.Pull(context.Background(), registry.reference(test, "1.0"), DefaultPlatform())
+	if err == nil || !strings.Contains(err.Error(), "too large") {
+		test.Errorf("expected a manifest that is too large, got %v", err)
+	}
+}
+
+func TestParseReference (test *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	} {
+		{ "nginx",                           "docker.io/library/nginx:latest" },
+		{ "nginx:1.25",                      "docker.io/library/nginx:1.25" },
+		{ "team/app",                        "docker.io/team/app:latest" },
+		{ "localhost:5000/app",              "localhost:5000/app:latest" },
+		{ "registry.example.com/team/app:2", "registry.example.com/team/app:2" },
+		{ "app@sha256:abcd",                 "docker.io/library/app@sha256:abcd" },
+	}
+	for _, current := range cases {
+		reference, err := ParseReference(current.text)
+		if err != nil {
+			test.Errorf("%v: %v", current.text, err)
+			continue
+		}
+		if reference.String() != current.expected {
+			test.Errorf("%v: expected %v, got %v", current.text, current.expected, reference)
+		}
+	}
+	for _, text := range []string { "UPPER/case", "app:bad tag", "app@nodigest", "" } {
+		_, err := ParseReference(text)
+		if err == nil { test.Errorf("%v: parsed an invalid reference", text) }
+	}
+}
+
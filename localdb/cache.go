@@ -0,0 +1,207 @@
+package localdb
+
+import "os"
+import "sync"
+import "io/fs"
+import "errors"
+import "database/sql"
+import "path/filepath"
+import _ "github.com/glebarez/go-sqlite"
+import "github.com/ajblkf/microscope/binscan"
+
+// HashCache remembers the sha256 sums of files on the local system, so that
+// files which have not changed since they were last hashed do not need to be
+// hashed again. Files are identified by their absolute path, and are
+// considered unchanged if their size, modification time, inode and device are
+// the same. It is safe for concurrent use.
+type HashCache struct {
+	// If true, cached sums are ignored, but new sums are still stored
+	Rehash bool
+
+	db      *sql.DB
+	mutex   sync.Mutex
+	pending map[string] cacheEntry
+	// the first error met while writing sums before the cache is closed
+	err     error
+}
+
+// cacheBatch is how many sums are stored before they are written to disk, so
+// that they are not lost if the scan is interrupted.
+const cacheBatch = 1000
+
+// fileIdentity is what is used to tell whether a file has changed.
+type fileIdentity struct {
+	size   int64
+	mtime  int64
+	inode  uint64
+	device uint64
+}
+
+type cacheEntry struct {
+	fileIdentity
+	hash string
+}
+
+// OpenHashCache opens a cache stored in an SQLite database, creating it if it
+// does not exist.
+func OpenHashCache (name string) (*HashCache, error) {
+	db, err := sql.Open("sqlite", name)
+	if err != nil { return nil, err }
+	// sums are written while files are being looked up, which SQLite
+	// would refuse from separate connections
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS hashes (
+		path   TEXT PRIMARY KEY,
+		size   INTEGER NOT NULL,
+		mtime  INTEGER NOT NULL,
+		inode  INTEGER NOT NULL,
+		device INTEGER NOT NULL,
+		hash   TEXT NOT NULL)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &HashCache {
+		db:      db,
+		pending: make(map[string] cacheEntry),
+	}, nil
+}
+
+// Lookup returns the cached sum of a file, if the file has not changed since
+// it was stored.
+func (this *HashCache) Lookup (path string, info fs.FileInfo) (string, bool) {
+	if this.Rehash { return "", false }
+	identity := identify(info)
+
+	this.mutex.Lock()
+	entry, found := this.pending[path]
+	this.mutex.Unlock()
+	if !found {
+		var inode, device int64
+		row := this.db.QueryRow (
+			"SELECT size, mtime, inode, device, hash FROM hashes WHERE path = ?",
+			path)
+		err := row.Scan (
+			&entry.size, &entry.mtime,
+			&inode, &device, &entry.hash)
+		if err != nil { return "", false }
+		entry.inode  = uint64(inode)
+		entry.device = uint64(device)
+	}
+
+	if entry.fileIdentity != identity { return "", false }
+	return entry.hash, true
+}
+
+// Store adds the sum of a file to the cache. Sums are written to disk in
+// batches, and once the cache is closed.
+func (this *HashCache) Store (path string, info fs.FileInfo, hash string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.pending[path] = cacheEntry {
+		fileIdentity: identify(info),
+		hash:         hash,
+	}
+	if len(this.pending) >= cacheBatch && this.err == nil {
+		this.err = this.flush()
+	}
+}
+
+// Prune removes the sums of files which no longer exist, and returns how many
+// were removed.
+func (this *HashCache) Prune () (int, error) {
+	rows, err := this.db.Query("SELECT path FROM hashes")
+	if err != nil { return 0, err }
+	var missing []string
+	for rows.Next() {
+		var path string
+		err = rows.Scan(&path)
+		if err != nil { break }
+		_, err = os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) { missing = append(missing, path) }
+		err = nil
+	}
+	rows.Close()
+	if err == nil { err = rows.Err() }
+	if err != nil { return 0, err }
+
+	transaction, err := this.db.Begin()
+	if err != nil { return 0, err }
+	for _, path := range missing {
+		_, err = transaction.Exec("DELETE FROM hashes WHERE path = ?", path)
+		if err != nil {
+			transaction.Rollback()
+			return 0, err
+		}
+	}
+	return len(missing), transaction.Commit()
+}
+
+// Close writes every stored sum to disk, and closes the cache.
+func (this *HashCache) Close () error {
+	this.mutex.Lock()
+	err := this.err
+	if err == nil { err = this.flush() }
+	this.mutex.Unlock()
+	if err != nil {
+		this.db.Close()
+		return err
+	}
+	return this.db.Close()
+}
+
+// flush writes the stored sums to disk. The mutex must be held.
+func (this *HashCache) flush () error {
+	if len(this.pending) == 0 { return nil }
+
+	transaction, err := this.db.Begin()
+	if err != nil { return err }
+	statement, err := transaction.Prepare (
+		"INSERT OR REPLACE INTO hashes (path, size, mtime, inode, device, hash) " +
+		"VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	defer statement.Close()
+
+	for path, entry := range this.pending {
+		_, err := statement.Exec (
+			path, entry.size, entry.mtime,
+			int64(entry.inode), int64(entry.device), entry.hash)
+		if err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	err = transaction.Commit()
+	if err != nil { return err }
+	this.pending = make(map[string] cacheEntry)
+	return nil
+}
+
+// CachedDatabase checks files on the local system against a database, using a
+// HashCache to avoid hashing files which have not changed. Root is the
+// directory on the local system which the filesystems given to CheckFile
+// refer to.
+type CachedDatabase struct {
+	*Database
+	Cache *HashCache
+	Root  string
+}
+
+func (this CachedDatabase) CheckFile (filesystem fs.FS, path string) (*binscan.Vulnerability, error) {
+	if this.Files == nil { return nil, nil }
+
+	info, err := fs.Stat(filesystem, path)
+	if err != nil { return nil, err }
+	absolute := filepath.Join(this.Root, filepath.FromSlash(path))
+
+	hash, cached := this.Cache.Lookup(absolute, info)
+	if !cached {
+		hash, err = HashFile(filesystem, path)
+		if err != nil { return nil, err }
+		this.Cache.Store(absolute, info, hash)
+	}
+	return this.CheckHash(path, hash), nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package localdb
+
+import "io/fs"
+
+// identify cannot find the inode and device of a file on this system, so only
+// its size and modification time are used.
+func identify (info fs.FileInfo) fileIdentity {
+	return fileIdentity {
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+	}
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package localdb
+
+import "io/fs"
+import "syscall"
+
+func identify (info fs.FileInfo) fileIdentity {
+	identity := fileIdentity {
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		identity.inode  = uint64(stat.Ino)
+		identity.device = uint64(stat.Dev)
+	}
+	return identity
+}
@@ -2,107 +2,214 @@ package localdb
 
 import "io"
 import "fmt"
-import "hash"
 import "io/fs"
 import "errors"
+import "strconv"
+import "strings"
 import "encoding/hex"
 import "encoding/csv"
 import "crypto/sha256"
 import "github.com/ajblkf/microscope/binscan"
 import "github.com/ajblkf/microscope/pkgscan"
+import "github.com/ajblkf/microscope/severity"
 
 type packageEntry struct {
 	pkgscan.Package
-	reason string
+	Record
 }
 
 func (entry packageEntry) matches (pkg pkgscan.Package) bool {
+	// npm scopes are part of the name of a package, so an entry without
+	// one only matches unscoped packages, while other namespaces, such as
+	// the distribution of a deb package, match any when they are left out
+	scoped := pkg.Type == "" || pkg.Type == "npm"
 	return (entry.Name == pkg.Name) &&
+		(entry.Type       == "" || entry.Type       == pkg.Type) &&
+		(entry.Namespace  == pkg.Namespace || !scoped && entry.Namespace == "") &&
+		(entry.Epoch      == "" || entry.Epoch      == pkg.Epoch) &&
+		(entry.Arch       == "" || entry.Arch       == pkg.Arch) &&
 		(entry.Version    == "" || entry.Version    == pkg.Version) &&
 		(entry.Release    == "" || entry.Release    == pkg.Release) &&
 		(entry.Repository == "" || entry.Repository == pkg.Repository)
 }
 
+// Database is a set of deny lists. Once every list has been read, it is safe
+// to check files and packages from several goroutines at once.
 type Database struct {
-	hash hash.Hash
-	Files    map[string] string
+	Files    map[string] Record
 	Packages map[string] []packageEntry
 }
 
 func (this *Database) ReadFileDb (input io.Reader) error {
-	if this.Files == nil { this.Files = make(map[string] string) }
-	return readMap(input, this.Files)
+	if this.Files == nil { this.Files = make(map[string] Record) }
+	records, err := ReadRecords(input)
+	if err != nil { return err }
+	for _, record := range records {
+		this.Files[record.Identifier] = record
+	}
+	return nil
 }
 
 func (this *Database) ReadPackageDb (input io.Reader) error {
 	if this.Packages == nil { this.Packages = make(map[string] []packageEntry) }
-	
-	reader := csv.NewReader(input)
-	line := 0
-	for {
-		line ++
-		row, err := reader.Read()
-		if err == io.EOF { break }
-		if err != nil { return err }
-		if len(row) != 2 {
+	records, err := ReadRecords(input)
+	if err != nil { return err }
+	for _, record := range records {
+		pkg, err := pkgscan.ParseIdentifier(record.Identifier)
+		if err != nil {
 			return errors.New(fmt.Sprintf (
-				"%v: wrong record count", line))
+				"%v: %v", record.Line, err))
 		}
-
-		pkg := pkgscan.ParsePackage(row[0])
 		this.Packages[pkg.Name] = append(this.Packages[pkg.Name], packageEntry {
 			Package: pkg,
-			reason:  row[1],
+			Record:  record,
 		})
 	}
 	return nil
 }
 
-func readMap (input io.Reader, destination map[string] string) error {	
+// Record is a single row of a database file.
+type Record struct {
+	// The line the record was read from
+	Line       int
+	Identifier string
+	Reason     string
+
+	// Optional information about the vulnerability
+	Severity   severity.Severity
+	CVSS       string
+	Score      float64
+	Advisory   string
+	URL        string
+}
+
+// ReadRecords reads every record of a database file, which is a CSV file with
+// two columns: an identifier, and a reason. These may be followed by up to five
+// optional columns, any of which may be left blank: a severity, a CVSS vector,
+// a CVSS base score, an advisory identifier, and a URL. If the severity is
+// blank, it is derived from the score.
+func ReadRecords (input io.Reader) ([]Record, error) {
+	var records []Record
 	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
 	line := 0
 	for {
 		line ++
 		row, err := reader.Read()
 		if err == io.EOF { break }
-		if err != nil { return err }
-		if len(row) != 2 {
-			return errors.New(fmt.Sprintf (
+		if err != nil { return records, err }
+		if len(row) < 2 || len(row) > 7 {
+			return records, errors.New(fmt.Sprintf (
 				"%v: wrong record count", line))
 		}
-		destination[row[0]] = row[1]
+		record, err := parseRecord(row)
+		if err != nil {
+			return records, errors.New(fmt.Sprintf (
+				"%v: %v", line, err))
+		}
+		record.Line = line
+		records = append(records, record)
 	}
-	return nil
+	return records, nil
 }
 
-func (this *Database) CheckFile (filesystem fs.FS, path string) (*binscan.Vulnerability, error) {
-	this.ensure()
-	if this.Files == nil { return nil, nil }
+func parseRecord (row []string) (Record, error) {
+	column := func (index int) string {
+		if index >= len(row) { return "" }
+		return strings.TrimSpace(row[index])
+	}
 
-	file, err := filesystem.Open(path)
-	if err != nil { return nil, err }
-	defer file.Close()
+	record := Record {
+		Identifier: row[0],
+		Reason:     row[1],
+		CVSS:       column(3),
+		Advisory:   column(5),
+		URL:        column(6),
+	}
+	if score := column(4); score != "" {
+		parsed, err := strconv.ParseFloat(score, 64)
+		if err != nil || parsed < 0 || parsed > 10 {
+			return Record { }, errors.New(fmt.Sprint (
+				"invalid CVSS score ", score))
+		}
+		record.Score = parsed
+	}
+	if column(2) == "" {
+		if column(4) != "" { record.Severity = severity.FromScore(record.Score) }
+	} else {
+		parsed, err := severity.Parse(column(2))
+		if err != nil { return Record { }, err }
+		record.Severity = parsed
+	}
+	return record, nil
+}
+
+// row returns the columns of the record, omitting trailing optional columns
+// which are blank.
+func (this Record) row () []string {
+	score := ""
+	if this.Score != 0 { score = strconv.FormatFloat(this.Score, 'f', -1, 64) }
+	level := ""
+	if this.Severity != severity.Unknown { level = this.Severity.String() }
+
+	row := []string {
+		this.Identifier, this.Reason,
+		level, this.CVSS, score, this.Advisory, this.URL,
+	}
+	for len(row) > 2 && row[len(row) - 1] == "" {
+		row = row[:len(row) - 1]
+	}
+	return row
+}
 
-	this.hash.Reset()
-	_, err = io.Copy(this.hash, file)
+// WriteRecords writes records in the format read by ReadRecords.
+func WriteRecords (output io.Writer, records []Record) error {
+	writer := csv.NewWriter(output)
+	for _, record := range records {
+		err := writer.Write(record.row())
+		if err != nil { return err }
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (this *Database) CheckFile (filesystem fs.FS, path string) (*binscan.Vulnerability, error) {
+	if this.Files == nil { return nil, nil }
+	hash, err := HashFile(filesystem, path)
 	if err != nil { return nil, err }
+	return this.CheckHash(path, hash), nil
+}
 
-	hashString := hex.EncodeToString(this.hash.Sum(nil))
-	reason, vulnerable := this.Files[hashString]
-	if vulnerable {
-		return &binscan.Vulnerability {
-			Name:   path,
-			Hash:   hashString,
-			Source: "Local database",
-			Reason: reason,
-		}, nil
+// CheckHash checks a file with a known hexadecimal encoded sha256 sum.
+func (this *Database) CheckHash (path, hash string) *binscan.Vulnerability {
+	record, vulnerable := this.Files[hash]
+	if !vulnerable { return nil }
+	return &binscan.Vulnerability {
+		Name:     path,
+		Hash:     hash,
+		Source:   "Local database",
+		Reason:   record.Reason,
+		Severity: record.Severity,
+		CVSS:     record.CVSS,
+		Score:    record.Score,
+		Advisory: record.Advisory,
+		URL:      record.URL,
 	}
+}
 
-	return nil, nil
+// HashFile returns the hexadecimal encoded sha256 sum of a file.
+func HashFile (filesystem fs.FS, path string) (string, error) {
+	file, err := filesystem.Open(path)
+	if err != nil { return "", err }
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil { return "", err }
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func (this *Database) CheckPackage (pkg pkgscan.Package) (*pkgscan.Vulnerability, error) {
-	this.ensure()
 	if this.Packages == nil { return nil, nil }
 	
 	versions, vulnerable := this.Packages[pkg.Name]
@@ -110,17 +217,17 @@ func (this *Database) CheckPackage (pkg pkgscan.Package) (*pkgscan.Vulnerability
 	for _, entry := range versions {
 		if entry.matches(pkg) {
 			return &pkgscan.Vulnerability {
-				Package: pkg,
-				Source:  "Local database",
-				Reason:  entry.reason,
+				Package:  pkg,
+				Source:   "Local database",
+				Reason:   entry.Reason,
+				Severity: entry.Severity,
+				CVSS:     entry.CVSS,
+				Score:    entry.Score,
+				Advisory: entry.Advisory,
+				URL:      entry.URL,
 			}, nil
 		}
 	}
 
 	return nil, nil
 }
-
-func (this *Database) ensure () {
-	if this.hash != nil { return }
-	this.hash = sha256.New()
-}
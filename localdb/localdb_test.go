@@ -0,0 +1,41 @@
+package localdb
+
+import "strings"
+import "testing"
+import "github.com/ajblkf/microscope/pkgscan"
+
+func TestCheckPackageNamespaces (test *testing.T) {
+	angular := pkgscan.Package { Type: "npm", Namespace: "@angular", Name: "core", Version: "1.0" }
+	unscoped := pkgscan.Package { Type: "npm", Name: "core", Version: "1.0" }
+	untyped := pkgscan.Package { Namespace: "@angular", Name: "core", Version: "1.0" }
+	bash := pkgscan.Package { Type: "deb", Namespace: "debian", Name: "bash", Version: "5.2.15", Release: "2" }
+
+	cases := []struct {
+		identifier string
+		// whether each package matches
+		matching   map[*pkgscan.Package] bool
+	} {
+		{ "core-1.0-:",                   map[*pkgscan.Package] bool { &angular: false, &unscoped: true, &untyped: false } },
+		{ "pkg:npm/core@1.0",             map[*pkgscan.Package] bool { &angular: false, &unscoped: true, &untyped: false } },
+		{ "@angular/core-1.0-:",          map[*pkgscan.Package] bool { &angular: true, &unscoped: false, &untyped: true } },
+		{ "pkg:npm/%40angular/core@1.0",  map[*pkgscan.Package] bool { &angular: true, &unscoped: false, &untyped: false } },
+		{ "pkg:npm/%40other/core@1.0",    map[*pkgscan.Package] bool { &angular: false, &unscoped: false, &untyped: false } },
+		// distributions need not be given to match packages of them
+		{ "bash-5.2.15-2:",               map[*pkgscan.Package] bool { &bash: true } },
+		{ "pkg:deb/bash@5.2.15-2",        map[*pkgscan.Package] bool { &bash: true } },
+		{ "pkg:deb/debian/bash@5.2.15-2", map[*pkgscan.Package] bool { &bash: true } },
+		{ "pkg:deb/ubuntu/bash@5.2.15-2", map[*pkgscan.Package] bool { &bash: false } },
+	}
+	for _, current := range cases {
+		database := Database { }
+		err := database.ReadPackageDb(strings.NewReader(current.identifier + ",denied\n"))
+		if err != nil { test.Fatal(err) }
+		for pkg, expected := range current.matching {
+			vulnerability, err := database.CheckPackage(*pkg)
+			if err != nil { test.Fatal(err) }
+			if (vulnerability != nil) != expected {
+				test.Errorf("%v: expected a match with %v to be %v", current.identifier, pkg.PURL(), expected)
+			}
+		}
+	}
+}
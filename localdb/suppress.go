@@ -0,0 +1,67 @@
+package localdb
+
+import "io"
+import "fmt"
+import "errors"
+import "strings"
+import "encoding/hex"
+import "github.com/ajblkf/microscope/binscan"
+import "github.com/ajblkf/microscope/pkgscan"
+
+// Suppressions is a list of findings that have been reviewed and accepted, and
+// should not be reported as vulnerabilities.
+type Suppressions struct {
+	Files    map[string] string
+	Packages map[string] []packageEntry
+}
+
+// ReadSuppressionDb reads a CSV file with two columns: an identifier, and a
+// justification for why findings matching it are suppressed. The identifier is
+// either a hexadecimal encoded sha256 sum of a file, or a package identifier in
+// any of the formats accepted by ReadPackageDb.
+func (this *Suppressions) ReadSuppressionDb (input io.Reader) error {
+	if this.Files    == nil { this.Files    = make(map[string] string) }
+	if this.Packages == nil { this.Packages = make(map[string] []packageEntry) }
+
+	records, err := ReadRecords(input)
+	if err != nil { return err }
+	for _, record := range records {
+		identifier := strings.TrimSpace(record.Identifier)
+		if IsHash(identifier) {
+			this.Files[strings.ToLower(identifier)] = record.Reason
+			continue
+		}
+
+		pkg, err := pkgscan.ParseIdentifier(identifier)
+		if err != nil {
+			return errors.New(fmt.Sprintf (
+				"%v: %v", record.Line, err))
+		}
+		this.Packages[pkg.Name] = append(this.Packages[pkg.Name], packageEntry {
+			Package: pkg,
+			Record:  record,
+		})
+	}
+	return nil
+}
+
+func (this *Suppressions) SuppressFile (vuln binscan.Vulnerability) (string, bool) {
+	justification, suppressed := this.Files[strings.ToLower(vuln.Hash)]
+	return justification, suppressed
+}
+
+func (this *Suppressions) SuppressPackage (vuln pkgscan.Vulnerability) (string, bool) {
+	for _, entry := range this.Packages[vuln.Package.Name] {
+		if entry.matches(vuln.Package) {
+			return entry.Reason, true
+		}
+	}
+	return "", false
+}
+
+// IsHash returns whether an identifier is a hexadecimal encoded sha256 sum.
+func IsHash (identifier string) bool {
+	if len(identifier) != 64 { return false }
+	_, err := hex.DecodeString(identifier)
+	return err == nil
+}
@@ -8,6 +8,10 @@ import "strings"
 const APKPackageList = "lib/apk/db/installed"
 
 type APKListReader struct {
+	// Namespace is applied to every package read, and should be set to
+	// the distribution the packages were installed on.
+	Namespace string
+
 	reader *bufio.Reader
 	line string
 }
@@ -21,7 +25,7 @@ func NewAPKListReader (list io.Reader) *APKListReader {
 }
 
 func (this *APKListReader) Next () (Package, error) {
-	var pack Package
+	pack := Package { Type: "apk", Namespace: this.Namespace }
 	for this.line != "" {
 		switch this.line[0] {
 		case 'P':
@@ -33,6 +37,8 @@ func (this *APKListReader) Next () (Package, error) {
 			pack.Version,
 			pack.Release, _ = strings.Cut(this.line[2:], "-")
 			pack.Release = pack.Release[1:]
+		case 'A':
+			pack.Arch = this.line[2:]
 		}
 
 		err := this.nextLine()
@@ -58,5 +64,7 @@ func ScanAPK (filesystem fs.FS, database Database) ([]Vulnerability, error) {
 	file, err := filesystem.Open(APKPackageList)
 	if err != nil { return nil, err }
 	defer file.Close()
-	return ScanPackageReader(NewAPKListReader(file), database)
+	reader := NewAPKListReader(file)
+	reader.Namespace = Distribution(filesystem)
+	return ScanPackageReader(reader, database)
 }
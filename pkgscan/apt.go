@@ -5,9 +5,13 @@ import "io/fs"
 import "bufio"
 import "strings"
 
-const DPKGPackageList = "/var/lib/dpkg/status"
+const DPKGPackageList = "var/lib/dpkg/status"
 
 type DPKGListReader struct {
+	// Namespace is applied to every package read, and should be set to
+	// the distribution the packages were installed on.
+	Namespace string
+
 	reader *bufio.Reader
 	line string
 }
@@ -21,7 +25,7 @@ func NewDPKGListReader (list io.Reader) *DPKGListReader {
 }
 
 func (this *DPKGListReader) Next () (Package, error) {
-	var pack Package
+	pack := Package { Type: "deb", Namespace: this.Namespace }
 	for this.line != "" {
 		key, value, _ := strings.Cut(this.line, ": ")
 	
@@ -30,6 +34,9 @@ func (this *DPKGListReader) Next () (Package, error) {
 			pack.Name = value
 		case "Version":
 			pack.Version, pack.Release, _ = strings.Cut(value, "-")
+			pack.Epoch, pack.Version = splitEpoch(pack.Version)
+		case "Architecture":
+			pack.Arch = value
 		}
 
 		err := this.nextLine()
@@ -40,10 +47,7 @@ func (this *DPKGListReader) Next () (Package, error) {
 
 func (this *DPKGListReader) nextLine () error {
 	line, err := this.reader.ReadString('\n')
-	this.line = line
-	if len(this.line) > 0 {
-		this.line = this.line[:len(this.line)]
-	}
+	this.line = strings.TrimSuffix(line, "\n")
 	return err
 }
 
@@ -51,5 +55,7 @@ func ScanAPT (filesystem fs.FS, database Database) ([]Vulnerability, error) {
 	file, err := filesystem.Open(DPKGPackageList)
 	if err != nil { return nil, err }
 	defer file.Close()
-	return ScanPackageReader(NewDPKGListReader(file), database)
+	reader := NewDPKGListReader(file)
+	reader.Namespace = Distribution(filesystem)
+	return ScanPackageReader(reader, database)
 }
@@ -0,0 +1,70 @@
+package pkgscan
+
+import "fmt"
+import "errors"
+import "strings"
+
+// ParseCPE parses a CPE 2.3 formatted string into a Package. Only the product,
+// version and target hardware are used, as the other components have no
+// equivalent in package managers. Components that are ANY (*) or NA (-) are
+// left blank, and so match anything.
+func ParseCPE (input string) (Package, error) {
+	var pack Package
+
+	fields := splitCPE(input)
+	if len(fields) != 13 || fields[0] != "cpe" || fields[1] != "2.3" {
+		return pack, errors.New(fmt.Sprintf (
+			"%v: not a CPE 2.3 formatted string", input))
+	}
+
+	// 0   1   2    3      4       5       6      7       8        9
+	// cpe:2.3:part:vendor:product:version:update:edition:language:sw_edition:
+	// 10        11        12
+	// target_sw:target_hw:other
+	pack.Name    = fields[4]
+	pack.Version = fields[5]
+	pack.Arch    = fields[11]
+	if pack.Name == "" {
+		return pack, errors.New(fmt.Sprintf (
+			"%v: CPE has no product", input))
+	}
+
+	return pack, nil
+}
+
+// splitCPE splits a CPE formatted string on unescaped colons, unescaping
+// each field and blanking out logical values. Escaped asterisks and hyphens
+// are kept, as they are not logical values.
+func splitCPE (input string) []string {
+	var fields  []string
+	var current strings.Builder
+	escaped := false
+	// whether the current field has escaped characters
+	quoted  := false
+
+	finish := func () {
+		field := current.String()
+		if !quoted && (field == "*" || field == "-") { field = "" }
+		fields = append(fields, field)
+		current.Reset()
+		quoted = false
+	}
+
+	for _, ch := range input {
+		switch {
+		case escaped:
+			current.WriteRune(ch)
+			escaped = false
+			quoted  = true
+		case ch == '\\':
+			escaped = true
+		case ch == ':':
+			finish()
+		default:
+			current.WriteRune(ch)
+		}
+	}
+	finish()
+
+	return fields
+}
@@ -0,0 +1,89 @@
+package pkgscan
+
+import "strings"
+import "testing"
+
+func TestParseCPE (test *testing.T) {
+	cases := []struct {
+		input    string
+		expected Package
+	} {
+		{
+			"cpe:2.3:a:openssl:openssl:1.1.1k:*:*:*:*:*:x86_64:*",
+			Package { Name: "openssl", Version: "1.1.1k", Arch: "x86_64" },
+		}, {
+			// ANY and NA values match anything
+			"cpe:2.3:a:haxx:curl:*:*:*:*:*:*:-:*",
+			Package { Name: "curl" },
+		}, {
+			"cpe:2.3:a:haxx:curl:-:*:*:*:*:*:*:*",
+			Package { Name: "curl" },
+		}, {
+			`cpe:2.3:a:vendor:foo\:bar:1.0\-beta:*:*:*:*:*:*:*`,
+			Package { Name: "foo:bar", Version: "1.0-beta" },
+		}, {
+			`cpe:2.3:a:vendor:c\+\+_tool:2.0:*:*:*:*:*:*:*`,
+			Package { Name: "c++_tool", Version: "2.0" },
+		}, {
+			// escaped asterisks and hyphens are not logical values
+			`cpe:2.3:a:vendor:tool:\*:*:*:*:*:*:\-:*`,
+			Package { Name: "tool", Version: "*", Arch: "-" },
+		}, {
+			`cpe:2.3:a:vendor:tool\\:1.0:*:*:*:*:*:*:*`,
+			Package { Name: `tool\`, Version: "1.0" },
+		},
+	}
+	for _, current := range cases {
+		pkg, err := ParseCPE(current.input)
+		if err != nil {
+			test.Errorf("%v: %v", current.input, err)
+			continue
+		}
+		if pkg != current.expected {
+			test.Errorf("%v: expected %+v, got %+v", current.input, current.expected, pkg)
+		}
+	}
+}
+
+func TestParseCPEErrors (test *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	} {
+		{ "cpe:2.3:a:openssl:openssl:1.0",                 "not a CPE 2.3 formatted string" },
+		{ "cpe:2.3:a:openssl:openssl:1.0:*:*:*:*:*:*:*:*", "not a CPE 2.3 formatted string" },
+		{ "cpe:2.2:a:openssl:openssl:1.0:*:*:*:*:*:*:*",   "not a CPE 2.3 formatted string" },
+		{ "cpe:/a:openssl:openssl:1.0",                    "not a CPE 2.3 formatted string" },
+		{ `cpe:2.3:a:openssl:openssl\:1.0:*:*:*:*:*:*:*`,  "not a CPE 2.3 formatted string" },
+		{ "cpe:2.3:a:openssl:*:1.0:*:*:*:*:*:*:*",         "CPE has no product" },
+		{ "cpe:2.3:a:openssl:-:1.0:*:*:*:*:*:*:*",         "CPE has no product" },
+	}
+	for _, current := range cases {
+		_, err := ParseCPE(current.input)
+		if err == nil || !strings.Contains(err.Error(), current.expected) {
+			test.Errorf("%v: expected %q, got %v", current.input, current.expected, err)
+		}
+	}
+}
+
+func TestParseIdentifier (test *testing.T) {
+	cases := []struct {
+		input    string
+		expected Package
+	} {
+		{ " pkg:npm/lodash@4.17.21 ",              Package { Type: "npm", Name: "lodash", Version: "4.17.21" } },
+		{ "cpe:2.3:a:haxx:curl:8.0:*:*:*:*:*:*:*", Package { Name: "curl", Version: "8.0" } },
+		{ "bash-5.2.15-2:",                        Package { Name: "bash", Version: "5.2.15", Release: "2" } },
+		{ "@angular/core-1.0-:",                   Package { Namespace: "@angular", Name: "core", Version: "1.0" } },
+	}
+	for _, current := range cases {
+		pkg, err := ParseIdentifier(current.input)
+		if err != nil {
+			test.Errorf("%v: %v", current.input, err)
+			continue
+		}
+		if pkg != current.expected {
+			test.Errorf("%v: expected %+v, got %+v", current.input, current.expected, pkg)
+		}
+	}
+}
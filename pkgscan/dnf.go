@@ -10,6 +10,10 @@ import _ "github.com/glebarez/go-sqlite"
 const DNFPackageList = "var/cache/dnf/packages.db"
 
 type DNFListReader struct {
+	// Namespace is applied to every package read, and should be set to
+	// the distribution the packages were installed on.
+	Namespace string
+
 	rows *sql.Rows
 }
 
@@ -27,7 +31,9 @@ func (this *DNFListReader) Next () (Package, error) {
 	var rawName string
 	err := this.rows.Scan(&rawName)
 	if err != nil { return Package { }, err }
-	return parseDNFGarbageNonsense(rawName), nil
+	pack := parseDNFGarbageNonsense(rawName)
+	pack.Namespace = this.Namespace
+	return pack, nil
 }
 
 func ScanDNF (filesystem fs.FS, database Database) ([]Vulnerability, error) {
@@ -48,7 +54,9 @@ func ScanDNF (filesystem fs.FS, database Database) ([]Vulnerability, error) {
 	if err != nil { return nil, err }
 
 	// scan
-	return ScanPackageReader(NewDNFListReader(rows), database)
+	reader := NewDNFListReader(rows)
+	reader.Namespace = Distribution(filesystem)
+	return ScanPackageReader(reader, database)
 }
 
 func extractToTemp (filesystem fs.FS, name string) (*os.File, error) {
@@ -106,13 +114,28 @@ func parseDNFGarbageNonsense (input string) Package {
 		}
 	}
 
-	pack.Version, _, _ = strings.Cut(pack.Version, "-")
+	pack.Type = "rpm"
+	pack.Version, pack.Release, _ = strings.Cut(pack.Version, "-")
+	pack.Epoch,   pack.Version    = splitEpoch(pack.Version)
 
-	// take arch off of version
-	// index := strings.LastIndex(pack.Version, ".")
-	// if index >= 0 {
-		// pack.Version = pack.Version[:index]
-	// }
+	// take arch off of release
+	index := strings.LastIndex(pack.Release, ".")
+	if index >= 0 && rpmArches[pack.Release[index + 1:]] {
+		pack.Arch    = pack.Release[index + 1:]
+		pack.Release = pack.Release[:index]
+	}
 
 	return pack
 }
+
+var rpmArches = map[string] bool {
+	"noarch":  true,
+	"x86_64":  true,
+	"i386":    true,
+	"i686":    true,
+	"aarch64": true,
+	"armv7hl": true,
+	"ppc64le": true,
+	"s390x":   true,
+	"riscv64": true,
+}
@@ -0,0 +1,32 @@
+package pkgscan
+
+import "sort"
+
+// Inventory is a Database that records every package it is asked to check,
+// without ever reporting a vulnerability. It can be passed to any scanning
+// function to list the packages installed on a system.
+type Inventory struct {
+	Packages []Package
+}
+
+func (this *Inventory) CheckPackage (pkg Package) (*Vulnerability, error) {
+	if pkg.Name != "" {
+		this.Packages = append(this.Packages, pkg)
+	}
+	return nil, nil
+}
+
+// Sorted returns the packages in the inventory sorted by package URL, with
+// duplicates removed.
+func (this *Inventory) Sorted () []Package {
+	packages := append([]Package(nil), this.Packages...)
+	sort.Slice(packages, func (left, right int) bool {
+		return packages[left].PURL() < packages[right].PURL()
+	})
+	unique := packages[:0]
+	for index, pkg := range packages {
+		if index > 0 && pkg == packages[index - 1] { continue }
+		unique = append(unique, pkg)
+	}
+	return unique
+}
@@ -2,6 +2,7 @@ package pkgscan
 
 import "io"
 import "path"
+import "strings"
 import "encoding/json"
 
 type NPMListReader struct {
@@ -29,12 +30,18 @@ func NewNPMListReader (packageLock io.Reader) (*NPMListReader, error) {
 	index := 0
 	for where, entry := range list.Packages {
 		pkg := Package {
+			Type:    "npm",
 			Name:    entry.Name,
 			Version: entry.Version,
 		}
 		
 		if pkg.Name == "" {
-			pkg.Name = path.Base(where)
+			pkg.Name = npmPackageName(where)
+		}
+		// scoped packages are split into their scope and name
+		if index := strings.LastIndex(pkg.Name, "/"); index >= 0 {
+			pkg.Namespace = pkg.Name[:index]
+			pkg.Name      = pkg.Name[index + 1:]
 		}
 		
 		reader.list[index] = pkg
@@ -44,6 +51,18 @@ func NewNPMListReader (packageLock io.Reader) (*NPMListReader, error) {
 	return reader, nil
 }
 
+// npmPackageName returns the name of a package given its location in
+// package-lock.json, such as node_modules/@scope/name.
+func npmPackageName (where string) string {
+	_, name, found := strings.Cut(where, "node_modules/")
+	for found {
+		where = name
+		_, name, found = strings.Cut(where, "node_modules/")
+	}
+	if strings.HasPrefix(where, "@") { return where }
+	return path.Base(where)
+}
+
 func (this *NPMListReader) Next () (Package, error) {
 	if len(this.list) < 1 {
 		return Package { }, io.EOF
@@ -0,0 +1,36 @@
+package pkgscan
+
+import "io/fs"
+import "bufio"
+import "strings"
+
+// OSReleaseFiles lists the locations of the os-release file, in order of
+// precedence.
+var OSReleaseFiles = []string {
+	"etc/os-release",
+	"usr/lib/os-release",
+}
+
+// Distribution returns the package URL namespace of the distribution installed
+// on the given filesystem, such as debian or alpine. It returns an empty string
+// if the distribution cannot be determined.
+func Distribution (filesystem fs.FS) string {
+	for _, name := range OSReleaseFiles {
+		file, err := filesystem.Open(name)
+		if err != nil { continue }
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			key, value, _ := strings.Cut(scanner.Text(), "=")
+			if key != "ID" { continue }
+			value = strings.Trim(value, "\"'")
+			switch value {
+			case "rhel": return "redhat"
+			default:     return value
+			}
+		}
+		return ""
+	}
+	return ""
+}
@@ -5,16 +5,24 @@ import "fmt"
 import "io/fs"
 import "strings"
 import "github.com/ajblkf/microscope/pmdetect"
+import "github.com/ajblkf/microscope/severity"
 
 type Database interface {
 	CheckPackage (Package) (*Vulnerability, error)
 }
 
 type Package struct {
-	Name       string
-	Version    string
-	Release    string
-	Repository string
+	// The ecosystem the package belongs to, as a package URL type (deb,
+	// apk, rpm, npm, etc). Empty if unknown.
+	Type       string `json:"type,omitempty"`
+	// The namespace of the package, such as an npm scope or a distribution
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	Epoch      string `json:"epoch,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Release    string `json:"release,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	Repository string `json:"repository,omitempty"`
 }
 
 func ParsePackage (input string) Package {
@@ -55,38 +63,145 @@ func ParsePackage (input string) Package {
 
 	pack.Version, pack.Release,    _ = strings.Cut(pack.Version, "-")
 	pack.Release, pack.Repository, _ = strings.Cut(pack.Release, ":")
+	pack.Epoch,   pack.Version        = splitEpoch(pack.Version)
+
+	if index := strings.LastIndex(pack.Name, "/"); index >= 0 {
+		pack.Namespace = pack.Name[:index]
+		pack.Name      = pack.Name[index + 1:]
+	}
 
 	return pack
 }
 
+// ParseIdentifier parses a package identifier, which may be a package URL, a
+// CPE 2.3 formatted string, or NAME-VERSION-RELEASE:REPOSITORY.
+func ParseIdentifier (input string) (Package, error) {
+	input = strings.TrimSpace(input)
+	switch {
+	case strings.HasPrefix(input, "pkg:"):     return ParsePURL(input)
+	case strings.HasPrefix(input, "cpe:2.3:"): return ParseCPE(input)
+	default: return ParsePackage(input), nil
+	}
+}
+
+// String formats the package as NAME-VERSION-RELEASE:REPOSITORY. Namespaces,
+// such as the distribution a package was installed on, are left out as they
+// are not part of this format, but PURL includes them. The scopes of npm
+// packages, and namespaces read from the name of a package of unknown type,
+// are kept in the name.
 func (this Package) String () string {
-	return fmt.Sprintf("%v-%v-%v:%v", this.Name, this.Version, this.Release, this.Repository)
+	name := this.Name
+	if (this.Type == "" || this.Type == "npm") && this.Namespace != "" {
+		name = this.Namespace + "/" + name
+	}
+	version := this.Version
+	if this.Epoch != "" {
+		version = this.Epoch + ":" + version
+	}
+	return fmt.Sprintf("%v-%v-%v:%v", name, version, this.Release, this.Repository)
+}
+
+// FullVersion returns the epoch, version and release of the package formatted
+// the way its package manager would, such as 1:2.3-4 or 2.3-r4.
+func (this Package) FullVersion () string {
+	version := this.Version
+	if this.Epoch != "" {
+		version = this.Epoch + ":" + version
+	}
+	if this.Release != "" {
+		if this.Type == "apk" {
+			version += "-r" + this.Release
+		} else {
+			version += "-" + this.Release
+		}
+	}
+	return version
+}
+
+func splitEpoch (version string) (epoch, rest string) {
+	epoch, rest, found := strings.Cut(version, ":")
+	if !found { return "", version }
+	return epoch, rest
 }
 
 type Vulnerability struct {
 	// The vulnerable package
-	Package Package
+	Package Package `json:"package"`
 	// Where the vulnerability was mentioned
-	Source string
+	Source string   `json:"source"`
 	// Description of the vulnerability
-	Reason string
+	Reason string   `json:"reason"`
+	// How severe the vulnerability is
+	Severity severity.Severity `json:"severity,omitempty"`
+	// The CVSS vector of the vulnerability
+	CVSS     string  `json:"cvss,omitempty"`
+	// The CVSS base score of the vulnerability, or zero if unknown
+	Score    float64 `json:"score,omitempty"`
+	// An identifier of the advisory, such as a CVE or GHSA id
+	Advisory string  `json:"advisory,omitempty"`
+	// Where to read more about the vulnerability
+	URL      string  `json:"url,omitempty"`
+	// The digest of the image layer which added the finding, if it was found
+	// in an image
+	Layer     string `json:"layer,omitempty"`
+	// The instruction which created that layer, such as a Dockerfile line
+	CreatedBy string `json:"createdBy,omitempty"`
 }
 
 func (this Vulnerability) String () string {
-	return fmt.Sprintf("%v\t%s\t%s", this.Package, this.Source, this.Reason)
+	text := fmt.Sprintf("%v\t%s\t%s", this.Package, this.Source, this.Reason)
+	if this.Layer != "" {
+		text += fmt.Sprintf("\t%s\t%s", this.Layer, this.CreatedBy)
+	}
+	return text
 }
 
 func Scan (filesystem fs.FS, database Database) ([]Vulnerability, error) {
+	return scan(filesystem, database, true)
+}
+
+// ScanQuietly is like Scan, but does not print which package managers are
+// being scanned.
+func ScanQuietly (filesystem fs.FS, database Database) ([]Vulnerability, error) {
+	return scan(filesystem, database, false)
+}
+
+// NeedsFile returns whether Scan may read a file or directory, given its path
+// relative to the root of a filesystem. Filesystems which can only be read
+// once, such as archives being streamed, can keep just these files in order to
+// scan their packages afterwards.
+func NeedsFile (name string) bool {
+	needed := []string {
+		APKPackageList,
+		DPKGPackageList,
+		DNFPackageList,
+	}
+	needed = append(needed, OSReleaseFiles...)
+	for _, pm := range pmdetect.All() {
+		needed = append(needed, pm.Markers()...)
+	}
+	for _, other := range needed {
+		if name == other { return true }
+	}
+	return false
+}
+
+func scan (filesystem fs.FS, database Database, verbose bool) ([]Vulnerability, error) {
 	var vulnerabilities []Vulnerability
 
 	pms := pmdetect.Detect(filesystem)
-	if len(pms) == 0 {
+	if len(pms) == 0 && verbose {
 		fmt.Fprintf (
 			os.Stderr, "%v: no package managers detected\n",
 			os.Args[0])
 	}
 	for _, pm := range pms {
-		vulnPiece, err := ScanPackageManager(filesystem, database, pm)
+		if verbose {
+			fmt.Fprintf (
+				os.Stderr, "%v: scanning %v\n",
+				os.Args[0], pm)
+		}
+		vulnPiece, err := scanPackageManager(filesystem, database, pm)
 		vulnerabilities = append(vulnerabilities, vulnPiece...)
 		if err != nil { return vulnerabilities, err }
 	}
@@ -107,6 +222,17 @@ func ScanPackageManager (
 	fmt.Fprintf (
 		os.Stderr, "%v: scanning %v\n",
 		os.Args[0], pm)
+	return scanPackageManager(filesystem, database, pm)
+}
+
+func scanPackageManager (
+	filesystem fs.FS,
+	database Database,
+	pm pmdetect.PackageManager,
+) (
+	[]Vulnerability,
+	error,
+) {
 	switch pm {
 	case pmdetect.PmAPT:     return ScanAPT(filesystem, database)
 	case pmdetect.PmAPK:     return ScanAPK(filesystem, database)
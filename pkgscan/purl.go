@@ -0,0 +1,144 @@
+package pkgscan
+
+import "fmt"
+import "sort"
+import "errors"
+import "strings"
+import "net/url"
+
+// ParsePURL parses a package URL (see https://github.com/package-url/purl-spec)
+// into a Package.
+func ParsePURL (input string) (Package, error) {
+	var pack Package
+	remaining := input
+
+	// the subpath is not needed to identify a package
+	remaining, _, _ = strings.Cut(remaining, "#")
+	remaining, rawQualifiers, _ := strings.Cut(remaining, "?")
+
+	scheme, remaining, found := strings.Cut(remaining, ":")
+	if !found || strings.ToLower(scheme) != "pkg" {
+		return pack, errors.New(fmt.Sprintf (
+			"%v: not a package URL", input))
+	}
+	remaining = strings.Trim(remaining, "/")
+
+	kind, remaining, found := strings.Cut(remaining, "/")
+	if !found || kind == "" {
+		return pack, errors.New(fmt.Sprintf (
+			"%v: package URL has no type", input))
+	}
+	pack.Type = strings.ToLower(kind)
+
+	if index := strings.LastIndex(remaining, "@"); index >= 0 {
+		version, err := url.PathUnescape(remaining[index + 1:])
+		if err != nil { return pack, err }
+		pack.Version = version
+		remaining = remaining[:index]
+	}
+
+	if index := strings.LastIndex(remaining, "/"); index >= 0 {
+		segments := strings.Split(remaining[:index], "/")
+		for index, segment := range segments {
+			segment, err := url.PathUnescape(segment)
+			if err != nil { return pack, err }
+			segments[index] = segment
+		}
+		pack.Namespace = strings.Join(segments, "/")
+		remaining = remaining[index + 1:]
+	}
+	name, err := url.PathUnescape(remaining)
+	if err != nil { return pack, err }
+	if name == "" {
+		return pack, errors.New(fmt.Sprintf (
+			"%v: package URL has no name", input))
+	}
+	pack.Name = name
+
+	qualifiers, err := url.ParseQuery(rawQualifiers)
+	if err != nil { return pack, err }
+	pack.Arch       = qualifiers.Get("arch")
+	pack.Epoch      = qualifiers.Get("epoch")
+	pack.Repository = qualifiers.Get("repository_url")
+
+	switch pack.Type {
+	case "deb":
+		pack.Version, pack.Release, _ = strings.Cut(pack.Version, "-")
+		if pack.Epoch == "" {
+			pack.Epoch, pack.Version = splitEpoch(pack.Version)
+		}
+	case "rpm":
+		pack.Version, pack.Release, _ = strings.Cut(pack.Version, "-")
+	case "apk":
+		pack.Version, pack.Release, _ = strings.Cut(pack.Version, "-")
+		pack.Release = strings.TrimPrefix(pack.Release, "r")
+	}
+
+	return pack, nil
+}
+
+// PURL renders the package as a package URL. Packages of an unknown type are
+// rendered with the generic type.
+func (this Package) PURL () string {
+	kind := this.Type
+	if kind == "" { kind = "generic" }
+
+	builder := strings.Builder { }
+	builder.WriteString("pkg:")
+	builder.WriteString(kind)
+	builder.WriteString("/")
+	if this.Namespace != "" {
+		for _, segment := range strings.Split(this.Namespace, "/") {
+			builder.WriteString(escapePURL(segment))
+			builder.WriteString("/")
+		}
+	}
+	builder.WriteString(escapePURL(this.Name))
+
+	qualifiers := map[string] string { }
+	version := this.Version
+	switch kind {
+	case "deb":
+		if this.Release != "" { version += "-" + this.Release }
+		if this.Epoch   != "" { version = this.Epoch + ":" + version }
+	case "apk":
+		if this.Release != "" { version += "-r" + this.Release }
+	default:
+		if this.Release != "" { version += "-" + this.Release }
+		if this.Epoch   != "" { qualifiers["epoch"] = this.Epoch }
+	}
+	if version != "" {
+		builder.WriteString("@")
+		builder.WriteString(escapePURL(version))
+	}
+
+	if this.Arch       != "" { qualifiers["arch"] = this.Arch }
+	if this.Repository != "" { qualifiers["repository_url"] = this.Repository }
+	keys := make([]string, 0, len(qualifiers))
+	for key := range qualifiers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for index, key := range keys {
+		if index == 0 {
+			builder.WriteString("?")
+		} else {
+			builder.WriteString("&")
+		}
+		builder.WriteString(key)
+		builder.WriteString("=")
+		builder.WriteString(url.QueryEscape(qualifiers[key]))
+	}
+
+	return builder.String()
+}
+
+func escapePURL (segment string) string {
+	// PathEscape leaves some characters alone that are significant within
+	// a package URL
+	escaped := url.PathEscape(segment)
+	escaped = strings.ReplaceAll(escaped, "@", "%40")
+	escaped = strings.ReplaceAll(escaped, "?", "%3F")
+	escaped = strings.ReplaceAll(escaped, "#", "%23")
+	return escaped
+}
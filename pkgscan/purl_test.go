@@ -0,0 +1,109 @@
+package pkgscan
+
+import "strings"
+import "testing"
+
+func TestParsePURL (test *testing.T) {
+	cases := []struct {
+		input    string
+		expected Package
+	} {
+		{
+			"pkg:deb/debian/bash@1:5.2.15-2?arch=amd64",
+			Package { Type: "deb", Namespace: "debian", Name: "bash", Epoch: "1", Version: "5.2.15", Release: "2", Arch: "amd64" },
+		}, {
+			"pkg:deb/debian/bash@5.2.15-2?epoch=3&arch=amd64",
+			Package { Type: "deb", Namespace: "debian", Name: "bash", Epoch: "3", Version: "5.2.15", Release: "2", Arch: "amd64" },
+		}, {
+			"pkg:rpm/fedora/curl@7.50.3-1.fc25?arch=i386&distro=fedora-25",
+			Package { Type: "rpm", Namespace: "fedora", Name: "curl", Version: "7.50.3", Release: "1.fc25", Arch: "i386" },
+		}, {
+			"pkg:apk/alpine/curl@8.5.0-r1?arch=x86_64",
+			Package { Type: "apk", Namespace: "alpine", Name: "curl", Version: "8.5.0", Release: "1", Arch: "x86_64" },
+		}, {
+			"pkg:rpm/curl@8.0-1?repository_url=https%3A%2F%2Fmirror.example.com%2Frepo",
+			Package { Type: "rpm", Name: "curl", Version: "8.0", Release: "1", Repository: "https://mirror.example.com/repo" },
+		}, {
+			"pkg:npm/%40angular/core@16.0.0",
+			Package { Type: "npm", Namespace: "@angular", Name: "core", Version: "16.0.0" },
+		}, {
+			// scopes are often left unencoded
+			"pkg:npm/@angular/core@16.0.0",
+			Package { Type: "npm", Namespace: "@angular", Name: "core", Version: "16.0.0" },
+		}, {
+			"pkg:npm/lodash@4.17.21",
+			Package { Type: "npm", Name: "lodash", Version: "4.17.21" },
+		}, {
+			"pkg:generic/my%20tool@1.0%2Bbuild.5",
+			Package { Type: "generic", Name: "my tool", Version: "1.0+build.5" },
+		}, {
+			"pkg:maven/org.apache.commons/commons%2Dio/commons-io@2.11.0?type=jar",
+			Package { Type: "maven", Namespace: "org.apache.commons/commons-io", Name: "commons-io", Version: "2.11.0" },
+		}, {
+			"pkg:golang/google.golang.org/genproto@v0.0.1#googleapis/api/annotations",
+			Package { Type: "golang", Namespace: "google.golang.org", Name: "genproto", Version: "v0.0.1" },
+		}, {
+			"pkg:github/package-url/purl-spec#everybody/loves/dogs",
+			Package { Type: "github", Namespace: "package-url", Name: "purl-spec" },
+		}, {
+			"PKG:NPM/lodash",
+			Package { Type: "npm", Name: "lodash" },
+		}, {
+			"pkg://npm/lodash@1",
+			Package { Type: "npm", Name: "lodash", Version: "1" },
+		},
+	}
+	for _, current := range cases {
+		pkg, err := ParsePURL(current.input)
+		if err != nil {
+			test.Errorf("%v: %v", current.input, err)
+			continue
+		}
+		if pkg != current.expected {
+			test.Errorf("%v: expected %+v, got %+v", current.input, current.expected, pkg)
+		}
+	}
+}
+
+func TestParsePURLErrors (test *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	} {
+		{ "npm/lodash@1",         "not a package URL" },
+		{ "purl:npm/lodash",      "not a package URL" },
+		{ "pkg:lodash",           "package URL has no type" },
+		{ "pkg:npm/",             "package URL has no type" },
+		{ "pkg:/lodash",          "package URL has no type" },
+		{ "pkg:npm/@1.0",         "package URL has no name" },
+		{ "pkg:npm/scope/@1.0",   "package URL has no name" },
+		{ "pkg:npm/lodash%zz",    "invalid URL escape" },
+		{ "pkg:npm/%zz/lodash",   "invalid URL escape" },
+		{ "pkg:npm/lodash@1%zz",  "invalid URL escape" },
+		{ "pkg:npm/lodash?a=%zz", "invalid URL escape" },
+	}
+	for _, current := range cases {
+		_, err := ParsePURL(current.input)
+		if err == nil || !strings.Contains(err.Error(), current.expected) {
+			test.Errorf("%v: expected %q, got %v", current.input, current.expected, err)
+		}
+	}
+}
+
+func TestPURLRoundTrip (test *testing.T) {
+	for _, input := range []string {
+		"pkg:deb/debian/bash@1:5.2.15-2?arch=amd64",
+		"pkg:rpm/fedora/curl@7.50.3-1.fc25?arch=i386&epoch=2",
+		"pkg:apk/alpine/curl@8.5.0-r1?arch=x86_64",
+		"pkg:npm/%40angular/core@16.0.0",
+		"pkg:generic/my%20tool@1.0+build.5",
+		"pkg:rpm/curl@8.0-1?repository_url=https%3A%2F%2Fmirror.example.com%2Frepo",
+	} {
+		pkg, err := ParsePURL(input)
+		if err != nil {
+			test.Errorf("%v: %v", input, err)
+			continue
+		}
+		if pkg.PURL() != input { test.Errorf("%v: rendered as %v", input, pkg.PURL()) }
+	}
+}
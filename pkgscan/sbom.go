@@ -0,0 +1,173 @@
+package pkgscan
+
+import "io"
+import "fmt"
+import "errors"
+import "strings"
+import "encoding/json"
+
+// SBOMListReader reads packages from a CycloneDX or SPDX JSON document.
+type SBOMListReader struct {
+	list []Package
+}
+
+type sbomDocument struct {
+	// CycloneDX
+	BOMFormat  string              `json:"bomFormat"`
+	Components []cycloneDXComponent `json:"components"`
+
+	// SPDX
+	SPDXVersion string        `json:"spdxVersion"`
+	Packages    []spdxPackage `json:"packages"`
+}
+
+type cycloneDXComponent struct {
+	Group      string              `json:"group"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version"`
+	PURL       string              `json:"purl"`
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type spdxPackage struct {
+	Name         string            `json:"name"`
+	VersionInfo  string            `json:"versionInfo"`
+	ExternalRefs []spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxExternalRef struct {
+	ReferenceType    string `json:"referenceType"`
+	ReferenceLocator string `json:"referenceLocator"`
+}
+
+func NewSBOMListReader (document io.Reader) (*SBOMListReader, error) {
+	decoder := json.NewDecoder(document)
+	sbom := sbomDocument { }
+	err := decoder.Decode(&sbom)
+	if err != nil { return nil, err }
+
+	reader := &SBOMListReader { }
+	switch {
+	case strings.EqualFold(sbom.BOMFormat, "CycloneDX"):
+		err = reader.addCycloneDX(sbom.Components)
+	case strings.HasPrefix(sbom.SPDXVersion, "SPDX-"):
+		err = reader.addSPDX(sbom.Packages)
+	default:
+		err = errors.New("unknown SBOM format")
+	}
+	if err != nil { return nil, err }
+
+	return reader, nil
+}
+
+func (this *SBOMListReader) addCycloneDX (components []cycloneDXComponent) error {
+	for _, component := range components {
+		if component.PURL != "" {
+			pkg, err := ParsePURL(component.PURL)
+			if err != nil { return err }
+			this.list = append(this.list, pkg)
+		} else if component.Name != "" {
+			this.list = append(this.list, Package {
+				Namespace: component.Group,
+				Name:      component.Name,
+				Version:   component.Version,
+			})
+		}
+
+		err := this.addCycloneDX(component.Components)
+		if err != nil { return err }
+	}
+	return nil
+}
+
+func (this *SBOMListReader) addSPDX (packages []spdxPackage) error {
+	for _, spdx := range packages {
+		pkg := Package {
+			Name:    spdx.Name,
+			Version: spdx.VersionInfo,
+		}
+		for _, ref := range spdx.ExternalRefs {
+			if ref.ReferenceType != "purl" { continue }
+			var err error
+			pkg, err = ParsePURL(ref.ReferenceLocator)
+			if err != nil {
+				return errors.New(fmt.Sprintf (
+					"%v: %v", spdx.Name, err))
+			}
+			break
+		}
+		if pkg.Name == "" { continue }
+		this.list = append(this.list, pkg)
+	}
+	return nil
+}
+
+func (this *SBOMListReader) Next () (Package, error) {
+	if len(this.list) < 1 {
+		return Package { }, io.EOF
+	}
+
+	pkg := this.list[0]
+	this.list = this.list[1:]
+	return pkg, nil
+}
+
+func ScanSBOM (document io.Reader, database Database) ([]Vulnerability, error) {
+	reader, err := NewSBOMListReader(document)
+	if err != nil { return nil, err }
+	return ScanPackageReader(reader, database)
+}
+
+type cycloneDXOutput struct {
+	BOMFormat   string                    `json:"bomFormat"`
+	SpecVersion string                    `json:"specVersion"`
+	Version     int                       `json:"version"`
+	Metadata    cycloneDXMetadata         `json:"metadata"`
+	Components  []cycloneDXOutputComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Tools []cycloneDXTool `json:"tools"`
+}
+
+type cycloneDXTool struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXOutputComponent struct {
+	Type    string `json:"type"`
+	BOMRef  string `json:"bom-ref"`
+	Group   string `json:"group,omitempty"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	PURL    string `json:"purl"`
+}
+
+// WriteCycloneDX writes a list of packages as a CycloneDX JSON document, which
+// can be read back with NewSBOMListReader.
+func WriteCycloneDX (output io.Writer, packages []Package) error {
+	document := cycloneDXOutput {
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cycloneDXMetadata {
+			Tools: []cycloneDXTool { { Name: "microscope" } },
+		},
+		Components: make([]cycloneDXOutputComponent, len(packages)),
+	}
+	for index, pkg := range packages {
+		purl := pkg.PURL()
+		document.Components[index] = cycloneDXOutputComponent {
+			Type:    "library",
+			BOMRef:  purl,
+			Group:   pkg.Namespace,
+			Name:    pkg.Name,
+			Version: pkg.FullVersion(),
+			PURL:    purl,
+		}
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(document)
+}
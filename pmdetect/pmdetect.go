@@ -24,17 +24,24 @@ type PackageManager int; const (
 // ExistsOn returns whether or not the given package manager exists on the
 // system. Root is the root filesystem of the system being analyzed.
 func (pm PackageManager) ExistsOn (root fs.FS) bool {
+	for _, marker := range pm.Markers() {
+		if fileExists(root, marker) { return true }
+	}
+	return false
+}
+
+// Markers returns the files and directories whose presence shows that the
+// given package manager exists on a system.
+func (pm PackageManager) Markers () []string {
 	switch pm {
-	case PmAPT:     return fileExists(root, "etc/apt/sources.list") ||
-				fileExists(root, "etc/apt/sources.list.d")
-	case PmAPK:     return fileExists(root, "etc/apk/repositories")
-	case PmDNF:     return fileExists(root, "etc/yum.repos.d")
-	case PmPacman:	return fileExists(root, "etc/pacman.conf") ||
-				fileExists(root, "etc/pacman.d")
-	case PmXBPS:    return fileExists(root, "usr/share/xbps.d")
-	case PmFlatpak: return fileExists(root, "var/lib/flatpak")
-	case PmSnap:    return fileExists(root, "etc/snap")
-	default: return false
+	case PmAPT:     return []string { "etc/apt/sources.list", "etc/apt/sources.list.d" }
+	case PmAPK:     return []string { "etc/apk/repositories" }
+	case PmDNF:     return []string { "etc/yum.repos.d" }
+	case PmPacman:	return []string { "etc/pacman.conf", "etc/pacman.d" }
+	case PmXBPS:    return []string { "usr/share/xbps.d" }
+	case PmFlatpak: return []string { "var/lib/flatpak" }
+	case PmSnap:    return []string { "etc/snap" }
+	default: return nil
 	}
 }
 
@@ -55,7 +62,7 @@ func (pm PackageManager) String () string {
 // the root filesystem of the system being analyzed.
 func Detect (root fs.FS) []PackageManager {
 	var pms []PackageManager
-	for pm := PmAPT; pm < pmCap; pm ++ {
+	for _, pm := range All() {
 		if pm.ExistsOn(root) {
 			pms = append(pms, pm)
 		}
@@ -63,6 +70,15 @@ func Detect (root fs.FS) []PackageManager {
 	return pms
 }
 
+// All returns a list of every package manager that can be detected.
+func All () []PackageManager {
+	pms := make([]PackageManager, 0, pmCap)
+	for pm := PmAPT; pm < pmCap; pm ++ {
+		pms = append(pms, pm)
+	}
+	return pms
+}
+
 func fileExists (filesystem fs.FS, name string) bool {
 	file, err := filesystem.Open(name)
 	if err != nil { return false}
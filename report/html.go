@@ -0,0 +1,198 @@
+package report
+
+import "io"
+import "html/template"
+
+// WriteHTML writes the report as a self-contained HTML document, which does not
+// reference any external assets.
+func WriteHTML (output io.Writer, report *Report) error {
+	return htmlTemplate.Execute(output, report)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap {
+	"ecosystem": func (kind string) string {
+		if kind == "" { return "unknown" }
+		return kind
+	},
+}).Parse(htmlSource))
+
+const htmlSource = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Microscope report</title>
+<style>
+body {
+	font-family: sans-serif;
+	margin: 2em auto;
+	max-width: 80em;
+	padding: 0 1em;
+	color: #222;
+}
+h1, h2, h3 { font-weight: normal; }
+h2 {
+	border-bottom: 1px solid #ccc;
+	padding-bottom: 0.25em;
+	margin-top: 2em;
+}
+table {
+	border-collapse: collapse;
+	width: 100%;
+	margin-bottom: 1em;
+}
+th, td {
+	border: 1px solid #ddd;
+	padding: 0.25em 0.5em;
+	text-align: left;
+	vertical-align: top;
+}
+th {
+	background: #f0f0f0;
+	cursor: pointer;
+	user-select: none;
+}
+th[data-order="ascending"]::after  { content: " \25B2"; }
+th[data-order="descending"]::after { content: " \25BC"; }
+.hash { font-family: monospace; word-break: break-all; }
+.summary td:first-child { width: 12em; }
+.severity-critical { color: #fff; background: #a00; }
+.severity-high     { color: #fff; background: #d60; }
+.severity-medium   { background: #fd6; }
+.severity-low      { background: #eee; }
+.errors li { color: #a00; }
+.suppressed { color: #666; }
+.kind { color: #666; }
+</style>
+</head>
+<body>
+<h1>Microscope report</h1>
+
+<table class="summary">
+<tr><td>Targets</td><td>{{len .Targets}}</td></tr>
+<tr><td>Findings</td><td>{{.Findings}}</td></tr>
+<tr><td>Suppressed</td><td>{{.Suppressed}}</td></tr>
+<tr><td>Skipped files</td><td>{{.Skipped}}</td></tr>
+<tr><td>Errors</td><td>{{len .AllErrors}}</td></tr>
+</table>
+
+{{- with .Errors}}
+<h2>Errors</h2>
+<ul class="errors">
+{{- range .}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+
+{{- range .Targets}}
+{{- $layered := .Layered}}
+<h2><span class="kind">{{.Kind}}:</span> {{.Name}}</h2>
+
+{{- with .Errors}}
+<h3>Errors</h3>
+<ul class="errors">
+{{- range .}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+
+{{- with .Files}}
+<h3>Files</h3>
+<table class="sortable">
+<thead><tr><th>Path</th><th>SHA-256</th><th>Severity</th><th>Advisory</th><th>Source</th><th>Reason</th>{{if $layered}}<th>Layer</th>{{end}}</tr></thead>
+<tbody>
+{{- range .}}
+<tr><td>{{.Name}}</td><td class="hash">{{.Hash}}</td>{{template "severity" .}}{{template "advisory" .}}<td>{{.Source}}</td><td>{{.Reason}}</td>{{if $layered}}{{template "layer" .}}{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- range .PackageGroups}}
+<h3>Packages ({{ecosystem .Type}})</h3>
+<table class="sortable">
+<thead><tr><th>Package</th><th>Version</th><th>Release</th><th>Architecture</th><th>Package URL</th><th>Severity</th><th>Advisory</th><th>Source</th><th>Reason</th>{{if $layered}}<th>Layer</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Packages}}
+<tr><td>{{with .Package.Namespace}}{{.}}/{{end}}{{.Package.Name}}</td><td>{{with .Package.Epoch}}{{.}}:{{end}}{{.Package.Version}}</td><td>{{.Package.Release}}</td><td>{{.Package.Arch}}</td><td class="hash">{{.Package.PURL}}</td>{{template "severity" .}}{{template "advisory" .}}<td>{{.Source}}</td><td>{{.Reason}}</td>{{if $layered}}{{template "layer" .}}{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if or .SuppressedFiles .SuppressedPackages}}
+<h3>Suppressed</h3>
+<table class="sortable suppressed">
+<thead><tr><th>Finding</th><th>Severity</th><th>Source</th><th>Reason</th><th>Justification</th></tr></thead>
+<tbody>
+{{- range .SuppressedFiles}}
+<tr><td>{{.Name}} <span class="hash">{{.Hash}}</span></td>{{template "severity" .}}<td>{{.Source}}</td><td>{{.Reason}}</td><td>{{.Justification}}</td></tr>
+{{- end}}
+{{- range .SuppressedPackages}}
+<tr><td>{{.Package}}</td>{{template "severity" .}}<td>{{.Source}}</td><td>{{.Reason}}</td><td>{{.Justification}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- with .Skipped}}
+<h3>Skipped files</h3>
+<table class="sortable suppressed">
+<thead><tr><th>Path</th><th>Reason</th></tr></thead>
+<tbody>
+{{- range .}}
+<tr><td>{{.Name}}</td><td>{{.Reason}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+
+{{- if not (or .Files .Packages .SuppressedFiles .SuppressedPackages .Skipped .Errors)}}
+<p>No findings.</p>
+{{- end}}
+{{- end}}
+
+<script>
+function sortKey (cell) {
+	if (cell.dataset.sort !== undefined) return cell.dataset.sort;
+	return cell.textContent;
+}
+
+document.querySelectorAll("table.sortable").forEach(function (table) {
+	table.querySelectorAll("th").forEach(function (header, column) {
+		header.addEventListener("click", function () {
+			var ascending = header.dataset.order !== "ascending";
+			table.querySelectorAll("th").forEach(function (other) {
+				delete other.dataset.order;
+			});
+			header.dataset.order = ascending ? "ascending" : "descending";
+
+			var body = table.tBodies[0];
+			var rows = Array.prototype.slice.call(body.rows);
+			rows.sort(function (left, right) {
+				var a = sortKey(left.cells[column]);
+				var b = sortKey(right.cells[column]);
+				var result = a.localeCompare(b, undefined, { numeric: true });
+				return ascending ? result : -result;
+			});
+			rows.forEach(function (row) { body.appendChild(row); });
+		});
+	});
+});
+</script>
+</body>
+</html>
+{{- define "severity" -}}
+<td class="severity-{{.Severity}}" data-sort="{{printf "%d" .Severity}}{{printf "%05.1f" .Score}}">
+{{- .Severity}}{{with .Score}} ({{.}}){{end}}{{with .CVSS}}<br><span class="hash">{{.}}</span>{{end -}}
+</td>
+{{- end}}
+{{- define "advisory" -}}
+<td>{{if .URL}}<a href="{{.URL}}">{{or .Advisory .URL}}</a>{{else}}{{.Advisory}}{{end}}</td>
+{{- end}}
+{{- define "layer" -}}
+<td>{{.CreatedBy}}{{with .Layer}}<br><span class="hash">{{.}}</span>{{end}}</td>
+{{- end}}
+`
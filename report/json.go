@@ -0,0 +1,95 @@
+package report
+
+import "io"
+import "errors"
+import "encoding/json"
+import "github.com/ajblkf/microscope/binscan"
+import "github.com/ajblkf/microscope/pkgscan"
+
+type jsonReport struct {
+	Targets []jsonTarget `json:"targets"`
+	Errors  []string     `json:"errors,omitempty"`
+}
+
+type jsonTarget struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+
+	Files    []binscan.Vulnerability `json:"files,omitempty"`
+	Packages []pkgscan.Vulnerability `json:"packages,omitempty"`
+
+	SuppressedFiles    []SuppressedFile    `json:"suppressedFiles,omitempty"`
+	SuppressedPackages []SuppressedPackage `json:"suppressedPackages,omitempty"`
+
+	Skipped []binscan.SkippedFile `json:"skipped,omitempty"`
+
+	Errors []string `json:"errors,omitempty"`
+}
+
+// WriteJSON writes the report as a JSON document, which can be read back with
+// ReadJSON.
+func WriteJSON (output io.Writer, report *Report) error {
+	document := jsonReport {
+		Targets: make([]jsonTarget, len(report.Targets)),
+		Errors:  errorStrings(report.Errors),
+	}
+	for index, target := range report.Targets {
+		document.Targets[index] = jsonTarget {
+			Kind:               target.Kind,
+			Name:               target.Name,
+			Files:              target.Files,
+			Packages:           target.Packages,
+			SuppressedFiles:    target.SuppressedFiles,
+			SuppressedPackages: target.SuppressedPackages,
+			Skipped:            target.Skipped,
+			Errors:             errorStrings(target.Errors),
+		}
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(document)
+}
+
+// ReadJSON reads a report written by WriteJSON. Errors are read back as plain
+// error values containing their original message.
+func ReadJSON (input io.Reader) (*Report, error) {
+	document := jsonReport { }
+	err := json.NewDecoder(input).Decode(&document)
+	if err != nil { return nil, err }
+
+	report := &Report {
+		Errors: stringErrors(document.Errors),
+	}
+	for _, target := range document.Targets {
+		report.Targets = append(report.Targets, &Target {
+			Kind:               target.Kind,
+			Name:               target.Name,
+			Files:              target.Files,
+			Packages:           target.Packages,
+			SuppressedFiles:    target.SuppressedFiles,
+			SuppressedPackages: target.SuppressedPackages,
+			Skipped:            target.Skipped,
+			Errors:             stringErrors(target.Errors),
+		})
+	}
+	return report, nil
+}
+
+func errorStrings (errs []error) []string {
+	if len(errs) == 0 { return nil }
+	strings := make([]string, len(errs))
+	for index, err := range errs {
+		strings[index] = err.Error()
+	}
+	return strings
+}
+
+func stringErrors (strings []string) []error {
+	if len(strings) == 0 { return nil }
+	errs := make([]error, len(strings))
+	for index, message := range strings {
+		errs[index] = errors.New(message)
+	}
+	return errs
+}
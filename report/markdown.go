@@ -0,0 +1,180 @@
+package report
+
+import "io"
+import "fmt"
+import "sort"
+import "strings"
+import "github.com/ajblkf/microscope/severity"
+
+// WriteMarkdown writes a concise summary of the report as Markdown, suitable
+// for posting as a comment on a pull request.
+func WriteMarkdown (output io.Writer, report *Report) error {
+	writer := &markdownWriter { output: output }
+	errs := report.AllErrors()
+
+	writer.printf("## Microscope scan summary\n\n")
+	writer.printf (
+		"**%v** in %v, %v, %v, %v.\n\n",
+		plural(report.Findings(), "finding"),
+		plural(len(report.Targets), "target"),
+		plural(report.Suppressed(), "suppressed finding"),
+		plural(report.Skipped(), "skipped file"),
+		plural(len(errs), "error"))
+
+	levels := map[severity.Severity] int { }
+	for _, target := range report.Targets {
+		for _, vuln := range target.Files    { levels[vuln.Severity] ++ }
+		for _, vuln := range target.Packages { levels[vuln.Severity] ++ }
+	}
+	if len(levels) > 1 || (len(levels) == 1 && levels[severity.Unknown] == 0) {
+		var parts []string
+		for level := severity.Critical; level >= severity.Unknown; level -- {
+			if levels[level] == 0 { continue }
+			parts = append(parts, fmt.Sprint(levels[level], " ", level))
+		}
+		writer.printf("By severity: %v.\n\n", strings.Join(parts, ", "))
+	}
+
+	counts := map[string] int { }
+	for _, target := range report.Targets {
+		for _, vuln := range target.Files    { counts[vuln.Source] ++ }
+		for _, vuln := range target.Packages { counts[vuln.Source] ++ }
+	}
+	if len(counts) > 0 {
+		sources := make([]string, 0, len(counts))
+		for source := range counts {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		writer.printf("| Source | Findings |\n|---|---:|\n")
+		for _, source := range sources {
+			writer.printf("| %v | %v |\n", markdownCell(source), counts[source])
+		}
+		writer.printf("\n")
+	}
+
+	for _, target := range report.Targets {
+		writer.printf (
+			"### %v: %v\n\n",
+			markdownCell(target.Kind), markdownCell(target.Name))
+		if target.Findings() == 0 {
+			writer.printf("No findings.\n\n")
+			continue
+		}
+
+		layered := target.Layered()
+		layer := func (digest, createdBy string) string {
+			if !layered { return "" }
+			return " " + markdownLayer(digest, createdBy) + " |"
+		}
+		if layered {
+			writer.printf (
+				"| Finding | Severity | Advisory | Reason | Source | Layer |\n" +
+				"|---|---|---|---|---|---|\n")
+		} else {
+			writer.printf (
+				"| Finding | Severity | Advisory | Reason | Source |\n" +
+				"|---|---|---|---|---|\n")
+		}
+		for _, vuln := range target.Files {
+			writer.printf (
+				"| `%v` (`%v`) | %v | %v | %v | %v |%v\n",
+				markdownCode(vuln.Name), shortHash(vuln.Hash),
+				markdownSeverity(vuln.Severity, vuln.Score),
+				markdownAdvisory(vuln.Advisory, vuln.URL),
+				markdownCell(vuln.Reason), markdownCell(vuln.Source),
+				layer(vuln.Layer, vuln.CreatedBy))
+		}
+		for _, vuln := range target.Packages {
+			writer.printf (
+				"| `%v` | %v | %v | %v | %v |%v\n",
+				markdownCode(vuln.Package.String()),
+				markdownSeverity(vuln.Severity, vuln.Score),
+				markdownAdvisory(vuln.Advisory, vuln.URL),
+				markdownCell(vuln.Reason), markdownCell(vuln.Source),
+				layer(vuln.Layer, vuln.CreatedBy))
+		}
+		writer.printf("\n")
+	}
+
+	if len(errs) > 0 {
+		writer.printf (
+			"<details>\n<summary>%v</summary>\n\n",
+			plural(len(errs), "error"))
+		for _, err := range errs {
+			writer.printf("- `%v`\n", markdownCode(err.Error()))
+		}
+		writer.printf("\n</details>\n")
+	}
+
+	if report.Skipped() > 0 {
+		writer.printf (
+			"\n<details>\n<summary>%v</summary>\n\n",
+			plural(report.Skipped(), "skipped file"))
+		for _, target := range report.Targets {
+			for _, file := range target.Skipped {
+				writer.printf (
+					"- %v: `%v`: %v\n",
+					markdownCell(target.Name), markdownCode(file.Name),
+					markdownCell(file.Reason))
+			}
+		}
+		writer.printf("\n</details>\n")
+	}
+
+	return writer.err
+}
+
+type markdownWriter struct {
+	output io.Writer
+	err    error
+}
+
+func (this *markdownWriter) printf (format string, values ...any) {
+	if this.err != nil { return }
+	_, this.err = fmt.Fprintf(this.output, format, values...)
+}
+
+func plural (count int, noun string) string {
+	if count == 1 { return fmt.Sprint(count, " ", noun) }
+	return fmt.Sprint(count, " ", noun, "s")
+}
+
+func shortHash (hash string) string {
+	if len(hash) > 12 { return hash[:12] }
+	return hash
+}
+
+func markdownCell (text string) string {
+	text = strings.TrimSpace(text)
+	text = strings.ReplaceAll(text, "\\", "\\\\")
+	text = strings.ReplaceAll(text, "|", "\\|")
+	text = strings.ReplaceAll(text, "<", "&lt;")
+	text = strings.ReplaceAll(text, "\n", " ")
+	return text
+}
+
+func markdownSeverity (level severity.Severity, score float64) string {
+	if score == 0 { return level.String() }
+	return fmt.Sprintf("%v (%v)", level, score)
+}
+
+func markdownAdvisory (advisory, url string) string {
+	if url == "" { return markdownCell(advisory) }
+	if advisory == "" { advisory = url }
+	return fmt.Sprintf("[%v](%v)", markdownCell(advisory), markdownCell(url))
+}
+
+func markdownLayer (digest, createdBy string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if digest == "" { return markdownCell(createdBy) }
+	if createdBy == "" { return fmt.Sprintf("`%v`", shortHash(digest)) }
+	return fmt.Sprintf("`%v` `%v`", shortHash(digest), markdownCode(createdBy))
+}
+
+func markdownCode (text string) string {
+	text = strings.ReplaceAll(text, "`", "'")
+	text = strings.ReplaceAll(text, "|", "\\|")
+	text = strings.ReplaceAll(text, "\n", " ")
+	return text
+}
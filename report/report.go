@@ -0,0 +1,282 @@
+package report
+
+import "io"
+import "fmt"
+import "sort"
+import "github.com/ajblkf/microscope/binscan"
+import "github.com/ajblkf/microscope/pkgscan"
+import "github.com/ajblkf/microscope/severity"
+
+// Report holds the results of a microscope run.
+type Report struct {
+	Targets []*Target
+	// Errors that did not occur while scanning a particular target
+	Errors  []error
+}
+
+// Target holds the results of scanning a single container, archive, directory,
+// project, etc.
+type Target struct {
+	// What kind of scan was performed, such as "files" or "packages"
+	Kind string
+	// The name of the thing that was scanned
+	Name string
+
+	Files    []binscan.Vulnerability
+	Packages []pkgscan.Vulnerability
+
+	SuppressedFiles    []SuppressedFile
+	SuppressedPackages []SuppressedPackage
+
+	// Files which could not be checked
+	Skipped []binscan.SkippedFile
+
+	Errors []error
+}
+
+// SuppressedFile is a file finding that has been suppressed.
+type SuppressedFile struct {
+	binscan.Vulnerability
+	// Why the finding was suppressed
+	Justification string `json:"justification"`
+}
+
+// SuppressedPackage is a package finding that has been suppressed.
+type SuppressedPackage struct {
+	pkgscan.Vulnerability
+	// Why the finding was suppressed
+	Justification string `json:"justification"`
+}
+
+// Suppressor decides whether findings have been reviewed and should not be
+// reported as vulnerabilities.
+type Suppressor interface {
+	SuppressFile    (binscan.Vulnerability) (justification string, suppressed bool)
+	SuppressPackage (pkgscan.Vulnerability) (justification string, suppressed bool)
+}
+
+// NewTarget adds a new target to the report and returns it.
+func (this *Report) NewTarget (kind, name string) *Target {
+	target := &Target {
+		Kind: kind,
+		Name: name,
+	}
+	this.Targets = append(this.Targets, target)
+	return target
+}
+
+// Merge appends the targets and errors of another report to the report.
+func (this *Report) Merge (other *Report) {
+	this.Targets = append(this.Targets, other.Targets...)
+	this.Errors  = append(this.Errors,  other.Errors...)
+}
+
+// AddError adds an error to the report if it is not nil.
+func (this *Report) AddError (err error) {
+	if err == nil { return }
+	this.Errors = append(this.Errors, err)
+}
+
+// Suppress moves all findings in the report that are suppressed by the given
+// suppressor into the suppressed lists of their targets.
+func (this *Report) Suppress (suppressor Suppressor) {
+	for _, target := range this.Targets {
+		target.Suppress(suppressor)
+	}
+}
+
+// AllErrors returns all errors in the report, including those of targets,
+// which are prefixed with the name of their target.
+func (this *Report) AllErrors () []error {
+	errs := append([]error(nil), this.Errors...)
+	for _, target := range this.Targets {
+		for _, err := range target.Errors {
+			errs = append(errs, fmt.Errorf("%v: %w", target.Name, err))
+		}
+	}
+	return errs
+}
+
+// Findings returns the total number of unsuppressed findings in the report.
+func (this *Report) Findings () int {
+	count := 0
+	for _, target := range this.Targets {
+		count += target.Findings()
+	}
+	return count
+}
+
+// FindingsAtLeast returns the number of unsuppressed findings in the report
+// that are at least as severe as the given threshold, including those of
+// unknown severity.
+func (this *Report) FindingsAtLeast (threshold severity.Severity) int {
+	count := 0
+	for _, target := range this.Targets {
+		for _, vuln := range target.Files {
+			if vuln.Severity.AtLeast(threshold) { count ++ }
+		}
+		for _, vuln := range target.Packages {
+			if vuln.Severity.AtLeast(threshold) { count ++ }
+		}
+	}
+	return count
+}
+
+// Filter removes all unsuppressed findings in the report that are less severe
+// than the given threshold. Findings of unknown severity are kept.
+func (this *Report) Filter (threshold severity.Severity) {
+	for _, target := range this.Targets {
+		target.Filter(threshold)
+	}
+}
+
+// Skipped returns the total number of files in the report which could not be
+// checked.
+func (this *Report) Skipped () int {
+	count := 0
+	for _, target := range this.Targets {
+		count += len(target.Skipped)
+	}
+	return count
+}
+
+// Suppressed returns the total number of suppressed findings in the report.
+func (this *Report) Suppressed () int {
+	count := 0
+	for _, target := range this.Targets {
+		count += len(target.SuppressedFiles) + len(target.SuppressedPackages)
+	}
+	return count
+}
+
+// AddFiles adds file findings to the target.
+func (this *Target) AddFiles (vulns ...binscan.Vulnerability) {
+	this.Files = append(this.Files, vulns...)
+}
+
+// AddPackages adds package findings to the target.
+func (this *Target) AddPackages (vulns ...pkgscan.Vulnerability) {
+	this.Packages = append(this.Packages, vulns...)
+}
+
+// AddSkipped adds files which could not be checked to the target.
+func (this *Target) AddSkipped (files ...binscan.SkippedFile) {
+	this.Skipped = append(this.Skipped, files...)
+}
+
+// AddError adds an error to the target if it is not nil.
+func (this *Target) AddError (err error) {
+	if err == nil { return }
+	this.Errors = append(this.Errors, err)
+}
+
+// Findings returns the number of unsuppressed findings in the target.
+func (this *Target) Findings () int {
+	return len(this.Files) + len(this.Packages)
+}
+
+// Layered returns whether any unsuppressed finding in the target is attributed
+// to a layer of an image.
+func (this *Target) Layered () bool {
+	for _, vuln := range this.Files {
+		if vuln.Layer != "" { return true }
+	}
+	for _, vuln := range this.Packages {
+		if vuln.Layer != "" { return true }
+	}
+	return false
+}
+
+// Suppress moves all findings in the target that are suppressed by the given
+// suppressor into its suppressed lists.
+func (this *Target) Suppress (suppressor Suppressor) {
+	files := this.Files[:0]
+	for _, vuln := range this.Files {
+		justification, suppressed := suppressor.SuppressFile(vuln)
+		if suppressed {
+			this.SuppressedFiles = append(this.SuppressedFiles, SuppressedFile {
+				Vulnerability: vuln,
+				Justification: justification,
+			})
+		} else {
+			files = append(files, vuln)
+		}
+	}
+	this.Files = files
+
+	packages := this.Packages[:0]
+	for _, vuln := range this.Packages {
+		justification, suppressed := suppressor.SuppressPackage(vuln)
+		if suppressed {
+			this.SuppressedPackages = append(this.SuppressedPackages, SuppressedPackage {
+				Vulnerability: vuln,
+				Justification: justification,
+			})
+		} else {
+			packages = append(packages, vuln)
+		}
+	}
+	this.Packages = packages
+}
+
+// Filter removes all unsuppressed findings in the target that are less severe
+// than the given threshold. Findings of unknown severity are kept.
+func (this *Target) Filter (threshold severity.Severity) {
+	files := this.Files[:0]
+	for _, vuln := range this.Files {
+		if vuln.Severity.AtLeast(threshold) { files = append(files, vuln) }
+	}
+	this.Files = files
+
+	packages := this.Packages[:0]
+	for _, vuln := range this.Packages {
+		if vuln.Severity.AtLeast(threshold) { packages = append(packages, vuln) }
+	}
+	this.Packages = packages
+}
+
+// PackageGroup is a list of package findings belonging to the same ecosystem.
+type PackageGroup struct {
+	// The package URL type of the packages, or an empty string if unknown
+	Type     string
+	Packages []pkgscan.Vulnerability
+}
+
+// PackageGroups returns the package findings of the target grouped by
+// ecosystem, sorted by type.
+func (this *Target) PackageGroups () []PackageGroup {
+	groups := map[string] []pkgscan.Vulnerability { }
+	for _, vuln := range this.Packages {
+		groups[vuln.Package.Type] = append(groups[vuln.Package.Type], vuln)
+	}
+
+	list := make([]PackageGroup, 0, len(groups))
+	for kind, packages := range groups {
+		list = append(list, PackageGroup {
+			Type:     kind,
+			Packages: packages,
+		})
+	}
+	sort.Slice(list, func (left, right int) bool {
+		return list[left].Type < list[right].Type
+	})
+	return list
+}
+
+// WriteText writes each finding in the report as a line of text, in the same
+// format as their String methods.
+func WriteText (output io.Writer, report *Report) error {
+	for _, target := range report.Targets {
+		for _, vulnerability := range target.Files {
+			_, err := fmt.Fprintln(output, vulnerability)
+			if err != nil { return err }
+		}
+	}
+	for _, target := range report.Targets {
+		for _, vulnerability := range target.Packages {
+			_, err := fmt.Fprintln(output, vulnerability)
+			if err != nil { return err }
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package report
+
+import "io"
+import "fmt"
+import "strings"
+import "encoding/csv"
+import "encoding/json"
+import "text/template"
+import "github.com/ajblkf/microscope/binscan"
+import "github.com/ajblkf/microscope/pkgscan"
+
+// FileFinding is a file finding along with the target it was found in.
+type FileFinding struct {
+	binscan.Vulnerability
+	Target *Target
+}
+
+// PackageFinding is a package finding along with the target it was found in.
+type PackageFinding struct {
+	pkgscan.Vulnerability
+	Target *Target
+}
+
+// AllFiles returns every unsuppressed file finding in the report.
+func (this *Report) AllFiles () []FileFinding {
+	var findings []FileFinding
+	for _, target := range this.Targets {
+		for _, vuln := range target.Files {
+			findings = append(findings, FileFinding {
+				Vulnerability: vuln,
+				Target:        target,
+			})
+		}
+	}
+	return findings
+}
+
+// AllPackages returns every unsuppressed package finding in the report.
+func (this *Report) AllPackages () []PackageFinding {
+	var findings []PackageFinding
+	for _, target := range this.Targets {
+		for _, vuln := range target.Packages {
+			findings = append(findings, PackageFinding {
+				Vulnerability: vuln,
+				Target:        target,
+			})
+		}
+	}
+	return findings
+}
+
+// TemplateFuncs are the helper functions available to templates parsed by
+// ParseTemplate.
+var TemplateFuncs = template.FuncMap {
+	"csv":       csvRecord,
+	"json":      jsonValue,
+	"join":      strings.Join,
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"trim":      strings.TrimSpace,
+	"replace":   strings.ReplaceAll,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"shortHash": shortHash,
+	"plural":    plural,
+}
+
+// ParseTemplate parses a text/template with access to TemplateFuncs. The
+// template is executed with a *Report by WriteTemplate.
+func ParseTemplate (name, source string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Parse(source)
+}
+
+// WriteTemplate renders the report through the given template.
+func WriteTemplate (output io.Writer, tmpl *template.Template, report *Report) error {
+	return tmpl.Execute(output, report)
+}
+
+// csvRecord formats its arguments as a single CSV record, without a trailing
+// newline.
+func csvRecord (fields ...any) (string, error) {
+	record := make([]string, len(fields))
+	for index, field := range fields {
+		record[index] = fmt.Sprint(field)
+	}
+
+	builder := strings.Builder { }
+	writer := csv.NewWriter(&builder)
+	err := writer.Write(record)
+	if err != nil { return "", err }
+	writer.Flush()
+	return strings.TrimSuffix(builder.String(), "\n"), writer.Error()
+}
+
+func jsonValue (value any) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
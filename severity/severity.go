@@ -0,0 +1,74 @@
+package severity
+
+import "fmt"
+import "errors"
+import "strings"
+
+// Severity represents how severe a vulnerability is. The zero value is
+// Unknown, which is used for vulnerabilities that have not been rated.
+type Severity int; const (
+	Unknown Severity = iota
+	None
+	Low
+	Medium
+	High
+	Critical
+)
+
+// Parse parses a severity from its name. The name is not case sensitive.
+func Parse (name string) (Severity, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "unknown": return Unknown, nil
+	case "none":        return None, nil
+	case "low":         return Low, nil
+	case "medium":      return Medium, nil
+	case "high":        return High, nil
+	case "critical":    return Critical, nil
+	default:
+		return Unknown, errors.New(fmt.Sprint("unknown severity ", name))
+	}
+}
+
+// FromScore returns the severity corresponding to a CVSS base score, using the
+// qualitative ratings of CVSS v3.
+func FromScore (score float64) Severity {
+	switch {
+	case score <  0:   return Unknown
+	case score == 0:   return None
+	case score <  4:   return Low
+	case score <  7:   return Medium
+	case score <  9:   return High
+	case score <= 10:  return Critical
+	default:           return Unknown
+	}
+}
+
+func (this Severity) String () string {
+	switch this {
+	case Unknown:  return "unknown"
+	case None:     return "none"
+	case Low:      return "low"
+	case Medium:   return "medium"
+	case High:     return "high"
+	case Critical: return "critical"
+	default: return fmt.Sprintf("severity.Severity(%d)", int(this))
+	}
+}
+
+// AtLeast returns whether the severity is at or above a threshold. Unknown
+// severities are always considered to be above the threshold, as there is no
+// way to tell otherwise.
+func (this Severity) AtLeast (threshold Severity) bool {
+	return this == Unknown || this >= threshold
+}
+
+func (this Severity) MarshalText () ([]byte, error) {
+	return []byte(this.String()), nil
+}
+
+func (this *Severity) UnmarshalText (text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil { return err }
+	*this = parsed
+	return nil
+}